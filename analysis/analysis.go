@@ -0,0 +1,121 @@
+// Package analysis provides static analyses over builder.Function bodies:
+// shared-memory bank conflicts, global coalescing, warp uniformity and
+// protocol checkers for the asynchronous copy and tensor core instructions.
+package analysis
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// WarpSize is the number of lanes in a warp.
+const WarpSize = 32
+
+// Launch describes the concrete launch geometry a kernel is analyzed under.
+// Zero dimensions are treated as 1.
+type Launch struct {
+	Block [3]int // %ntid.x, %ntid.y, %ntid.z
+}
+
+// dims returns the block dimensions with zero entries replaced by 1.
+func (l Launch) dims() (x, y, z int) {
+	x, y, z = l.Block[0], l.Block[1], l.Block[2]
+	if x <= 0 {
+		x = 1
+	}
+	if y <= 0 {
+		y = 1
+	}
+	if z <= 0 {
+		z = 1
+	}
+	return x, y, z
+}
+
+// Threads returns the number of threads in one CTA.
+func (l Launch) Threads() int {
+	x, y, z := l.dims()
+	return x * y * z
+}
+
+// Warps returns the number of warps in one CTA.
+func (l Launch) Warps() int {
+	return (l.Threads() + WarpSize - 1) / WarpSize
+}
+
+// Site locates an instruction within a function.
+type Site struct {
+	Func  *builder.Function
+	Block int // index into Func.Blocks
+	Index int // index into the block's Instructions
+}
+
+// Inst returns the instruction at this site, or nil for the fall-through
+// position at the end of a block.
+func (s Site) Inst() *builder.Instruction {
+	insts := s.Func.Blocks[s.Block].Instructions
+	if s.Index < 0 || s.Index >= len(insts) {
+		return nil
+	}
+	return insts[s.Index]
+}
+
+// pseudo reports whether inst is a comment, scope brace or declaration.
+// The analyses skip these: declaring a register does not define it.
+func pseudo(inst *builder.Instruction) bool {
+	switch inst.Op {
+	case ptx.OpComment, ptx.OpScopeBegin, ptx.OpScopeEnd, ptx.OpDecl:
+		return true
+	}
+	return false
+}
+
+// String formats the site as func/label#index.
+func (s Site) String() string {
+	label := s.Func.Blocks[s.Block].Label
+	if label == "" {
+		label = fmt.Sprintf("block%d", s.Block)
+	}
+	return fmt.Sprintf("%s/%s#%d", s.Func.Name, label, s.Index)
+}
+
+// sortedSites returns the keys of m in program order.
+func sortedSites[V any](m map[Site]V) []Site {
+	sites := make([]Site, 0, len(m))
+	for s := range m {
+		sites = append(sites, s)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].Block != sites[j].Block {
+			return sites[i].Block < sites[j].Block
+		}
+		return sites[i].Index < sites[j].Index
+	})
+	return sites
+}
+
+// Finding is a problem reported by one of the checkers.
+type Finding struct {
+	Site
+	Message string
+}
+
+// String formats the finding as site: message.
+func (f Finding) String() string {
+	return f.Site.String() + ": " + f.Message
+}
+
+// sortFindings orders findings by site, keeping the order of findings at
+// the same site.
+func sortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i].Site, findings[j].Site
+		if a.Block != b.Block {
+			return a.Block < b.Block
+		}
+		return a.Index < b.Index
+	})
+}
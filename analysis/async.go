@@ -0,0 +1,588 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+const (
+	maxGroupPaths = 16 // distinct in-flight states kept per program point
+	maxGroups     = 8  // committed groups kept per path; older ones are merged
+)
+
+// AsyncGroups checks the commit/wait protocol of cp.async and of bulk
+// copies that complete through bulk async-groups (cp.async.bulk,
+// cp.async.bulk.tensor and cp.reduce.async.bulk from shared to global
+// memory).
+//
+// Every path through f is followed with the copies issued but not yet
+// committed and the committed groups not yet waited for. It reports:
+//
+//   - shared-memory reads that may overlap a cp.async destination, and
+//     shared-memory writes that may overlap a bulk copy's source, before a
+//     wait_group covers the copy;
+//   - copies still uncommitted at a wait_group, which it does not wait for;
+//   - wait_group depths that never block because fewer groups are ever
+//     pending, and depths that wait for more groups than any later access
+//     needs;
+//   - copies a kernel never waits for before it exits.
+//
+// Only accesses made by the same thread are considered. Two shared
+// addresses may overlap unless they are derived from different shared
+// variables.
+func AsyncGroups(f *builder.Function) []Finding {
+	g := NewCFG(f)
+	roots := newPointerRoots(f)
+	var findings []Finding
+	for _, fam := range []*groupFamily{cpAsyncFamily, bulkGroupFamily} {
+		findings = append(findings, fam.check(g, roots)...)
+	}
+	sortFindings(findings)
+	return findings
+}
+
+// groupFamily is one kind of asynchronous copy tracked through commit and
+// wait groups.
+type groupFamily struct {
+	copy       func(*builder.Instruction) (builder.Operand, bool)               // the shared operand a copy uses
+	conflict   func(*builder.Instruction, pointerRoots) (builder.Operand, bool) // accesses that must wait for it
+	commit     ptx.Opcode
+	wait       ptx.Opcode
+	waitAll    ptx.Opcode
+	hasWaitAll bool
+	uses       string // how a copy uses its shared operand
+	access     string // what a conflicting access does to it
+}
+
+var cpAsyncFamily = &groupFamily{
+	copy: func(inst *builder.Instruction) (builder.Operand, bool) {
+		if inst.Op != ptx.OpCpAsync || len(inst.Src) == 0 {
+			return nil, false
+		}
+		return inst.Src[0], true
+	},
+	conflict:   sharedRead,
+	commit:     ptx.OpCpAsyncCommitGroup,
+	wait:       ptx.OpCpAsyncWaitGroup,
+	waitAll:    ptx.OpCpAsyncWaitAll,
+	hasWaitAll: true,
+	uses:       "written",
+	access:     "reads",
+}
+
+var bulkGroupFamily = &groupFamily{
+	copy:     bulkGroupSource,
+	conflict: sharedWrite,
+	commit:   ptx.OpCpAsyncBulkCommitGroup,
+	wait:     ptx.OpCpAsyncBulkWaitGroup,
+	uses:     "read",
+	access:   "overwrites",
+}
+
+// bulkGroupSource reports whether inst is a bulk copy that completes
+// through a bulk async-group, and returns its shared source (nil if it
+// cannot be told apart from the other operands).
+func bulkGroupSource(inst *builder.Instruction) (builder.Operand, bool) {
+	switch inst.Op {
+	case ptx.OpCpAsyncBulk, ptx.OpCpReduceAsyncBulk, ptx.OpCpAsyncBulkTensor, ptx.OpCpReduceAsyncBulkTensor:
+	default:
+		return nil, false
+	}
+	bulk := inst.Op == ptx.OpCpReduceAsyncBulkTensor
+	for _, m := range inst.Modifiers {
+		if m == ptx.ModBulkGroup {
+			bulk = true
+		}
+	}
+	// The first state space modifier is the destination; copies to global
+	// memory always complete through bulk groups.
+	for _, m := range inst.Modifiers {
+		if m == ptx.ModSpaceGlobal {
+			bulk = true
+		}
+		if m == ptx.ModSpaceGlobal || m == ptx.ModSpaceShared || m == ptx.ModSpaceSharedCTA || m == ptx.ModSpaceSharedCluster {
+			break
+		}
+	}
+	if !bulk {
+		return nil, false
+	}
+	switch inst.Op {
+	case ptx.OpCpAsyncBulk, ptx.OpCpReduceAsyncBulk:
+		if len(inst.Src) > 1 {
+			return inst.Src[1], true
+		}
+	case ptx.OpCpReduceAsyncBulkTensor:
+		return inst.Src[len(inst.Src)-1], true
+	}
+	return nil, true
+}
+
+// sharedRead returns the shared address inst reads; nil stands for shared
+// memory read implicitly.
+func sharedRead(inst *builder.Instruction, roots pointerRoots) (builder.Operand, bool) {
+	switch inst.Op {
+	case ptx.OpLd, ptx.OpLdu, ptx.OpAtom, ptx.OpRed:
+		return sharedAddress(inst, roots)
+	case ptx.OpLdMatrix:
+		if len(inst.Src) > 0 {
+			return inst.Src[0], true
+		}
+	case ptx.OpWgmmaMmaAsync, ptx.OpTcgen05Mma, ptx.OpTcgen05Cp:
+		return nil, true
+	}
+	return bulkGroupSource(inst)
+}
+
+// sharedWrite returns the shared address inst writes.
+func sharedWrite(inst *builder.Instruction, roots pointerRoots) (builder.Operand, bool) {
+	switch inst.Op {
+	case ptx.OpSt, ptx.OpAtom, ptx.OpRed:
+		return sharedAddress(inst, roots)
+	case ptx.OpStMatrix, ptx.OpCpAsync:
+		if len(inst.Src) > 0 {
+			return inst.Src[0], true
+		}
+	}
+	return nil, false
+}
+
+// sharedAddress returns the address of an ld, st or atomic that accesses
+// shared memory, either explicitly or through a generic address derived
+// from a symbol.
+func sharedAddress(inst *builder.Instruction, roots pointerRoots) (builder.Operand, bool) {
+	if len(inst.Src) == 0 {
+		return nil, false
+	}
+	if isSharedSpace(inst.Space) || (inst.Space == ptx.Reg && len(roots.of(inst.Src[0])) > 0) {
+		return inst.Src[0], true
+	}
+	return nil, false
+}
+
+func isSharedSpace(s ptx.StateSpace) bool {
+	return s == ptx.Shared || s == ptx.SharedCTA || s == ptx.SharedCluster
+}
+
+// groupPath is one path's view of the copies in flight, as indices into
+// groupChecker.copies. Paths are never modified once built.
+type groupPath struct {
+	open   []int   // issued since the last commit
+	groups [][]int // committed and not yet waited for, oldest first
+}
+
+func (p groupPath) key() string {
+	var sb strings.Builder
+	fmt.Fprint(&sb, p.open)
+	for _, grp := range p.groups {
+		fmt.Fprint(&sb, grp)
+	}
+	return sb.String()
+}
+
+// pending returns every copy the path has in flight.
+func (p groupPath) pending() []int {
+	all := p.open
+	for _, grp := range p.groups {
+		all = unionInts(all, grp)
+	}
+	return all
+}
+
+// groupChecker follows one groupFamily through a function.
+type groupChecker struct {
+	fam    *groupFamily
+	roots  pointerRoots
+	copies []Site
+	index  map[Site]int
+	depth  map[Site]int64 // wait_group depths overridden while probing
+}
+
+func (c *groupChecker) entry() []groupPath { return []groupPath{{}} }
+
+func (c *groupChecker) clone(s []groupPath) []groupPath { return append([]groupPath(nil), s...) }
+
+func (c *groupChecker) join(a, b []groupPath, _ int) ([]groupPath, bool) {
+	out := canonicalPaths(append(c.clone(a), b...))
+	if len(out) != len(a) {
+		return out, true
+	}
+	for i := range out {
+		if out[i].key() != a[i].key() {
+			return out, true
+		}
+	}
+	return a, false
+}
+
+func (c *groupChecker) transfer(s []groupPath, site Site) []groupPath {
+	inst := site.Inst()
+	var out []groupPath
+	for _, p := range s {
+		var next groupPath
+		switch {
+		case c.isCopy(inst):
+			out = append(out, groupPath{open: unionInts(p.open, []int{c.index[site]}), groups: p.groups})
+			continue
+		case inst.Op == c.fam.commit:
+			next = groupPath{groups: appendGroup(p.groups, p.open)}
+		case c.fam.hasWaitAll && inst.Op == c.fam.waitAll:
+			next = groupPath{}
+		case inst.Op == c.fam.wait:
+			n := int(c.waitDepth(site))
+			next = groupPath{open: p.open, groups: p.groups}
+			if len(p.groups) > n {
+				next.groups = p.groups[len(p.groups)-n:]
+			}
+		default:
+			out = append(out, p)
+			continue
+		}
+		if inst.Guard != nil {
+			out = append(out, p)
+		}
+		out = append(out, next)
+	}
+	return canonicalPaths(out)
+}
+
+func (c *groupChecker) isCopy(inst *builder.Instruction) bool {
+	_, ok := c.fam.copy(inst)
+	return ok
+}
+
+// waitDepth returns the number of groups a wait_group leaves pending.
+func (c *groupChecker) waitDepth(site Site) int64 {
+	if n, ok := c.depth[site]; ok {
+		return n
+	}
+	inst := site.Inst()
+	if len(inst.Src) > 0 {
+		if n, ok := immInt(inst.Src[0]); ok && n >= 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// appendGroup commits open as a new group, merging the oldest groups once
+// more than maxGroups are pending.
+func appendGroup(groups [][]int, open []int) [][]int {
+	out := append(append([][]int(nil), groups...), open)
+	for len(out) > maxGroups {
+		out = append([][]int{unionInts(out[0], out[1])}, out[2:]...)
+	}
+	return out
+}
+
+// canonicalPaths removes duplicate paths and orders them by key. Beyond
+// maxGroupPaths, paths with the same number of pending groups are merged.
+func canonicalPaths(paths []groupPath) []groupPath {
+	byKey := make(map[string]groupPath)
+	for _, p := range paths {
+		byKey[p.key()] = p
+	}
+	if len(byKey) > maxGroupPaths {
+		merged := make(map[int]groupPath)
+		for _, p := range byKey {
+			m, ok := merged[len(p.groups)]
+			if !ok {
+				merged[len(p.groups)] = p
+				continue
+			}
+			groups := make([][]int, len(p.groups))
+			for i := range groups {
+				groups[i] = unionInts(m.groups[i], p.groups[i])
+			}
+			merged[len(p.groups)] = groupPath{open: unionInts(m.open, p.open), groups: groups}
+		}
+		byKey = make(map[string]groupPath)
+		for _, p := range merged {
+			byKey[p.key()] = p
+		}
+	}
+	keys := make([]string, 0, len(byKey))
+	for k := range byKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]groupPath, len(keys))
+	for i, k := range keys {
+		out[i] = byKey[k]
+	}
+	return out
+}
+
+// waitInfo summarizes the paths reaching one wait_group.
+type waitInfo struct {
+	pending     int          // most groups pending on any path
+	uncommitted map[int]bool // copies not yet committed on some path
+}
+
+// run solves c over g and returns the hazards found, keyed so that runs
+// with different wait depths can be compared, and what reaches each wait.
+func (c *groupChecker) run(g *CFG) (map[string]Finding, map[Site]*waitInfo) {
+	hazards := make(map[string]Finding)
+	waits := make(map[Site]*waitInfo)
+	in, reached := solve[[]groupPath](g, c)
+
+	visit := func(site Site, s []groupPath) {
+		inst := site.Inst()
+		if inst.Op == c.fam.wait || (c.fam.hasWaitAll && inst.Op == c.fam.waitAll) {
+			w := waits[site]
+			if w == nil {
+				w = &waitInfo{uncommitted: make(map[int]bool)}
+				waits[site] = w
+			}
+			for _, p := range s {
+				w.pending = max(w.pending, len(p.groups))
+				if inst.Op == c.fam.wait {
+					for _, i := range p.open {
+						w.uncommitted[i] = true
+					}
+				}
+			}
+		}
+		addr, ok := c.fam.conflict(inst, c.roots)
+		if !ok {
+			return
+		}
+		for _, p := range s {
+			for _, i := range p.pending() {
+				copied, _ := c.fam.copy(c.copies[i].Inst())
+				if c.copies[i] == site || !c.roots.mayAlias(copied, addr) {
+					continue
+				}
+				hazards[fmt.Sprintf("%v|%d", site, i)] = Finding{Site: site, Message: fmt.Sprintf(
+					"%s %s shared memory %s by %s at %s before %s covers it",
+					inst.Op, c.fam.access, c.fam.uses, c.copies[i].Inst().Op, c.copies[i], c.fam.wait)}
+			}
+		}
+	}
+	edge := func(site Site, to int, s []groupPath) {
+		if to != Exit || !g.Func.IsKernel {
+			return
+		}
+		for _, p := range s {
+			for _, i := range p.pending() {
+				hazards[fmt.Sprintf("exit|%d", i)] = Finding{Site: c.copies[i], Message: fmt.Sprintf(
+					"%s is never waited for before the kernel exits", c.copies[i].Inst().Op)}
+			}
+		}
+	}
+	replay[[]groupPath](g, c, in, reached, visit, edge)
+	return hazards, waits
+}
+
+func (fam *groupFamily) check(g *CFG, roots pointerRoots) []Finding {
+	c := &groupChecker{fam: fam, roots: roots, index: make(map[Site]int)}
+	for b, bb := range g.Func.Blocks {
+		for i, inst := range bb.Instructions {
+			if _, ok := fam.copy(inst); ok {
+				site := Site{Func: g.Func, Block: b, Index: i}
+				c.index[site] = len(c.copies)
+				c.copies = append(c.copies, site)
+			}
+		}
+	}
+	if len(c.copies) == 0 {
+		return nil
+	}
+
+	hazards, waits := c.run(g)
+	var findings []Finding
+	for _, h := range hazards {
+		findings = append(findings, h)
+	}
+	for _, site := range sortedSites(waits) {
+		w := waits[site]
+		for _, i := range sortedKeys(w.uncommitted) {
+			findings = append(findings, Finding{Site: site, Message: fmt.Sprintf(
+				"%s at %s is not committed, so %s does not wait for it", c.copies[i].Inst().Op, c.copies[i], fam.wait)})
+		}
+		if site.Inst().Op != fam.wait {
+			continue
+		}
+		n := c.waitDepth(site)
+		if int64(w.pending) <= n {
+			findings = append(findings, Finding{Site: site, Message: fmt.Sprintf(
+				"%s %d never blocks: at most %d group(s) pending", fam.wait, n, w.pending)})
+			continue
+		}
+		// Probe shallower waits, deepest first, for one that uncovers no
+		// new hazard.
+		for relaxed := int64(w.pending); relaxed > n; relaxed-- {
+			c.depth = map[Site]int64{site: relaxed}
+			probe, _ := c.run(g)
+			c.depth = nil
+			if !newHazards(probe, hazards) {
+				msg := fmt.Sprintf("%s %d waits for more than needed: wait_group %d covers every later access", fam.wait, n, relaxed)
+				if relaxed == int64(w.pending) {
+					msg = fmt.Sprintf("%s %d is not needed by any later access", fam.wait, n)
+				}
+				findings = append(findings, Finding{Site: site, Message: msg})
+				break
+			}
+		}
+	}
+	return findings
+}
+
+func newHazards(probe, base map[string]Finding) bool {
+	for k := range probe {
+		if _, ok := base[k]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[int]bool) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// unionInts merges two sorted sets.
+func unionInts(a, b []int) []int {
+	out := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j >= len(b) || (i < len(a) && a[i] < b[j]):
+			out = append(out, a[i])
+			i++
+		case i >= len(a) || b[j] < a[i]:
+			out = append(out, b[j])
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// pointerRoots maps each register to the shared variables its value may
+// be derived from through moves and address arithmetic.
+type pointerRoots struct {
+	shared map[string]bool
+	regs   map[*builder.Register]map[string]bool
+}
+
+func newPointerRoots(f *builder.Function) pointerRoots {
+	r := pointerRoots{shared: sharedVariables(f), regs: make(map[*builder.Register]map[string]bool)}
+	for changed := true; changed; {
+		changed = false
+		for _, bb := range f.Blocks {
+			for _, inst := range bb.Instructions {
+				dst, ok := inst.Dst.(*builder.Register)
+				if !ok {
+					continue
+				}
+				switch inst.Op {
+				case ptx.OpMov, ptx.OpCvta, ptx.OpCvt, ptx.OpAdd, ptx.OpSub, ptx.OpMad, ptx.OpSelp, ptx.OpAnd, ptx.OpOr:
+				default:
+					continue
+				}
+				for _, src := range inst.Src {
+					for name := range r.of(src) {
+						if !r.regs[dst][name] {
+							if r.regs[dst] == nil {
+								r.regs[dst] = make(map[string]bool)
+							}
+							r.regs[dst][name] = true
+							changed = true
+						}
+					}
+				}
+			}
+		}
+	}
+	return r
+}
+
+// sharedVariables returns the names of the shared variables f uses: its
+// own .shared variables, and module-scope symbols it addresses in a shared
+// state space or as a cp.async destination. Parameters and f's other
+// variables never count.
+func sharedVariables(f *builder.Function) map[string]bool {
+	shared := make(map[string]bool)
+	other := make(map[string]bool)
+	for _, p := range f.Params {
+		other[p.Name] = true
+	}
+	for _, p := range f.ReturnParams {
+		other[p.Name] = true
+	}
+	for _, v := range f.Locals {
+		if isSharedSpace(v.Space) {
+			shared[v.Name] = true
+		} else {
+			other[v.Name] = true
+		}
+	}
+	add := func(op builder.Operand) {
+		if a, ok := op.(*builder.Address); ok {
+			op = a.Base
+		}
+		if sym, ok := op.(*builder.Symbol); ok && !other[sym.Name] {
+			shared[sym.Name] = true
+		}
+	}
+	for _, bb := range f.Blocks {
+		for _, inst := range bb.Instructions {
+			switch {
+			case inst.Op == ptx.OpCpAsync && len(inst.Src) > 0:
+				add(inst.Src[0])
+			case isSharedSpace(inst.Space):
+				for _, src := range inst.Src {
+					add(src)
+				}
+			}
+		}
+	}
+	return shared
+}
+
+// of returns the shared variables an operand may be derived from.
+func (r pointerRoots) of(op builder.Operand) map[string]bool {
+	switch o := op.(type) {
+	case *builder.Register:
+		return r.regs[o]
+	case *builder.Symbol:
+		if r.shared[o.Name] {
+			return map[string]bool{o.Name: true}
+		}
+	case *builder.Address:
+		return r.of(o.Base)
+	}
+	return nil
+}
+
+// mayAlias reports whether two addresses may refer to the same memory. A
+// nil operand, or one not derived from any shared variable, may alias
+// anything.
+func (r pointerRoots) mayAlias(a, b builder.Operand) bool {
+	if a == nil || b == nil {
+		return true
+	}
+	ra, rb := r.of(a), r.of(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		return true
+	}
+	for name := range ra {
+		if rb[name] {
+			return true
+		}
+	}
+	return false
+}
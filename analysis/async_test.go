@@ -0,0 +1,164 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// asyncKernel is a kernel with shared buffers a and b, a global pointer
+// parameter src and a u32 register v to load into.
+type asyncKernel struct {
+	k  *builder.Function
+	bb *builder.BasicBlock
+	v  *builder.Register
+}
+
+func newAsyncKernel() *asyncKernel {
+	mod := builder.NewModule(ptx.ISA80, ptx.SM90)
+	k := mod.NewKernel("k")
+	k.AddParam(builder.NewParam("src", ptx.U64))
+	k.NewLocal("a", ptx.Shared, ptx.B32)
+	k.NewLocal("b", ptx.Shared, ptx.B32)
+	return &asyncKernel{k: k, bb: k.NewBlock("entry"), v: k.NewReg("v", ptx.U32)}
+}
+
+// copy issues a 16-byte cp.async from global memory into dst.
+func (m *asyncKernel) copy(dst builder.Operand) {
+	m.bb.Add(builder.CpAsync(builder.Addr(dst, 0), builder.Addr(builder.Sym("gsrc"), 0), builder.Imm(16)).
+		WithMod(ptx.ModSpaceShared, ptx.ModSpaceGlobal))
+}
+
+func (m *asyncKernel) read(name string) {
+	m.bb.Add(builder.Ld(m.v, builder.Addr(builder.Sym(name), 0)).Typed(ptx.U32).InSpace(ptx.Shared))
+}
+
+func (m *asyncKernel) end() *builder.Function {
+	m.k.NewBlock("end").Add(builder.Ret())
+	return m.k
+}
+
+func TestAsyncGroups(t *testing.T) {
+	a, b := builder.Sym("a"), builder.Sym("b")
+	commit, waitAll := builder.CpAsyncCommitGroup, builder.CpAsyncWaitAll
+	wait := builder.CpAsyncWaitGroup
+	for _, tt := range []struct {
+		name  string
+		build func(m *asyncKernel)
+		want  []string
+	}{
+		{"wait then read", func(m *asyncKernel) {
+			m.copy(a)
+			m.bb.Add(commit())
+			m.bb.Add(wait(0))
+			m.read("a")
+		}, nil},
+		{"read before wait", func(m *asyncKernel) {
+			m.copy(a)
+			m.bb.Add(commit())
+			m.read("a")
+			m.bb.Add(wait(0))
+		}, []string{"ld reads shared memory written by cp.async at k/entry#0 before cp.async.wait_group covers it"}},
+		{"read of another variable", func(m *asyncKernel) {
+			m.copy(a)
+			m.bb.Add(commit())
+			m.read("b")
+			m.bb.Add(waitAll())
+		}, nil},
+		{"read before wait on one path", func(m *asyncKernel) {
+			p := m.k.NewReg("p", ptx.Pred)
+			m.copy(a)
+			m.bb.Add(commit())
+			m.bb.Add(builder.Setp(ptx.CmpEq, p, m.v, builder.Imm(0)).Typed(ptx.U32))
+			m.bb.Add(builder.Bra("read").Pred(p))
+			m.k.NewBlock("wait").Add(wait(0))
+			m.bb = m.k.NewBlock("read")
+			m.read("a")
+			m.bb.Add(waitAll())
+		}, []string{
+			"cp.async.wait_group 0 is not needed by any later access",
+			"ld reads shared memory written by cp.async",
+		}},
+		{"double buffered", func(m *asyncKernel) {
+			m.copy(a)
+			m.bb.Add(commit())
+			m.copy(b)
+			m.bb.Add(commit())
+			m.bb.Add(wait(1))
+			m.read("a")
+			m.bb.Add(wait(0))
+			m.read("b")
+		}, nil},
+		{"wait deeper than needed", func(m *asyncKernel) {
+			m.copy(a)
+			m.bb.Add(commit())
+			m.copy(b)
+			m.bb.Add(commit())
+			m.bb.Add(wait(0))
+			m.read("a")
+			m.bb.Add(wait(0))
+			m.read("b")
+		}, []string{
+			"cp.async.wait_group 0 waits for more than needed: wait_group 1 covers every later access",
+			"cp.async.wait_group 0 never blocks: at most 0 group(s) pending",
+		}},
+		{"wait never blocks", func(m *asyncKernel) {
+			m.copy(a)
+			m.bb.Add(commit())
+			m.bb.Add(wait(1))
+			m.bb.Add(waitAll())
+			m.read("a")
+		}, []string{"cp.async.wait_group 1 never blocks: at most 1 group(s) pending"}},
+		{"uncommitted copy", func(m *asyncKernel) {
+			m.copy(a)
+			m.bb.Add(wait(0))
+			m.read("a")
+		}, []string{
+			"cp.async is never waited for before the kernel exits",
+			"cp.async at k/entry#0 is not committed, so cp.async.wait_group does not wait for it",
+			"cp.async.wait_group 0 never blocks: at most 0 group(s) pending",
+			"ld reads shared memory written by cp.async",
+		}},
+		{"generic load through a parameter", func(m *asyncKernel) {
+			dst := m.k.NewReg("dst", ptx.U64)
+			g := m.k.NewReg("g", ptx.U64)
+			m.bb.Add(builder.Ld(dst, builder.Addr(builder.Sym("src"), 8)).Typed(ptx.U64).InSpace(ptx.Param))
+			m.copy(dst)
+			m.bb.Add(commit())
+			m.bb.Add(builder.Mov(g, builder.Sym("src")).Typed(ptx.U64))
+			m.bb.Add(builder.Ld(m.v, builder.Addr(g, 0)).Typed(ptx.U32))
+			m.bb.Add(wait(0))
+			m.read("a")
+		}, nil},
+	} {
+		m := newAsyncKernel()
+		tt.build(m)
+		checkFindings(t, tt.name, AsyncGroups(m.end()), tt.want...)
+	}
+}
+
+func TestAsyncBulkGroups(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		before bool // overwrite the source before the wait
+		want   []string
+	}{
+		{"overwrite after wait", false, nil},
+		{"overwrite before wait", true, []string{"st overwrites shared memory read by cp.async.bulk"}},
+	} {
+		m := newAsyncKernel()
+		m.bb.Add(builder.CpAsyncBulk(builder.Addr(builder.Sym("gdst"), 0), builder.Addr(builder.Sym("a"), 0), builder.Imm(16)).
+			WithMod(ptx.ModSpaceGlobal, ptx.ModSpaceShared, ptx.ModBulkGroup))
+		m.bb.Add(builder.CpAsyncBulkCommitGroup())
+		st := builder.St(builder.Addr(builder.Sym("a"), 0), m.v).Typed(ptx.U32).InSpace(ptx.Shared)
+		if tt.before {
+			m.bb.Add(st)
+		}
+		m.bb.Add(builder.CpAsyncBulkWaitGroup(0))
+		if !tt.before {
+			m.bb.Add(st)
+		}
+		checkFindings(t, tt.name, AsyncGroups(m.end()), tt.want...)
+	}
+}
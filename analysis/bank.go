@@ -0,0 +1,199 @@
+package analysis
+
+import (
+	"fmt"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+const (
+	numBanks  = 32
+	bankWidth = 4 // bytes per bank
+)
+
+// BankConflict reports the shared-memory bank behaviour of one ld.shared,
+// st.shared, ldmatrix or stmatrix instruction.
+//
+// Accesses are modelled the usual way: a warp request is split into phases
+// of 128 bytes (one phase for 4-byte accesses, half-warps for 8-byte,
+// quarter-warps for 16-byte and one 8x8 matrix per phase for ldmatrix and
+// stmatrix). Within a phase, lanes reading the same word are broadcast and
+// distinct words in the same bank are serialized.
+type BankConflict struct {
+	Site
+	Width      int  // bytes per lane (per matrix row for ldmatrix/stmatrix)
+	Degree     int  // worst n-way conflict in any phase; 1 = conflict free
+	Wavefronts int  // shared-memory wavefronts needed by the worst warp
+	Ideal      int  // wavefronts a conflict-free access of this shape needs
+	Warp       int  // warp of the CTA that needs the most wavefronts
+	Known      bool // false if the address could not be evaluated for every warp
+}
+
+// String formats the report, e.g. "k/store#5: st 4B: 2-way conflict (2 wavefronts, ideal 1, warp 0)".
+func (c BankConflict) String() string {
+	head := fmt.Sprintf("%s: %s %dB", c.Site, c.Inst().Op, c.Width)
+	if !c.Known {
+		return head + ": address not evaluable"
+	}
+	if c.Degree <= 1 {
+		return fmt.Sprintf("%s: conflict free (%d wavefronts)", head, c.Wavefronts)
+	}
+	return fmt.Sprintf("%s: %d-way conflict (%d wavefronts, ideal %d, warp %d)",
+		head, c.Degree, c.Wavefronts, c.Ideal, c.Warp)
+}
+
+// BankConflicts evaluates the shared address used by every lane for each
+// shared-memory access in f, for every warp of a CTA launched with the given
+// block dimensions, and reports the worst bank conflict degree of each.
+//
+// Addresses are evaluated from %tid through the integer arithmetic that
+// produces them. Warp-uniform terms (kernel parameters, %ctaid, the base of
+// a shared variable, uniform loop counters) are kept symbolic and assumed
+// to be word aligned, which does not change the conflict degree. All lanes
+// of a warp are assumed active at every access.
+func BankConflicts(f *builder.Function, launch Launch) []BankConflict {
+	g := NewCFG(f)
+	var reports []BankConflict
+	index := make(map[Site]int)
+	isShared := func(inst *builder.Instruction) bool {
+		_, _, ok := sharedAccess(inst)
+		return ok
+	}
+
+	for w := 0; w < launch.Warps(); w++ {
+		e := newWarpEval(f, launch, w)
+		addrs := e.addresses(g, isShared)
+		for _, site := range sortedSites(addrs) {
+			addr := addrs[site]
+			width, rows, _ := sharedAccess(site.Inst())
+
+			i, exists := index[site]
+			if !exists {
+				i = len(reports)
+				index[site] = i
+				reports = append(reports, BankConflict{Site: site, Width: width, Known: true})
+			}
+			r := &reports[i]
+			if !addr.known {
+				r.Known = false
+				continue
+			}
+			active := e.active
+			for l := rows; l < WarpSize; l++ {
+				active[l] = false
+			}
+			degree, waves, ideal := bankWavefronts(&addr.lanes, &active, width)
+			if waves > r.Wavefronts {
+				r.Wavefronts, r.Ideal, r.Warp = waves, ideal, w
+			}
+			if degree > r.Degree {
+				r.Degree = degree
+			}
+		}
+	}
+	return reports
+}
+
+// sharedAccess classifies inst as a shared-memory access, returning the
+// bytes accessed per lane and how many lanes supply addresses.
+func sharedAccess(inst *builder.Instruction) (width, lanes int, ok bool) {
+	if len(inst.Src) == 0 {
+		return 0, 0, false
+	}
+	if _, isAddr := inst.Src[0].(*builder.Address); !isAddr {
+		return 0, 0, false
+	}
+
+	switch inst.Op {
+	case ptx.OpLdMatrix, ptx.OpStMatrix:
+		// Each address-supplying lane names one 16-byte row; .x1/.x2/.x4
+		// select how many 8-row matrices (and so how many lanes) take part.
+		lanes = 8
+		for _, m := range inst.Modifiers {
+			switch m {
+			case ptx.ModNumX2:
+				lanes = 16
+			case ptx.ModNumX4:
+				lanes = 32
+			}
+		}
+		return 16, lanes, true
+	case ptx.OpLd, ptx.OpSt:
+		if inst.Space != ptx.Shared && inst.Space != ptx.SharedCTA {
+			return 0, 0, false
+		}
+		return accessWidth(inst), WarpSize, true
+	}
+	return 0, 0, false
+}
+
+// accessWidth returns the bytes a single lane of an ld/st transfers.
+func accessWidth(inst *builder.Instruction) int {
+	width := inst.Typ.BitWidth() / 8
+	if width == 0 {
+		width = 1
+	}
+	switch inst.Vec {
+	case ptx.V2:
+		width *= 2
+	case ptx.V4:
+		width *= 4
+	}
+	return width
+}
+
+// bankWavefronts computes the worst per-phase conflict degree, the number
+// of wavefronts, and the conflict-free wavefront count for one warp access.
+func bankWavefronts(addrs *[WarpSize]int64, active *[WarpSize]bool, width int) (degree, waves, ideal int) {
+	perPhase := WarpSize
+	if width > bankWidth {
+		perPhase = numBanks * bankWidth / width
+	}
+	for start := 0; start < WarpSize; start += perPhase {
+		words := make(map[int64][]int64) // bank -> distinct words
+		activeLanes := false
+		for l := start; l < start+perPhase; l++ {
+			if !active[l] {
+				continue
+			}
+			activeLanes = true
+			first := floorDiv(addrs[l], bankWidth)
+			last := floorDiv(addrs[l]+int64(width)-1, bankWidth)
+			for w := first; w <= last; w++ {
+				bank := ((w % numBanks) + numBanks) % numBanks
+				if !containsInt(words[bank], w) {
+					words[bank] = append(words[bank], w)
+				}
+			}
+		}
+		if !activeLanes {
+			continue
+		}
+		d := 1
+		for _, ws := range words {
+			d = max(d, len(ws))
+		}
+		degree = max(degree, d)
+		waves += d
+		ideal++
+	}
+	return degree, waves, ideal
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+func containsInt(xs []int64, x int64) bool {
+	for _, y := range xs {
+		if y == x {
+			return true
+		}
+	}
+	return false
+}
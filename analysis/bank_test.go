@@ -0,0 +1,101 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// tileKernel computes in addr the shared address tile + x*xStride + y*yStride
+// from %tid.x and %tid.y, and adds the access built from it.
+func tileKernel(xStride, yStride int64,
+	access func(k *builder.Function, addr *builder.Register) *builder.Instruction) *builder.Function {
+	mod := builder.NewModule(ptx.ISA80, ptx.SM80)
+	k := mod.NewKernel("k")
+	k.NewLocal("tile", ptx.Shared, ptx.B32)
+	x := k.NewReg("x", ptx.U32)
+	y := k.NewReg("y", ptx.U32)
+	off := k.NewReg("off", ptx.U32)
+	addr := k.NewReg("addr", ptx.U32)
+	bb := k.NewBlock("entry")
+	bb.Add(builder.Mov(x, builder.SReg(ptx.RegTidX)).Typed(ptx.U32))
+	bb.Add(builder.Mov(y, builder.SReg(ptx.RegTidY)).Typed(ptx.U32))
+	bb.Add(builder.Mul(off, x, builder.Imm(xStride)).Typed(ptx.U32))
+	bb.Add(builder.Mad(off, y, builder.Imm(yStride), off).Typed(ptx.U32))
+	bb.Add(builder.Mov(addr, builder.Sym("tile")).Typed(ptx.U32))
+	bb.Add(builder.Add(addr, addr, off).Typed(ptx.U32))
+	bb.Add(access(k, addr))
+	bb.Add(builder.Ret())
+	return k
+}
+
+func checkBank(t *testing.T, name string, f *builder.Function, launch Launch, degree, waves, ideal int) {
+	t.Helper()
+	got := BankConflicts(f, launch)
+	if len(got) != 1 {
+		t.Fatalf("%s: reports %v, want 1", name, got)
+	}
+	if r := got[0]; !r.Known || r.Degree != degree || r.Wavefronts != waves || r.Ideal != ideal {
+		t.Errorf("%s: %v; want degree %d, %d wavefronts, ideal %d", name, r, degree, waves, ideal)
+	}
+}
+
+// TestBankConflictsTranspose reads a column of a 32x32 float tile, one
+// row per lane, as the second half of a shared-memory transpose does.
+func TestBankConflictsTranspose(t *testing.T) {
+	launch := Launch{Block: [3]int{32, 8, 1}}
+	for _, tt := range []struct {
+		name                 string
+		pitch                int64 // floats per row
+		degree, waves, ideal int
+	}{
+		{"unpadded", 32, 32, 32, 1},
+		{"padded", 33, 1, 1, 1},
+		{"padded by two", 34, 2, 2, 1},
+	} {
+		f := tileKernel(tt.pitch*4, 4, func(k *builder.Function, addr *builder.Register) *builder.Instruction {
+			return builder.Ld(k.NewReg("v", ptx.F32), builder.Addr(addr, 0)).Typed(ptx.F32).InSpace(ptx.Shared)
+		})
+		checkBank(t, tt.name, f, launch, tt.degree, tt.waves, tt.ideal)
+	}
+
+	// The row-wise store of the first half is conflict free either way.
+	f := tileKernel(4, 32*4, func(k *builder.Function, addr *builder.Register) *builder.Instruction {
+		return builder.St(builder.Addr(addr, 0), k.NewReg("v", ptx.F32)).Typed(ptx.F32).InSpace(ptx.Shared)
+	})
+	checkBank(t, "row store", f, launch, 1, 1, 1)
+}
+
+func TestBankConflictsVectorWidth(t *testing.T) {
+	// A contiguous .v4 access is split into quarter-warp phases.
+	f := tileKernel(16, 0, func(k *builder.Function, addr *builder.Register) *builder.Instruction {
+		v := builder.Vec(k.NewReg("a", ptx.F32), k.NewReg("b", ptx.F32), k.NewReg("c", ptx.F32), k.NewReg("d", ptx.F32))
+		return builder.Ld(v, builder.Addr(addr, 0)).Typed(ptx.F32).InSpace(ptx.Shared).WithVec(ptx.V4)
+	})
+	checkBank(t, "v4", f, Launch{Block: [3]int{32, 1, 1}}, 1, 4, 4)
+}
+
+// TestBankConflictsLdmatrix loads 8x8 b16 matrices whose 16-byte rows are
+// pitch bytes apart, one row address per lane.
+func TestBankConflictsLdmatrix(t *testing.T) {
+	launch := Launch{Block: [3]int{32, 1, 1}}
+	for _, tt := range []struct {
+		name                 string
+		num                  ptx.Modifier
+		pitch                int64
+		degree, waves, ideal int
+	}{
+		{".x1 contiguous", ptx.ModNumX1, 16, 1, 1, 1},
+		{".x1 unpadded 64-byte rows", ptx.ModNumX1, 64, 4, 4, 1},
+		{".x1 padded 80-byte rows", ptx.ModNumX1, 80, 1, 1, 1},
+		{".x4 contiguous", ptx.ModNumX4, 16, 1, 4, 4},
+		{".x4 unpadded 64-byte rows", ptx.ModNumX4, 64, 4, 16, 4},
+		{".x4 padded 80-byte rows", ptx.ModNumX4, 80, 1, 4, 4},
+	} {
+		f := tileKernel(tt.pitch, 0, func(k *builder.Function, addr *builder.Register) *builder.Instruction {
+			return builder.Ldmatrix(ptx.ModShapeM8N8, tt.num, ptx.B16, k.NewReg("frag", ptx.B32), builder.Addr(addr, 0))
+		})
+		checkBank(t, tt.name, f, launch, tt.degree, tt.waves, tt.ideal)
+	}
+}
@@ -0,0 +1,341 @@
+package analysis
+
+import (
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// Exit is the pseudo block index used for edges that leave the function
+// (ret, exit, trap, or falling off the end of the last block).
+const Exit = -1
+
+// CFG is the control-flow graph of a function's basic blocks.
+//
+// Branches may appear anywhere inside a block, not only at its end, so an
+// edge is recorded for every bra as well as for the fall-through at the end
+// of each block that is not terminated by an unconditional branch.
+type CFG struct {
+	Func  *builder.Function
+	Succs [][]int // successor block indices per block (Exit for leaving the function)
+	Preds [][]int // predecessor block indices per block
+
+	labels map[string]int
+}
+
+// NewCFG builds the control-flow graph for f.
+func NewCFG(f *builder.Function) *CFG {
+	g := &CFG{
+		Func:   f,
+		Succs:  make([][]int, len(f.Blocks)),
+		Preds:  make([][]int, len(f.Blocks)),
+		labels: make(map[string]int),
+	}
+	for i, bb := range f.Blocks {
+		if bb.Label != "" {
+			g.labels[bb.Label] = i
+		}
+	}
+	for b := range f.Blocks {
+		g.edges(b, func(_ int, to int) {
+			for _, s := range g.Succs[b] {
+				if s == to {
+					return
+				}
+			}
+			g.Succs[b] = append(g.Succs[b], to)
+			if to != Exit {
+				g.Preds[to] = append(g.Preds[to], b)
+			}
+		})
+	}
+	return g
+}
+
+// Block returns the index of the block with the given label.
+func (g *CFG) Block(label string) (int, bool) {
+	b, ok := g.labels[label]
+	return b, ok
+}
+
+// edges calls fn for every outgoing edge of block b, passing the index of
+// the instruction the edge leaves from (len(Instructions) for fall-through).
+func (g *CFG) edges(b int, fn func(at, to int)) {
+	insts := g.Func.Blocks[b].Instructions
+	for i, inst := range insts {
+		if to, ok := g.target(inst); ok {
+			fn(i, to)
+		}
+		if terminates(inst) {
+			return
+		}
+	}
+	if b+1 < len(g.Func.Blocks) {
+		fn(len(insts), b+1)
+	} else {
+		fn(len(insts), Exit)
+	}
+}
+
+// target returns the block an instruction transfers control to, if any.
+func (g *CFG) target(inst *builder.Instruction) (int, bool) {
+	switch inst.Op {
+	case ptx.OpBra:
+		if len(inst.Src) == 0 {
+			return 0, false
+		}
+		sym, ok := inst.Src[0].(*builder.Symbol)
+		if !ok {
+			return 0, false
+		}
+		b, ok := g.labels[sym.Name]
+		return b, ok
+	case ptx.OpRet, ptx.OpExit, ptx.OpTrap:
+		return Exit, true
+	}
+	return 0, false
+}
+
+// terminates reports whether control never continues past inst.
+func terminates(inst *builder.Instruction) bool {
+	if inst.Guard != nil {
+		return false
+	}
+	switch inst.Op {
+	case ptx.OpBra, ptx.OpBrxIdx, ptx.OpRet, ptx.OpExit, ptx.OpTrap:
+		return true
+	}
+	return false
+}
+
+// problem is a forward dataflow problem over a CFG.
+//
+// Implementations must not modify the state passed as b to join, and may
+// modify and return the state passed to transfer.
+type problem[S any] interface {
+	entry() S
+	clone(s S) S
+	join(a, b S, block int) (S, bool) // joined state at block, and whether it differs from a
+	transfer(s S, site Site) S
+}
+
+// brancher is implemented by problems that refine their state along the
+// taken and fall-through edges of a guarded branch. branch may modify and
+// return s.
+type brancher[S any] interface {
+	branch(s S, site Site, taken bool) S
+}
+
+// solve runs p to a fixed point and returns the state on entry to every
+// block, together with which blocks are reachable from the entry block.
+func solve[S any](g *CFG, p problem[S]) (in []S, reached []bool) {
+	n := len(g.Func.Blocks)
+	in = make([]S, n)
+	reached = make([]bool, n)
+	if n == 0 {
+		return in, reached
+	}
+
+	in[0] = p.entry()
+	reached[0] = true
+	queued := make([]bool, n)
+	work := []int{0}
+	queued[0] = true
+
+	for len(work) > 0 {
+		b := work[0]
+		work = work[1:]
+		queued[b] = false
+
+		walk(g, p, b, in[b], nil, func(_ Site, to int, s S) {
+			if to == Exit {
+				return
+			}
+			changed := false
+			if !reached[to] {
+				in[to] = p.clone(s)
+				reached[to] = true
+				changed = true
+			} else {
+				in[to], changed = p.join(in[to], s, to)
+			}
+			if changed && !queued[to] {
+				work = append(work, to)
+				queued[to] = true
+			}
+		})
+	}
+	return in, reached
+}
+
+// walk interprets block b starting from state s. visit is called before each
+// instruction with the state it executes in; edge is called for every
+// control transfer out of the block with the state at that point.
+func walk[S any](g *CFG, p problem[S], b int, s S, visit func(Site, S), edge func(Site, int, S)) {
+	s = p.clone(s)
+	insts := g.Func.Blocks[b].Instructions
+	for i, inst := range insts {
+		if pseudo(inst) {
+			continue
+		}
+		site := Site{Func: g.Func, Block: b, Index: i}
+		if visit != nil {
+			visit(site, s)
+		}
+		s = p.transfer(s, site)
+		if to, ok := g.target(inst); ok {
+			if br, ok := any(p).(brancher[S]); ok && inst.Guard != nil {
+				taken := br.branch(p.clone(s), site, true)
+				s = br.branch(s, site, false)
+				if edge != nil {
+					edge(site, to, taken)
+				}
+			} else if edge != nil {
+				edge(site, to, s)
+			}
+		}
+		if terminates(inst) {
+			return
+		}
+	}
+	if edge == nil {
+		return
+	}
+	site := Site{Func: g.Func, Block: b, Index: len(insts)}
+	if b+1 < len(g.Func.Blocks) {
+		edge(site, b+1, s)
+	} else {
+		edge(site, Exit, s)
+	}
+}
+
+// replay walks every reachable block once more from its fixed-point entry
+// state, reporting the state before each instruction and at each edge.
+func replay[S any](g *CFG, p problem[S], in []S, reached []bool, visit func(Site, S), edge func(Site, int, S)) {
+	for b := range g.Func.Blocks {
+		if reached[b] {
+			walk(g, p, b, in[b], visit, edge)
+		}
+	}
+}
+
+// dominators returns the immediate dominator of every block, with -1 for
+// the entry block and for blocks it cannot reach.
+func (g *CFG) dominators() []int {
+	n := len(g.Func.Blocks)
+	idom := make([]int, n)
+	for i := range idom {
+		idom[i] = -1
+	}
+	if n == 0 {
+		return idom
+	}
+
+	// Reverse post-order from the entry.
+	rank := make([]int, n)
+	for i := range rank {
+		rank[i] = -1
+	}
+	var order []int
+	var visit func(b int)
+	visit = func(b int) {
+		rank[b] = 0
+		for _, s := range g.Succs[b] {
+			if s != Exit && rank[s] < 0 {
+				visit(s)
+			}
+		}
+		order = append(order, b)
+	}
+	visit(0)
+	for i, b := range order {
+		rank[b] = len(order) - 1 - i
+	}
+
+	idom[0] = 0
+	intersect := func(a, b int) int {
+		for a != b {
+			for rank[a] > rank[b] {
+				a = idom[a]
+			}
+			for rank[b] > rank[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(order) - 1; i >= 0; i-- {
+			b := order[i]
+			if b == 0 {
+				continue
+			}
+			next := -1
+			for _, p := range g.Preds[b] {
+				if idom[p] < 0 {
+					continue
+				}
+				if next < 0 {
+					next = p
+				} else {
+					next = intersect(p, next)
+				}
+			}
+			if next != idom[b] {
+				idom[b] = next
+				changed = true
+			}
+		}
+	}
+	idom[0] = -1
+	return idom
+}
+
+// dominates reports whether block a dominates block b.
+func dominates(idom []int, a, b int) bool {
+	for b >= 0 {
+		if a == b {
+			return true
+		}
+		b = idom[b]
+	}
+	return false
+}
+
+// loop is a natural loop: a back edge to a header that dominates it, and
+// the blocks that reach the back edge without passing through the header.
+type loop struct {
+	header int
+	back   Site   // the branch, or fall-through position, closing the loop
+	blocks []bool // blocks in the loop body, header included
+}
+
+// loops returns the natural loops of g, one per back edge.
+func (g *CFG) loops() []loop {
+	idom := g.dominators()
+	var loops []loop
+	for b := range g.Func.Blocks {
+		if b != 0 && idom[b] < 0 {
+			continue
+		}
+		g.edges(b, func(at, to int) {
+			if to == Exit || !dominates(idom, to, b) {
+				return
+			}
+			body := make([]bool, len(g.Func.Blocks))
+			body[to] = true
+			work := []int{b}
+			for len(work) > 0 {
+				x := work[len(work)-1]
+				work = work[:len(work)-1]
+				if body[x] {
+					continue
+				}
+				body[x] = true
+				work = append(work, g.Preds[x]...)
+			}
+			loops = append(loops, loop{header: to, back: Site{Func: g.Func, Block: b, Index: at}, blocks: body})
+		})
+	}
+	return loops
+}
@@ -0,0 +1,401 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// sectorSize is the granularity, in bytes, of global memory transactions.
+const sectorSize = 32
+
+// maxVectorBytes is the widest ld/st vector access (.v4.b32, .v2.b64).
+const maxVectorBytes = 16
+
+// GlobalAccess reports how one global load or store maps onto 32-byte
+// sectors across a warp.
+type GlobalAccess struct {
+	Site
+	Address    string // affine form of the address for warp 0
+	Width      int    // bytes per lane
+	Sectors    int    // sectors the worst warp touches in one request
+	Ideal      int    // sectors a contiguous, sector-aligned access of the same bytes needs
+	Stride     int64  // byte stride between consecutive lanes; 0 if not linear
+	Strided    bool   // lanes touch more sectors than ideal even when aligned
+	Misaligned bool   // the base may not be sector aligned, costing extra sectors
+	Vector     int    // .v2/.v4 width this access could be merged into; 0 if none
+	VectorWith []Site // neighbouring scalar accesses to merge with
+	Known      bool   // false if the address could not be evaluated for every warp
+}
+
+// String formats the report, e.g.
+// "k/body#4: ld 4B: 4 sectors/request (ideal 4), address 4*lane + a".
+func (c GlobalAccess) String() string {
+	head := fmt.Sprintf("%s: %s %dB", c.Site, c.Inst().Op, c.Width)
+	if !c.Known {
+		return head + ": address not evaluable"
+	}
+	notes := []string{fmt.Sprintf("%d sectors/request (ideal %d)", c.Sectors, c.Ideal)}
+	if c.Strided {
+		if c.Stride != 0 {
+			notes = append(notes, fmt.Sprintf("strided by %dB", c.Stride))
+		} else {
+			notes = append(notes, "scattered")
+		}
+	}
+	if c.Misaligned {
+		notes = append(notes, "possibly misaligned")
+	}
+	if c.Vector > 0 {
+		with := make([]string, len(c.VectorWith))
+		for i, s := range c.VectorWith {
+			with[i] = fmt.Sprintf("#%d", s.Index)
+		}
+		notes = append(notes, fmt.Sprintf("could be .v%d with %s", c.Vector, strings.Join(with, ", ")))
+	}
+	return fmt.Sprintf("%s: %s, address %s", head, strings.Join(notes, ", "), c.Address)
+}
+
+// Coalescing evaluates the address of every global ld, st and ld.global.nc
+// in f as an affine function of %tid, %ctaid and the kernel parameters, for
+// every warp of a CTA launched with the given block dimensions. It reports
+// the 32-byte sectors each request touches, strided or misaligned patterns,
+// and runs of scalar accesses that could be a single .v2/.v4 access.
+//
+// Pointer parameters are assumed aligned to their .align, or to 256 bytes
+// (the cudaMalloc granularity) when none is given. All lanes of a warp are
+// assumed active at every access.
+func Coalescing(f *builder.Function, launch Launch) []GlobalAccess {
+	g := NewCFG(f)
+	var reports []GlobalAccess
+	index := make(map[Site]int)
+
+	for w := 0; w < launch.Warps(); w++ {
+		e := newWarpEval(f, launch, w)
+		addrs := e.addresses(g, globalAccess)
+		vectors := e.vectorGroups(f, addrs)
+
+		for _, site := range sortedSites(addrs) {
+			addr := addrs[site]
+			i, exists := index[site]
+			if !exists {
+				i = len(reports)
+				index[site] = i
+				reports = append(reports, GlobalAccess{
+					Site:    site,
+					Address: addr.String(),
+					Width:   accessWidth(site.Inst()),
+					Known:   true,
+				})
+				if v, ok := vectors[site]; ok {
+					reports[i].Vector, reports[i].VectorWith = v.width, v.with
+				}
+			}
+			r := &reports[i]
+			if v := vectors[site]; r.Vector != v.width || !sameSites(r.VectorWith, v.with) {
+				r.Vector, r.VectorWith = 0, nil
+			}
+			if !addr.known {
+				r.Known = false
+				continue
+			}
+			e.sectorize(r, addr)
+		}
+	}
+	return reports
+}
+
+// globalAccess reports whether inst is a load or store of global memory.
+func globalAccess(inst *builder.Instruction) bool {
+	if len(inst.Src) == 0 {
+		return false
+	}
+	if _, ok := inst.Src[0].(*builder.Address); !ok {
+		return false
+	}
+	switch inst.Op {
+	case ptx.OpLd, ptx.OpSt, ptx.OpLdNC, ptx.OpLdu:
+		return inst.Space == ptx.Global
+	}
+	return false
+}
+
+// sectorize folds one warp's view of an access into r.
+func (e *warpEval) sectorize(r *GlobalAccess, addr value) {
+	width := int64(r.Width)
+	first, lo := -1, int64(0)
+	distinct := make(map[int64]bool)
+	for l := range addr.lanes {
+		if !e.active[l] {
+			continue
+		}
+		if first < 0 || addr.lanes[l] < lo {
+			lo = addr.lanes[l]
+		}
+		if first < 0 {
+			first = l
+		}
+		distinct[addr.lanes[l]] = true
+	}
+	if first < 0 {
+		return
+	}
+
+	// Worst case over every base residue the uniform part can take while
+	// keeping the access naturally aligned.
+	align := addr.termAlign(e.align)
+	worst := 0
+	for res := int64(0); res < sectorSize; res++ {
+		if (align == 0 && res != 0) || (align != 0 && res%align != 0) {
+			continue
+		}
+		if (addr.lanes[first]+res)%width != 0 {
+			continue
+		}
+		worst = max(worst, e.sectors(&addr.lanes, res, width))
+	}
+	aligned := e.sectors(&addr.lanes, ((-lo)%sectorSize+sectorSize)%sectorSize, width)
+	if worst == 0 {
+		worst = aligned
+	}
+	ideal := int((int64(len(distinct))*width + sectorSize - 1) / sectorSize)
+
+	if worst > r.Sectors {
+		r.Sectors, r.Ideal = worst, ideal
+	}
+	if aligned > ideal {
+		r.Strided = true
+	}
+	if worst > aligned {
+		r.Misaligned = true
+	}
+	if stride, ok := linearStride(&addr.lanes, &e.active); ok {
+		r.Stride = stride
+	}
+}
+
+// sectors counts the distinct 32-byte sectors touched by the active lanes
+// when the uniform part of the address is congruent to res.
+func (e *warpEval) sectors(lanes *[WarpSize]int64, res, width int64) int {
+	seen := make(map[int64]bool)
+	for l, a := range lanes {
+		if !e.active[l] {
+			continue
+		}
+		for s := floorDiv(a+res, sectorSize); s <= floorDiv(a+res+width-1, sectorSize); s++ {
+			seen[s] = true
+		}
+	}
+	return len(seen)
+}
+
+// linearStride returns the constant difference between consecutive active lanes.
+func linearStride(lanes *[WarpSize]int64, active *[WarpSize]bool) (int64, bool) {
+	prev, stride, have := -1, int64(0), false
+	for l := range lanes {
+		if !active[l] {
+			continue
+		}
+		if prev >= 0 {
+			d := lanes[l] - lanes[prev]
+			if have && d != stride {
+				return 0, false
+			}
+			stride, have = d, true
+		}
+		prev = l
+	}
+	return stride, have
+}
+
+// vectorGroup is a suggested merge of scalar accesses into one vector access.
+type vectorGroup struct {
+	width int
+	with  []Site
+}
+
+// vectorGroups finds runs of scalar global accesses in the same block that
+// read or write adjacent elements from a common address and could be
+// issued as one naturally aligned .v2 or .v4 access. The search from each
+// access stops at the first intervening instruction that could order
+// memory: a store, atomic, barrier, fence, call or branch (and, for stores,
+// any other load). Merging issues the later accesses together with this
+// one, so the search also stops at an instruction that reads a register
+// loaded earlier in the run or that redefines a register of this address,
+// and an access joins only if the registers it reads other than its
+// address are not written in between and its destination is not touched.
+func (e *warpEval) vectorGroups(f *builder.Function, addrs map[Site]value) map[Site]vectorGroup {
+	groups := make(map[Site]vectorGroup)
+	for _, site := range sortedSites(addrs) {
+		if _, done := groups[site]; done {
+			continue
+		}
+		inst := site.Inst()
+		base := addrs[site]
+		width := int64(accessWidth(inst))
+		if inst.Vec != ptx.Scalar || !base.known || width*2 > maxVectorBytes {
+			continue
+		}
+
+		// Collect neighbours by byte offset from this access.
+		offsets := map[int64]Site{0: site}
+		addrRegs, loaded := regSet(inst.Src[0]), regSet(inst.Dst)
+		written, touched := regSet(), regSet()
+		insts := f.Blocks[site.Block].Instructions
+		for j := site.Index + 1; j < len(insts); j++ {
+			next := Site{Func: f, Block: site.Block, Index: j}
+			other := insts[j]
+			if readsAny(other, loaded) || writesAny(other, addrRegs) {
+				break
+			}
+			if v, ok := addrs[next]; ok && mergeable(inst, other) && canHoist(other, written, touched) {
+				if d, ok := sub(v, base).scalar(); ok && d%width == 0 && abs(d) < maxVectorBytes {
+					if _, taken := offsets[d]; !taken {
+						offsets[d] = next
+						forEachOperandReg(other.Dst, func(r *builder.Register) { loaded[r] = true })
+					}
+					continue
+				}
+			}
+			if ordersMemory(other, inst.Op == ptx.OpSt) {
+				break
+			}
+			forEachOperandReg(other.Dst, func(r *builder.Register) { written[r] = true })
+			forEachOperandReg(other.Dst2, func(r *builder.Register) { written[r] = true })
+			forEachReg(other, func(r *builder.Register) { touched[r] = true })
+		}
+		if len(offsets) < 2 {
+			continue
+		}
+
+		for n := maxVectorBytes / width; n >= 2; n /= 2 {
+			if n > 4 {
+				continue
+			}
+			if g, ok := e.vectorRun(offsets, base, width, n); ok {
+				for _, s := range g {
+					var with []Site
+					for _, o := range g {
+						if o != s {
+							with = append(with, o)
+						}
+					}
+					groups[s] = vectorGroup{width: int(n), with: with}
+				}
+				break
+			}
+		}
+	}
+	return groups
+}
+
+// vectorRun looks for n adjacent elements, including offset 0, whose start
+// is aligned to the vector size in every lane.
+func (e *warpEval) vectorRun(offsets map[int64]Site, base value, width, n int64) ([]Site, bool) {
+	size := width * n
+	align := base.termAlign(e.align)
+	if align != 0 && align%size != 0 {
+		return nil, false
+	}
+	for start := -(n - 1) * width; start <= 0; start += width {
+		run := make([]Site, 0, n)
+		for k := int64(0); k < n; k++ {
+			s, ok := offsets[start+k*width]
+			if !ok {
+				break
+			}
+			run = append(run, s)
+		}
+		if int64(len(run)) != n {
+			continue
+		}
+		aligned := true
+		for l, a := range base.lanes {
+			if e.active[l] && ((a+start)%size+size)%size != 0 {
+				aligned = false
+				break
+			}
+		}
+		if aligned {
+			return run, true
+		}
+	}
+	return nil, false
+}
+
+// mergeable reports whether b could share a vector access with a.
+func mergeable(a, b *builder.Instruction) bool {
+	if a.Op != b.Op || a.Typ != b.Typ || a.Space != b.Space || b.Vec != ptx.Scalar || a.Cache != b.Cache {
+		return false
+	}
+	if (a.Guard == nil) != (b.Guard == nil) {
+		return false
+	}
+	return a.Guard == nil || (a.Guard.Reg == b.Guard.Reg && a.Guard.Negate == b.Guard.Negate)
+}
+
+// canHoist reports whether inst could be issued earlier, before the
+// instructions that wrote the registers in written and touched those in
+// touched: its guard and operands other than the address are not written
+// and its destination is not touched.
+func canHoist(inst *builder.Instruction, written, touched map[*builder.Register]bool) bool {
+	ok := inst.Guard == nil || !written[inst.Guard.Reg]
+	for _, src := range inst.Src[1:] {
+		forEachOperandReg(src, func(r *builder.Register) { ok = ok && !written[r] })
+	}
+	forEachOperandReg(inst.Dst, func(r *builder.Register) { ok = ok && !touched[r] })
+	return ok
+}
+
+// readsAny reports whether inst reads a register in set.
+func readsAny(inst *builder.Instruction, set map[*builder.Register]bool) bool {
+	found := inst.Guard != nil && set[inst.Guard.Reg]
+	for _, src := range inst.Src {
+		forEachOperandReg(src, func(r *builder.Register) { found = found || set[r] })
+	}
+	return found
+}
+
+// writesAny reports whether inst writes a register in set.
+func writesAny(inst *builder.Instruction, set map[*builder.Register]bool) bool {
+	found := false
+	forEachOperandReg(inst.Dst, func(r *builder.Register) { found = found || set[r] })
+	forEachOperandReg(inst.Dst2, func(r *builder.Register) { found = found || set[r] })
+	return found
+}
+
+// regSet returns the set of registers named by ops.
+func regSet(ops ...builder.Operand) map[*builder.Register]bool {
+	set := make(map[*builder.Register]bool)
+	for _, op := range ops {
+		forEachOperandReg(op, func(r *builder.Register) { set[r] = true })
+	}
+	return set
+}
+
+// ordersMemory reports whether inst may not be reordered across a memory
+// access; loads only matter when the access being merged is a store.
+func ordersMemory(inst *builder.Instruction, store bool) bool {
+	switch inst.Op {
+	case ptx.OpSt, ptx.OpAtom, ptx.OpRed, ptx.OpBar, ptx.OpBarWarpSync, ptx.OpMembar, ptx.OpFence,
+		ptx.OpCall, ptx.OpBra, ptx.OpBrxIdx, ptx.OpRet, ptx.OpExit:
+		return true
+	case ptx.OpLd, ptx.OpLdNC, ptx.OpLdu:
+		return store
+	}
+	return false
+}
+
+func sameSites(a, b []Site) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
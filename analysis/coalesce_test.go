@@ -0,0 +1,161 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// coalesceKernel is a kernel with a global pointer parameter a, a u32
+// parameter off, and in addr the address a + stride*%tid.x + offset.
+type coalesceKernel struct {
+	k    *builder.Function
+	bb   *builder.BasicBlock
+	addr *builder.Register
+	off  *builder.Register
+}
+
+func newCoalesceKernel(stride, offset int64) *coalesceKernel {
+	mod := builder.NewModule(ptx.ISA80, ptx.SM80)
+	k := mod.NewKernel("k")
+	k.AddParam(builder.NewPtrParam("a", ptx.Global))
+	k.AddParam(builder.NewParam("off", ptx.U32))
+	m := &coalesceKernel{k: k, addr: k.NewReg("addr", ptx.U64), off: k.NewReg("off", ptx.U64)}
+	base := k.NewReg("base", ptx.U64)
+	tid := k.NewReg("tid", ptx.U32)
+	t := k.NewReg("t", ptx.U64)
+	m.bb = k.NewBlock("entry")
+	m.bb.Add(builder.Ld(base, builder.Addr(k.Param("a"), 0)).Typed(ptx.U64).InSpace(ptx.Param))
+	m.bb.Add(builder.Ld(m.off, builder.Addr(k.Param("off"), 0)).Typed(ptx.U64).InSpace(ptx.Param))
+	m.bb.Add(builder.Mov(tid, builder.SReg(ptx.RegTidX)).Typed(ptx.U32))
+	m.bb.Add(builder.Cvt(t, tid).Typed(ptx.U64))
+	m.bb.Add(builder.Mul(t, t, builder.Imm(stride)).Typed(ptx.U64))
+	m.bb.Add(builder.Add(m.addr, base, t).Typed(ptx.U64))
+	m.bb.Add(builder.Add(m.addr, m.addr, builder.Imm(offset)).Typed(ptx.U64))
+	return m
+}
+
+func (m *coalesceKernel) ld(dst *builder.Register, off int64) *builder.Instruction {
+	return builder.Ld(dst, builder.Addr(m.addr, off)).Typed(dst.Typ).InSpace(ptx.Global)
+}
+
+// only returns the single access Coalescing reports for m.
+func (m *coalesceKernel) only(t *testing.T, launch Launch) GlobalAccess {
+	t.Helper()
+	m.bb.Add(builder.Ret())
+	got := Coalescing(m.k, launch)
+	if len(got) != 1 {
+		t.Fatalf("accesses %v, want 1", got)
+	}
+	return got[0]
+}
+
+func TestCoalescingSectors(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		typ               ptx.Type
+		stride, offset    int64
+		sectors, ideal    int
+		strided, misalign bool
+		reportedStride    int64
+	}{
+		{"contiguous f32", ptx.F32, 4, 0, 4, 4, false, false, 0},
+		{"contiguous f64", ptx.F64, 8, 0, 8, 8, false, false, 0},
+		{"contiguous u8", ptx.U8, 1, 0, 1, 1, false, false, 0},
+		{"stride 8", ptx.F32, 8, 0, 8, 4, true, false, 8},
+		{"stride 128", ptx.F32, 128, 0, 32, 4, true, false, 128},
+		{"broadcast", ptx.F32, 0, 0, 1, 1, false, false, 0},
+		{"offset by one element", ptx.F32, 4, 4, 5, 4, false, true, 0},
+		{"offset by a sector", ptx.F32, 4, 32, 4, 4, false, false, 0},
+	} {
+		m := newCoalesceKernel(tt.stride, tt.offset)
+		m.bb.Add(m.ld(m.k.NewReg("v", tt.typ), 0))
+		r := m.only(t, Launch{Block: [3]int{64, 1, 1}})
+		if !r.Known || r.Sectors != tt.sectors || r.Ideal != tt.ideal || r.Strided != tt.strided ||
+			r.Misaligned != tt.misalign || (tt.strided && r.Stride != tt.reportedStride) {
+			t.Errorf("%s: %v; want %d sectors (ideal %d), strided %v by %d, misaligned %v",
+				tt.name, r, tt.sectors, tt.ideal, tt.strided, tt.reportedStride, tt.misalign)
+		}
+	}
+}
+
+func TestCoalescingUnknownOffset(t *testing.T) {
+	m := newCoalesceKernel(4, 0)
+	m.bb.Add(builder.Add(m.addr, m.addr, m.off).Typed(ptx.U64))
+	m.bb.Add(m.ld(m.k.NewReg("v", ptx.F32), 0))
+	if r := m.only(t, Launch{Block: [3]int{32, 1, 1}}); !r.Misaligned {
+		t.Errorf("%v: want possibly misaligned", r)
+	}
+}
+
+func TestCoalescingVectors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		stride int64
+		build  func(m *coalesceKernel, x, y, z, w *builder.Register)
+		want   []int // Vector of each access
+	}{
+		{"pair", 8, func(m *coalesceKernel, x, y, z, w *builder.Register) {
+			m.bb.Add(m.ld(x, 0))
+			m.bb.Add(m.ld(y, 4))
+		}, []int{2, 2}},
+		{"quad out of order", 16, func(m *coalesceKernel, x, y, z, w *builder.Register) {
+			m.bb.Add(m.ld(x, 8))
+			m.bb.Add(m.ld(y, 0))
+			m.bb.Add(m.ld(z, 12))
+			m.bb.Add(m.ld(w, 4))
+		}, []int{4, 4, 4, 4}},
+		{"misaligned pair", 8, func(m *coalesceKernel, x, y, z, w *builder.Register) {
+			m.bb.Add(m.ld(x, 4))
+			m.bb.Add(m.ld(y, 8))
+		}, []int{0, 0}},
+		{"independent work between", 8, func(m *coalesceKernel, x, y, z, w *builder.Register) {
+			m.bb.Add(m.ld(x, 0))
+			m.bb.Add(builder.Add(z, w, w).Typed(ptx.F32))
+			m.bb.Add(m.ld(y, 4))
+		}, []int{2, 2}},
+		{"uses the first load", 8, func(m *coalesceKernel, x, y, z, w *builder.Register) {
+			m.bb.Add(m.ld(x, 0))
+			m.bb.Add(builder.Add(z, x, x).Typed(ptx.F32))
+			m.bb.Add(m.ld(y, 4))
+		}, []int{0, 0}},
+		{"redefines the base", 8, func(m *coalesceKernel, x, y, z, w *builder.Register) {
+			m.bb.Add(m.ld(x, 0))
+			m.bb.Add(builder.Add(m.addr, m.addr, builder.Imm(0)).Typed(ptx.U64))
+			m.bb.Add(m.ld(y, 4))
+		}, []int{0, 0}},
+		{"destination read in between", 8, func(m *coalesceKernel, x, y, z, w *builder.Register) {
+			m.bb.Add(m.ld(x, 0))
+			m.bb.Add(builder.Add(z, y, y).Typed(ptx.F32))
+			m.bb.Add(m.ld(y, 4))
+		}, []int{0, 0}},
+		{"store of a value computed in between", 8, func(m *coalesceKernel, x, y, z, w *builder.Register) {
+			m.bb.Add(builder.St(builder.Addr(m.addr, 0), x).Typed(ptx.F32).InSpace(ptx.Global))
+			m.bb.Add(builder.Add(y, x, x).Typed(ptx.F32))
+			m.bb.Add(builder.St(builder.Addr(m.addr, 4), y).Typed(ptx.F32).InSpace(ptx.Global))
+		}, []int{0, 0}},
+		{"store pair", 8, func(m *coalesceKernel, x, y, z, w *builder.Register) {
+			m.bb.Add(builder.St(builder.Addr(m.addr, 0), x).Typed(ptx.F32).InSpace(ptx.Global))
+			m.bb.Add(builder.St(builder.Addr(m.addr, 4), y).Typed(ptx.F32).InSpace(ptx.Global))
+		}, []int{2, 2}},
+	} {
+		m := newCoalesceKernel(tt.stride, 0)
+		regs := [4]*builder.Register{}
+		for i, name := range []string{"x", "y", "z", "w"} {
+			regs[i] = m.k.NewReg(name, ptx.F32)
+		}
+		tt.build(m, regs[0], regs[1], regs[2], regs[3])
+		m.bb.Add(builder.Ret())
+		got := Coalescing(m.k, Launch{Block: [3]int{64, 1, 1}})
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: accesses %v, want %d", tt.name, got, len(tt.want))
+			continue
+		}
+		for i, r := range got {
+			if r.Vector != tt.want[i] || len(r.VectorWith) != max(tt.want[i]-1, 0) {
+				t.Errorf("%s: %v; want vector width %d", tt.name, r, tt.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,778 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// Mbarriers checks the mbarrier protocol of f for a CTA launched with the
+// given block dimensions:
+//
+//   - where sizes are constant, the transaction bytes a path announces with
+//     mbarrier.arrive.expect_tx or mbarrier.expect_tx must equal the bytes
+//     completed on the same mbarrier by cp.async.bulk,
+//     cp.async.bulk.tensor and mbarrier.complete_tx before the next wait on
+//     it and before the kernel exits;
+//   - the arrivals on an mbarrier in one phase, counted over every thread
+//     of the CTA along each path, must equal the count it was initialized
+//     with;
+//   - mbarrier.try_wait and test_wait must use a phase parity that changes
+//     on every iteration of an enclosing loop, or a state returned by an
+//     arrive on the same mbarrier, and their result must be tested.
+//
+// boxBytes gives the bytes one cp.async.bulk.tensor copies, keyed by the
+// name of its tensor-map operand; tensor copies not listed, and multicast
+// copies, are not counted. An mbarrier is identified by the shared
+// variable its address is derived from and the constant offset, so the
+// stages of an mbarrier array share one identity. Guarded expect_tx and
+// copies are assumed to be issued together by one thread.
+func Mbarriers(f *builder.Function, launch Launch, boxBytes map[string]int64) []Finding {
+	g := NewCFG(f)
+	roots := newPointerRoots(f)
+	var findings []Finding
+	findings = append(findings, checkTransactions(g, roots, boxBytes)...)
+	findings = append(findings, checkArrivals(g, roots, launch)...)
+	findings = append(findings, checkPhaseWaits(g, roots)...)
+	sortFindings(findings)
+	return findings
+}
+
+// barrierName identifies the mbarrier an address operand refers to.
+func (r pointerRoots) barrierName(op builder.Operand) string {
+	var off int64
+	if a, ok := op.(*builder.Address); ok {
+		op, off = a.Base, a.Offset
+	}
+	var name string
+	switch o := op.(type) {
+	case *builder.Symbol:
+		name = o.Name
+	case *builder.Register:
+		if roots := r.regs[o]; len(roots) > 0 {
+			names := make([]string, 0, len(roots))
+			for n := range roots {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			name = strings.Join(names, "|")
+		} else {
+			name = o.Name
+		}
+	default:
+		return "?"
+	}
+	if off != 0 {
+		name += fmt.Sprintf("+%d", off)
+	}
+	return name
+}
+
+// mbarrierAddr returns the mbarrier an instruction operates on or signals.
+func mbarrierAddr(inst *builder.Instruction) (builder.Operand, bool) {
+	switch inst.Op {
+	case ptx.OpMbarrierInit, ptx.OpMbarrierInval, ptx.OpMbarrierArrive, ptx.OpMbarrierArriveDrop,
+		ptx.OpMbarrierTestWait, ptx.OpMbarrierTryWait, ptx.OpMbarrierExpectTx, ptx.OpMbarrierCompleteTx,
+		ptx.OpCpAsyncMbarrierArrive:
+		if len(inst.Src) > 0 {
+			return inst.Src[0], true
+		}
+		return nil, false
+	}
+	return bulkMbarrier(inst)
+}
+
+// bulkMbarrier returns the mbarrier a bulk copy into shared memory
+// completes its transactions on.
+func bulkMbarrier(inst *builder.Instruction) (builder.Operand, bool) {
+	if _, group := bulkGroupSource(inst); group {
+		return nil, false
+	}
+	switch inst.Op {
+	case ptx.OpCpAsyncBulk:
+		if len(inst.Src) > 3 {
+			return inst.Src[3], true
+		}
+	case ptx.OpCpAsyncBulkTensor:
+		if i := 2 + tensorDims(inst); len(inst.Src) > i {
+			return inst.Src[i], true
+		}
+	}
+	return nil, false
+}
+
+// tensorDims returns the number of coordinates of a bulk tensor copy.
+func tensorDims(inst *builder.Instruction) int {
+	for _, m := range inst.Modifiers {
+		switch m {
+		case ptx.ModDim1D:
+			return 1
+		case ptx.ModDim2D:
+			return 2
+		case ptx.ModDim3D:
+			return 3
+		case ptx.ModDim4D:
+			return 4
+		case ptx.ModDim5D:
+			return 5
+		}
+	}
+	return 0
+}
+
+func isMbarrierWait(inst *builder.Instruction) bool {
+	return inst.Op == ptx.OpMbarrierTryWait || inst.Op == ptx.OpMbarrierTestWait
+}
+
+// txCount is the transaction bytes a path has announced and completed on
+// one mbarrier since it was last waited on.
+type txCount struct {
+	expected, completed int64
+	known               bool
+}
+
+type txState map[string]txCount
+
+// txChecker balances expect_tx against completed bytes along every path.
+type txChecker struct {
+	roots     pointerRoots
+	boxBytes  map[string]int64
+	conflicts map[int]map[string]bool // block -> mbarriers whose incoming balances disagree
+	found     map[string]Finding
+}
+
+func (c *txChecker) entry() txState { return txState{} }
+
+func (c *txChecker) clone(s txState) txState {
+	out := make(txState, len(s))
+	for k, v := range s {
+		out[k] = v
+	}
+	return out
+}
+
+func (c *txChecker) join(a, b txState, block int) (txState, bool) {
+	changed := false
+	disagree := func(name string) {
+		a[name] = txCount{}
+		changed = true
+		if c.conflicts[block] == nil {
+			c.conflicts[block] = make(map[string]bool)
+		}
+		c.conflicts[block][name] = true
+	}
+	for name, vb := range b {
+		va, ok := a[name]
+		switch {
+		case ok && !va.known:
+		case !vb.known:
+			a[name] = vb
+			changed = true
+		case !ok || va.expected-va.completed != vb.expected-vb.completed:
+			disagree(name)
+		}
+	}
+	for name, va := range a {
+		if _, ok := b[name]; !ok && va.known {
+			disagree(name)
+		}
+	}
+	return a, changed
+}
+
+func (c *txChecker) transfer(s txState, site Site) txState {
+	inst := site.Inst()
+	addr, ok := mbarrierAddr(inst)
+	if !ok {
+		return s
+	}
+	name := c.roots.barrierName(addr)
+	if isMbarrierWait(inst) {
+		// Report an imbalance once, then stop tracking the mbarrier; a
+		// failed try_wait loops back with the same phase outstanding.
+		if t, ok := s[name]; ok && t.known {
+			c.found[fmt.Sprintf("%v|%s", site, name)] = Finding{Site: site, Message: fmt.Sprintf(
+				"mbarrier %s: expect_tx announces %d bytes but %d bytes complete before this wait", name, t.expected, t.completed)}
+			s[name] = txCount{}
+		}
+		return s
+	}
+	expected, completed, counted, known := c.bytes(inst)
+	if !counted {
+		return s
+	}
+	t, ok := s[name]
+	if !ok {
+		t.known = true
+	}
+	t.known = t.known && known
+	t.expected += expected
+	t.completed += completed
+	if t.known && t.expected == t.completed {
+		delete(s, name)
+	} else {
+		s[name] = t
+	}
+	return s
+}
+
+// bytes returns the transaction bytes inst announces or completes, whether
+// it takes part in transaction counting at all, and whether the amount is
+// known.
+func (c *txChecker) bytes(inst *builder.Instruction) (expected, completed int64, counted, known bool) {
+	switch {
+	case inst.Op == ptx.OpMbarrierArrive && hasModifier(inst, ptx.ModExpectTx),
+		inst.Op == ptx.OpMbarrierExpectTx:
+		if len(inst.Src) < 2 {
+			return 0, 0, true, false
+		}
+		n, ok := immInt(inst.Src[1])
+		return n, 0, true, ok
+	case inst.Op == ptx.OpMbarrierCompleteTx:
+		if len(inst.Src) < 2 {
+			return 0, 0, true, false
+		}
+		n, ok := immInt(inst.Src[1])
+		return 0, n, true, ok
+	case inst.Op == ptx.OpCpAsyncBulk:
+		if len(inst.Src) < 3 {
+			return 0, 0, true, false
+		}
+		n, ok := immInt(inst.Src[2])
+		return 0, n, true, ok && !hasModifier(inst, ptx.ModMulticastCluster)
+	case inst.Op == ptx.OpCpAsyncBulkTensor:
+		if len(inst.Src) < 2 {
+			return 0, 0, true, false
+		}
+		n, ok := c.boxBytes[operandName(inst.Src[1])]
+		return 0, n, true, ok && !hasModifier(inst, ptx.ModMulticastCluster)
+	}
+	return 0, 0, false, false
+}
+
+// operandName returns the register or symbol an operand names.
+func operandName(op builder.Operand) string {
+	if a, ok := op.(*builder.Address); ok {
+		op = a.Base
+	}
+	switch o := op.(type) {
+	case *builder.Register:
+		return o.Name
+	case *builder.Symbol:
+		return o.Name
+	}
+	return ""
+}
+
+func checkTransactions(g *CFG, roots pointerRoots, boxBytes map[string]int64) []Finding {
+	c := &txChecker{
+		roots:     roots,
+		boxBytes:  boxBytes,
+		conflicts: make(map[int]map[string]bool),
+		found:     make(map[string]Finding),
+	}
+	in, reached := solve[txState](g, c)
+	found := c.found
+	replay[txState](g, c, in, reached, nil, func(site Site, to int, s txState) {
+		if to != Exit || !g.Func.IsKernel {
+			return
+		}
+		for name, t := range s {
+			if t.known {
+				found[fmt.Sprintf("%v|%s", site, name)] = Finding{Site: site, Message: fmt.Sprintf(
+					"mbarrier %s: expect_tx announces %d bytes but %d bytes complete before the kernel exits",
+					name, t.expected, t.completed)}
+			}
+		}
+	})
+
+	keys := make([]string, 0, len(found))
+	for k := range found {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var findings []Finding
+	for _, k := range keys {
+		findings = append(findings, found[k])
+	}
+	blocks := make([]int, 0, len(c.conflicts))
+	for b := range c.conflicts {
+		blocks = append(blocks, b)
+	}
+	sort.Ints(blocks)
+	for _, b := range blocks {
+		names := make([]string, 0, len(c.conflicts[b]))
+		for name := range c.conflicts[b] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			findings = append(findings, Finding{Site: Site{Func: g.Func, Block: b}, Message: fmt.Sprintf(
+				"mbarrier %s: paths into this block disagree on the transaction bytes outstanding", name)})
+		}
+	}
+	return findings
+}
+
+// arrivals returns how many arrivals one thread executing inst signals.
+// cp.async.mbarrier.arrive without .noinc raises the pending count by the
+// arrival it signals, so it does not count.
+func arrivals(inst *builder.Instruction) (int64, bool) {
+	switch inst.Op {
+	case ptx.OpMbarrierArrive, ptx.OpMbarrierArriveDrop:
+		if hasModifier(inst, ptx.ModExpectTx) || len(inst.Src) < 2 {
+			return 1, true
+		}
+		return immInt(inst.Src[1])
+	case ptx.OpCpAsyncMbarrierArrive:
+		if hasModifier(inst, ptx.ModNoInc) {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// checkArrivals compares the arrivals on each mbarrier in one phase with
+// its init count. A phase is counted along each path from one wait on the
+// mbarrier to the next, so arrives on exclusive paths (the two sides of a
+// uniform branch, or an @p and @!p pair) are alternatives rather than
+// summed. Arrives in different innermost loops, and in different
+// iterations of one loop, belong to different phases.
+func checkArrivals(g *CFG, roots pointerRoots, launch Launch) []Finding {
+	f := g.Func
+	c := &arrivalChecker{
+		sites:     make(map[Site]arrivalSite),
+		waits:     make(map[Site]string),
+		innermost: innermostLoops(g),
+	}
+	inits := make(map[string]int64)
+	conflicting := make(map[string]bool)
+	var arrives []Site
+	for b, bb := range f.Blocks {
+		for i, inst := range bb.Instructions {
+			addr, ok := mbarrierAddr(inst)
+			if !ok {
+				continue
+			}
+			site := Site{Func: f, Block: b, Index: i}
+			name := roots.barrierName(addr)
+			switch {
+			case inst.Op == ptx.OpMbarrierInit:
+				n, ok := int64(0), len(inst.Src) > 1
+				if ok {
+					n, ok = immInt(inst.Src[1])
+				}
+				if prev, seen := inits[name]; !ok || (seen && prev != n) {
+					conflicting[name] = true
+				}
+				inits[name] = n
+			case isMbarrierWait(inst):
+				c.waits[site] = name
+			default:
+				if _, ok := arrivals(inst); ok {
+					c.sites[site] = arrivalSite{name: name}
+					arrives = append(arrives, site)
+				}
+			}
+		}
+	}
+	if len(arrives) == 0 || len(inits) == 0 {
+		return nil
+	}
+
+	// Count the threads of the CTA executing each arrive.
+	threads := make(map[Site]int)
+	exact := make(map[Site]bool)
+	for _, s := range arrives {
+		exact[s] = true
+	}
+	for w := 0; w < launch.Warps(); w++ {
+		masks := newWarpEval(f, launch, w).masks(g)
+		for _, s := range arrives {
+			m, ok := masks[s]
+			if !ok {
+				continue
+			}
+			threads[s] += m.count()
+			exact[s] = exact[s] && m.exact
+		}
+	}
+
+	// Where the lanes could not be worked out, a CTA-uniform condition
+	// still sends every thread the same way: the count holds on each path
+	// through the site, and a uniform guard makes the arrive optional.
+	u := AnalyzeUniformity(f)
+	for _, s := range arrives {
+		a := c.sites[s]
+		inst := s.Inst()
+		n, ok := arrivals(inst)
+		total := n * int64(threads[s])
+		control, _ := u.Control(s)
+		switch {
+		case !ok:
+		case exact[s]:
+			a.alts, a.exact = []int64{total}, true
+		case control != CTAUniform:
+		case inst.Guard == nil:
+			a.alts, a.exact = []int64{total}, true
+		case u.Of(inst.Guard.Reg) == CTAUniform:
+			a.alts, a.exact, a.optional = []int64{0, total}, true, true
+		}
+		c.sites[s] = a
+	}
+	c.pairGuards(arrives)
+
+	in, reached := solve[arrivalState](g, c)
+	found := make(map[string]Finding)
+	c.report = func(name string, p phaseCount) {
+		want, ok := inits[name]
+		if !ok || conflicting[name] || !p.exact {
+			return
+		}
+		for _, t := range p.totals {
+			if t != 0 && t != want {
+				found[fmt.Sprintf("%v|%s|%d", p.first, name, t)] = Finding{Site: p.first, Message: fmt.Sprintf(
+					"mbarrier %s: %d arrivals per phase but mbarrier.init expects %d", name, t, want)}
+			}
+		}
+	}
+	replay[arrivalState](g, c, in, reached, nil, func(_ Site, to int, s arrivalState) {
+		for name, p := range s {
+			if to == Exit || p.loop == to {
+				c.report(name, p)
+			}
+		}
+	})
+
+	keys := make([]string, 0, len(found))
+	for k := range found {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var findings []Finding
+	for _, k := range keys {
+		findings = append(findings, found[k])
+	}
+	return findings
+}
+
+// maxTotals bounds the alternative arrival totals tracked for one
+// mbarrier before the count is given up as inexact.
+const maxTotals = 16
+
+// arrivalSite is what one arrive contributes to its phase.
+type arrivalSite struct {
+	name     string
+	alts     []int64 // arrivals over the CTA, one per exclusive alternative
+	exact    bool
+	optional bool // guarded by a uniform predicate that may be false
+	paired   bool // the second of an @p / @!p pair, counted with the first
+}
+
+// phaseCount is the arrivals on one mbarrier since it was last waited on,
+// on the paths reaching a point.
+type phaseCount struct {
+	totals []int64 // the possible totals, sorted; never modified once built
+	exact  bool
+	first  Site // the arrive that opened the phase
+	loop   int  // innermost loop header of first, or -1
+}
+
+type arrivalState map[string]phaseCount
+
+// arrivalChecker counts arrivals per phase along every path.
+type arrivalChecker struct {
+	sites     map[Site]arrivalSite
+	waits     map[Site]string
+	innermost []int
+	report    func(name string, p phaseCount) // nil while solving
+}
+
+// pairGuards makes an optional arrive and the next arrive on the same
+// mbarrier in its block one pair of alternatives when they are guarded by
+// opposite senses of a predicate that is not written in between.
+func (c *arrivalChecker) pairGuards(arrives []Site) {
+	for i := 0; i+1 < len(arrives); i++ {
+		s, t := arrives[i], arrives[i+1]
+		a, b := c.sites[s], c.sites[t]
+		if s.Block != t.Block || a.name != b.name || !a.optional || !b.optional {
+			continue
+		}
+		gs, gt := s.Inst().Guard, t.Inst().Guard
+		if gs.Reg != gt.Reg || gs.Negate == gt.Negate || c.between(s, t, gs.Reg) {
+			continue
+		}
+		a.alts, a.optional = []int64{a.alts[1], b.alts[1]}, false
+		b.paired = true
+		c.sites[s], c.sites[t] = a, b
+		i++
+	}
+}
+
+// between reports whether an instruction after s and before t, in the
+// same block, writes r or waits on the mbarrier of s.
+func (c *arrivalChecker) between(s, t Site, r *builder.Register) bool {
+	insts := s.Func.Blocks[s.Block].Instructions
+	for i := s.Index; i < t.Index; i++ {
+		if name, ok := c.waits[Site{Func: s.Func, Block: s.Block, Index: i}]; ok && name == c.sites[s].name {
+			return true
+		}
+		written := false
+		forEachOperandReg(insts[i].Dst, func(d *builder.Register) { written = written || d == r })
+		forEachOperandReg(insts[i].Dst2, func(d *builder.Register) { written = written || d == r })
+		if written {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *arrivalChecker) flush(name string, p phaseCount) {
+	if c.report != nil {
+		c.report(name, p)
+	}
+}
+
+func (c *arrivalChecker) entry() arrivalState { return arrivalState{} }
+
+func (c *arrivalChecker) clone(s arrivalState) arrivalState {
+	out := make(arrivalState, len(s))
+	for k, v := range s {
+		out[k] = v
+	}
+	return out
+}
+
+// join unions the totals of a and b. A path that has not arrived on an
+// mbarrier contributes a total of 0. Counts opened in the loop headed by
+// block come round its back edge and were reported there.
+func (c *arrivalChecker) join(a, b arrivalState, block int) (arrivalState, bool) {
+	changed := false
+	merge := func(name string, pa, pb phaseCount) {
+		p := phaseCount{totals: unionTotals(pa.totals, pb.totals), exact: pa.exact && pb.exact, first: pa.first, loop: pa.loop}
+		if !p.exact || len(p.totals) > maxTotals {
+			p.totals, p.exact = nil, false
+		}
+		if p.exact != pa.exact || len(p.totals) != len(pa.totals) {
+			a[name] = p
+			changed = true
+		}
+	}
+	for name, pb := range b {
+		if pb.loop == block {
+			continue
+		}
+		pa, ok := a[name]
+		if !ok {
+			pa = phaseCount{totals: []int64{0}, exact: true, first: pb.first, loop: pb.loop}
+			a[name] = pa
+			changed = true
+		}
+		merge(name, pa, pb)
+	}
+	for name, pa := range a {
+		if pb, ok := b[name]; !ok || pb.loop == block {
+			merge(name, pa, phaseCount{totals: []int64{0}, exact: true})
+		}
+	}
+	return a, changed
+}
+
+func (c *arrivalChecker) transfer(s arrivalState, site Site) arrivalState {
+	if name, ok := c.waits[site]; ok {
+		if p, ok := s[name]; ok {
+			c.flush(name, p)
+			delete(s, name)
+		}
+		return s
+	}
+	a, ok := c.sites[site]
+	if !ok || a.paired {
+		return s
+	}
+	loop := c.innermost[site.Block]
+	p, ok := s[a.name]
+	if ok && p.loop != loop {
+		c.flush(a.name, p)
+		ok = false
+	}
+	if !ok {
+		p = phaseCount{totals: []int64{0}, exact: true, first: site, loop: loop}
+	}
+	if p.exact && a.exact {
+		var totals []int64
+		for _, t := range p.totals {
+			for _, n := range a.alts {
+				totals = unionTotals(totals, []int64{t + n})
+			}
+		}
+		p.totals = totals
+	}
+	if !p.exact || !a.exact || len(p.totals) > maxTotals {
+		p.totals, p.exact = nil, false
+	}
+	s[a.name] = p
+	return s
+}
+
+// unionTotals returns the sorted union of two sorted total lists.
+func unionTotals(a, b []int64) []int64 {
+	out := make([]int64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i] < b[j]):
+			out = append(out, a[i])
+			i++
+		case i == len(a) || b[j] < a[i]:
+			out = append(out, b[j])
+			j++
+		default:
+			out = append(out, a[i])
+			i, j = i+1, j+1
+		}
+	}
+	return out
+}
+
+// innermostLoops returns, for every block, the header of the smallest
+// natural loop containing it, or -1.
+func innermostLoops(g *CFG) []int {
+	inner := make([]int, len(g.Func.Blocks))
+	size := make([]int, len(g.Func.Blocks))
+	for b := range inner {
+		inner[b] = -1
+	}
+	for _, l := range g.loops() {
+		n := 0
+		for _, in := range l.blocks {
+			if in {
+				n++
+			}
+		}
+		for b, in := range l.blocks {
+			if in && (inner[b] < 0 || n < size[b]) {
+				inner[b], size[b] = l.header, n
+			}
+		}
+	}
+	return inner
+}
+
+// checkPhaseWaits checks the parity or state operand of every try_wait and
+// test_wait, and that its result is used.
+func checkPhaseWaits(g *CFG, roots pointerRoots) []Finding {
+	f := g.Func
+	defs := make(map[*builder.Register][]Site)
+	used := make(map[*builder.Register]bool)
+	for b, bb := range f.Blocks {
+		for i, inst := range bb.Instructions {
+			if pseudo(inst) {
+				continue
+			}
+			site := Site{Func: f, Block: b, Index: i}
+			forEachOperandReg(inst.Dst, func(r *builder.Register) { defs[r] = append(defs[r], site) })
+			forEachOperandReg(inst.Dst2, func(r *builder.Register) { defs[r] = append(defs[r], site) })
+			for _, src := range inst.Src {
+				forEachOperandReg(src, func(r *builder.Register) { used[r] = true })
+			}
+			if inst.Guard != nil {
+				used[inst.Guard.Reg] = true
+			}
+		}
+	}
+	loops := g.loops()
+
+	var findings []Finding
+	for b, bb := range f.Blocks {
+		for i, inst := range bb.Instructions {
+			if !isMbarrierWait(inst) || len(inst.Src) < 2 {
+				continue
+			}
+			site := Site{Func: f, Block: b, Index: i}
+			name := roots.barrierName(inst.Src[0])
+			result, _ := inst.Dst.(*builder.Register)
+			if result != nil && !used[result] {
+				findings = append(findings, Finding{Site: site, Message: fmt.Sprintf(
+					"%s result %s is never tested; the phase may not have completed", inst.Op, result.Name)})
+			}
+
+			if !hasModifier(inst, ptx.ModParity) {
+				state, ok := inst.Src[1].(*builder.Register)
+				if !ok {
+					continue
+				}
+				for _, d := range defs[state] {
+					def := d.Inst()
+					addr, isArrive := mbarrierAddr(def)
+					if _, counts := arrivals(def); !isArrive || !counts || roots.barrierName(addr) != name {
+						findings = append(findings, Finding{Site: site, Message: fmt.Sprintf(
+							"state %s is not returned by an arrive on mbarrier %s (set at %s)", state.Name, name, d)})
+						break
+					}
+				}
+				continue
+			}
+
+			parity := inst.Src[1]
+			if c, ok := immInt(parity); ok && c != 0 && c != 1 {
+				findings = append(findings, Finding{Site: site, Message: fmt.Sprintf(
+					"phase parity %d must be 0 or 1", c)})
+				continue
+			}
+			for _, l := range loops {
+				if !l.blocks[b] || retries(g, l, result) || changesIn(l, parity, defs) {
+					continue
+				}
+				findings = append(findings, Finding{Site: site, Message: fmt.Sprintf(
+					"phase parity on mbarrier %s is the same in every iteration of the loop closed at %s", name, l.back)})
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// retries reports whether l is the retry loop of a wait whose result is
+// result: a branch on that predicate closes the loop or leaves it.
+func retries(g *CFG, l loop, result *builder.Register) bool {
+	if result == nil {
+		return false
+	}
+	for b, in := range l.blocks {
+		if !in {
+			continue
+		}
+		for i, inst := range g.Func.Blocks[b].Instructions {
+			if inst.Op != ptx.OpBra || inst.Guard == nil || inst.Guard.Reg != result {
+				continue
+			}
+			if (Site{Func: g.Func, Block: b, Index: i}) == l.back {
+				return true
+			}
+			if to, ok := g.target(inst); ok && (to == Exit || !l.blocks[to]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// changesIn reports whether op may hold a different value in each
+// iteration of l.
+func changesIn(l loop, op builder.Operand, defs map[*builder.Register][]Site) bool {
+	r, ok := op.(*builder.Register)
+	if !ok {
+		return false
+	}
+	for _, d := range defs[r] {
+		if l.blocks[d.Block] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// mbarKernel is a kernel with a shared mbarrier bar initialized to count,
+// %tid.x in tid and a CTA-uniform predicate flag loaded from a parameter.
+type mbarKernel struct {
+	k          *builder.Function
+	bb         *builder.BasicBlock
+	bar        builder.Operand
+	tid        *builder.Register
+	flag, done *builder.Register
+}
+
+func newMbarKernel(count int64) *mbarKernel {
+	mod := builder.NewModule(ptx.ISA80, ptx.SM90)
+	k := mod.NewKernel("k")
+	k.AddParam(builder.NewParam("f", ptx.U32))
+	k.NewLocal("bar", ptx.Shared, ptx.B64)
+	m := &mbarKernel{
+		k:    k,
+		bar:  builder.Addr(builder.Sym("bar"), 0),
+		tid:  k.NewReg("tid", ptx.U32),
+		flag: k.NewReg("flag", ptx.Pred),
+		done: k.NewReg("done", ptx.Pred),
+	}
+	f := k.NewReg("fv", ptx.U32)
+	m.bb = k.NewBlock("entry")
+	m.bb.Add(builder.Mov(m.tid, builder.SReg(ptx.RegTidX)).Typed(ptx.U32))
+	m.bb.Add(builder.Ld(f, k.Param("f")).Typed(ptx.U32).InSpace(ptx.Param))
+	m.bb.Add(builder.Setp(ptx.CmpNe, m.flag, f, builder.Imm(0)).Typed(ptx.U32))
+	m.bb.Add(builder.MbarrierInit(m.bar, builder.Imm(count)).InSpace(ptx.Shared).Typed(ptx.B64))
+	return m
+}
+
+func (m *mbarKernel) arrive() *builder.Instruction {
+	return builder.MbarrierArrive(m.bar).InSpace(ptx.Shared).Typed(ptx.B64)
+}
+
+// wait adds a block that retries try_wait with parity until it succeeds.
+func (m *mbarKernel) wait(label string, parity builder.Operand) *builder.BasicBlock {
+	m.bb = m.k.NewBlock(label)
+	m.bb.Add(builder.MbarrierTryWait(m.done, m.bar, parity, nil).WithMod(ptx.ModParity).InSpace(ptx.Shared).Typed(ptx.B64))
+	m.bb.Add(builder.Bra(label).PredNot(m.done))
+	return m.bb
+}
+
+func (m *mbarKernel) end() *builder.Function {
+	m.k.NewBlock("end").Add(builder.Ret())
+	return m.k
+}
+
+var mbarLaunch = Launch{Block: [3]int{128, 1, 1}}
+
+func checkFindings(t *testing.T, name string, got []Finding, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: findings %v, want %d", name, got, len(want))
+		return
+	}
+	for i, f := range got {
+		if !strings.Contains(f.Message, want[i]) {
+			t.Errorf("%s: finding %q, want one containing %q", name, f.Message, want[i])
+		}
+	}
+}
+
+func TestMbarrierExpectTx(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		copies []int64
+		want   []string
+	}{
+		{"balanced", []int64{2048, 2048}, nil},
+		{"short", []int64{2048}, []string{"expect_tx announces 4096 bytes but 2048 bytes complete before this wait"}},
+		{"over", []int64{2048, 2048, 1024}, []string{"expect_tx announces 0 bytes but 1024 bytes complete before this wait"}},
+	} {
+		m := newMbarKernel(1)
+		p := m.k.NewReg("p", ptx.Pred)
+		state := m.k.NewReg("state", ptx.B64)
+		m.bb.Add(builder.Setp(ptx.CmpEq, p, m.tid, builder.Imm(0)).Typed(ptx.U32))
+		m.bb.Add(builder.MbarrierArriveExpectTx(state, m.bar, builder.Imm(4096)).InSpace(ptx.Shared).Typed(ptx.B64).Pred(p))
+		for i, n := range tt.copies {
+			dst := builder.Addr(builder.Sym("tile"), int64(i)*2048)
+			m.bb.Add(builder.CpAsyncBulk(dst, builder.Addr(builder.Sym("src"), 0), builder.Imm(n), m.bar).
+				WithMod(ptx.ModSpaceSharedCluster, ptx.ModSpaceGlobal, ptx.ModMbarrierCompleteTxBytes).Pred(p))
+		}
+		m.wait("wait", builder.Imm(0))
+		checkFindings(t, tt.name, Mbarriers(m.end(), mbarLaunch, nil), tt.want...)
+	}
+}
+
+func TestMbarrierArrivals(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		count int64
+		build func(m *mbarKernel)
+		want  []string
+	}{
+		{"every thread", 128, func(m *mbarKernel) {
+			m.bb.Add(m.arrive())
+		}, nil},
+		{"every thread, init too low", 64, func(m *mbarKernel) {
+			m.bb.Add(m.arrive())
+		}, []string{"128 arrivals per phase but mbarrier.init expects 64"}},
+		{"one thread", 1, func(m *mbarKernel) {
+			p := m.k.NewReg("p", ptx.Pred)
+			m.bb.Add(builder.Setp(ptx.CmpEq, p, m.tid, builder.Imm(0)).Typed(ptx.U32))
+			m.bb.Add(m.arrive().Pred(p))
+		}, nil},
+		{"split by thread", 128, func(m *mbarKernel) {
+			p := m.k.NewReg("p", ptx.Pred)
+			m.bb.Add(builder.Setp(ptx.CmpLt, p, m.tid, builder.Imm(32)).Typed(ptx.U32))
+			m.bb.Add(m.arrive().Pred(p))
+			m.bb.Add(m.arrive().PredNot(p))
+		}, nil},
+		{"uniform @p and @!p", 128, func(m *mbarKernel) {
+			m.bb.Add(m.arrive().Pred(m.flag))
+			m.bb.Add(m.arrive().PredNot(m.flag))
+		}, nil},
+		{"uniform @p and @!p, init too high", 256, func(m *mbarKernel) {
+			m.bb.Add(m.arrive().Pred(m.flag))
+			m.bb.Add(m.arrive().PredNot(m.flag))
+		}, []string{"128 arrivals per phase but mbarrier.init expects 256"}},
+		{"uniform @p twice", 128, func(m *mbarKernel) {
+			m.bb.Add(m.arrive().Pred(m.flag))
+			m.bb.Add(m.arrive().Pred(m.flag))
+		}, []string{"256 arrivals per phase"}},
+		{"uniform if/else", 128, func(m *mbarKernel) {
+			m.bb.Add(builder.Bra("else").Pred(m.flag))
+			m.k.NewBlock("then").Add(m.arrive()).Add(builder.Bra("join"))
+			m.bb = m.k.NewBlock("else")
+			m.bb.Add(m.arrive())
+			m.bb = m.k.NewBlock("join")
+		}, nil},
+		{"uniform if/else, one side twice", 128, func(m *mbarKernel) {
+			m.bb.Add(builder.Bra("else").Pred(m.flag))
+			m.k.NewBlock("then").Add(m.arrive()).Add(builder.Bra("join"))
+			m.bb = m.k.NewBlock("else")
+			m.bb.Add(m.arrive())
+			m.bb.Add(m.arrive())
+			m.bb = m.k.NewBlock("join")
+		}, []string{"256 arrivals per phase but mbarrier.init expects 128"}},
+		{"uniform branch around the arrive", 128, func(m *mbarKernel) {
+			m.bb.Add(builder.Bra("join").Pred(m.flag))
+			m.k.NewBlock("then").Add(m.arrive())
+			m.bb = m.k.NewBlock("join")
+		}, nil},
+	} {
+		m := newMbarKernel(tt.count)
+		tt.build(m)
+		m.wait("wait", builder.Imm(0))
+		checkFindings(t, tt.name, Mbarriers(m.end(), mbarLaunch, nil), tt.want...)
+	}
+}
+
+// loopKernel arrives and waits on bar in each of 8 iterations. The wait
+// parity starts at 0 and, if toggle is set, flips after every wait.
+func loopKernel(count int64, toggle bool) *builder.Function {
+	m := newMbarKernel(count)
+	phase := m.k.NewReg("phase", ptx.U32)
+	i := m.k.NewReg("i", ptx.U32)
+	p := m.k.NewReg("p", ptx.Pred)
+	m.bb.Add(builder.Mov(phase, builder.Imm(0)).Typed(ptx.U32))
+	m.bb.Add(builder.Mov(i, builder.Imm(0)).Typed(ptx.U32))
+	m.k.NewBlock("loop").Add(m.arrive())
+	m.wait("wait", phase)
+	if toggle {
+		m.bb.Add(builder.Xor(phase, phase, builder.Imm(1)).Typed(ptx.B32))
+	}
+	m.bb.Add(builder.Add(i, i, builder.Imm(1)).Typed(ptx.U32))
+	m.bb.Add(builder.Setp(ptx.CmpLt, p, i, builder.Imm(8)).Typed(ptx.U32))
+	m.bb.Add(builder.Bra("loop").Pred(p))
+	return m.end()
+}
+
+func TestMbarrierLoop(t *testing.T) {
+	checkFindings(t, "toggled parity", Mbarriers(loopKernel(128, true), mbarLaunch, nil))
+	checkFindings(t, "fixed parity", Mbarriers(loopKernel(128, false), mbarLaunch, nil),
+		"phase parity on mbarrier bar is the same in every iteration of the loop")
+	// Each iteration is its own phase; arrivals are not summed over them.
+	checkFindings(t, "init too low", Mbarriers(loopKernel(64, true), mbarLaunch, nil),
+		"128 arrivals per phase but mbarrier.init expects 64")
+}
@@ -0,0 +1,60 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// waitKernel arrives on an mbarrier and waits for the phase with the
+// returned state, declaring the state in a nested scope if scoped is set.
+func waitKernel(scoped bool) *builder.Function {
+	mod := builder.NewModule(ptx.ISA80, ptx.SM90)
+	k := mod.NewKernel("k")
+	k.NewLocal("bar", ptx.Shared, ptx.B64)
+	bar := builder.Addr(builder.Sym("bar"), 0)
+	tid := k.NewReg("tid", ptx.U32)
+	p := k.NewReg("p", ptx.Pred)
+	entry := k.NewBlock("entry")
+	entry.Add(builder.Mov(tid, builder.SReg(ptx.RegTidX)).Typed(ptx.U32))
+	entry.Add(builder.MbarrierInit(bar, builder.Imm(32)).InSpace(ptx.Shared).Typed(ptx.B64))
+
+	var state *builder.Register
+	var scope *builder.Scope
+	if scoped {
+		scope = entry.OpenScope()
+		state = scope.NewReg("state", ptx.B64)
+	} else {
+		state = k.NewReg("state", ptx.B64)
+	}
+	entry.Add(&builder.Instruction{Op: ptx.OpMbarrierArrive, Space: ptx.Shared, Typ: ptx.B64, Dst: state, Src: []builder.Operand{bar}})
+	wait := k.NewBlock("wait")
+	wait.Add(builder.MbarrierTestWait(p, bar, state).InSpace(ptx.Shared).Typed(ptx.B64))
+	wait.Add(builder.Bra("wait").PredNot(p))
+	if scoped {
+		scope.Close()
+	}
+	k.NewBlock("done").Add(builder.Ret())
+	return k
+}
+
+// TestScopedDeclarations checks that a register declared in a nested scope
+// is not taken as defined by its declaration.
+func TestScopedDeclarations(t *testing.T) {
+	launch := Launch{Block: [3]int{32, 1, 1}}
+	if got := Mbarriers(waitKernel(false), launch, nil); len(got) != 0 {
+		t.Fatalf("unscoped kernel: unexpected findings %v", got)
+	}
+	if got := Mbarriers(waitKernel(true), launch, nil); len(got) != 0 {
+		t.Errorf("scoped kernel: unexpected findings %v", got)
+	}
+
+	k := waitKernel(true)
+	u := AnalyzeUniformity(k)
+	for r, level := range u.Registers {
+		if r.Name == "%state" && level != CTAUniform {
+			t.Errorf("%%state is %v, want %v", level, CTAUniform)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package analysis
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// TensorMemory checks how f manages tcgen05 tensor memory. Every path
+// through f is followed with the allocations not yet freed and the
+// tcgen05.ld/st not yet waited for. It reports:
+//
+//   - tcgen05.alloc not matched by a tcgen05.dealloc on some path to exit
+//     or ret, and a dealloc whose cta_group or column count differs from
+//     every allocation still outstanding;
+//   - an alloc that runs again before its previous allocation is freed,
+//     and an alloc after tcgen05.relinquish_alloc_permit;
+//   - any access to the destination registers of a tcgen05.ld before
+//     tcgen05.wait::ld, and writes to the source registers of a tcgen05.st
+//     before tcgen05.wait::st.
+//
+// Column counts are compared as written: two registers match only if
+// they are the same register.
+func TensorMemory(f *builder.Function) []Finding {
+	g := NewCFG(f)
+	c := &tmemChecker{index: make(map[Site]int)}
+	for b, bb := range f.Blocks {
+		for i, inst := range bb.Instructions {
+			switch inst.Op {
+			case ptx.OpTcgen05Alloc, ptx.OpTcgen05Dealloc, ptx.OpTcgen05RelinquishAllocPermit,
+				ptx.OpTcgen05Ld, ptx.OpTcgen05St:
+				site := Site{Func: f, Block: b, Index: i}
+				c.index[site] = len(c.sites)
+				c.sites = append(c.sites, site)
+			}
+		}
+	}
+	if len(c.sites) == 0 {
+		return nil
+	}
+
+	found := make(map[string]Finding)
+	c.report = func(key string, site Site, format string, args ...any) {
+		found[key] = Finding{Site: site, Message: fmt.Sprintf(format, args...)}
+	}
+	in, reached := solve[[]tmemPath](g, c)
+	c.reporting = true
+	replay[[]tmemPath](g, c, in, reached, c.checkRegisters, func(site Site, to int, s []tmemPath) {
+		if to != Exit {
+			return
+		}
+		for _, p := range s {
+			for _, i := range p.allocs {
+				c.report(fmt.Sprintf("leak|%d", i), c.sites[i],
+					"tcgen05.alloc is not deallocated on the path leaving the function at %s", site)
+			}
+		}
+	})
+
+	keys := make([]string, 0, len(found))
+	for k := range found {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	findings := make([]Finding, 0, len(keys))
+	for _, k := range keys {
+		findings = append(findings, found[k])
+	}
+	sortFindings(findings)
+	return findings
+}
+
+// maxTmemPaths bounds the distinct paths kept per program point.
+const maxTmemPaths = 32
+
+// tmemPath is one path's view of tensor memory, as indices into
+// tmemChecker.sites, together with the predicates the path has branched
+// on. Paths are never modified once built.
+type tmemPath struct {
+	allocs     []int // outstanding allocations, in the order they were made
+	relinquish int   // the relinquish_alloc_permit that ran, or -1
+	loads      []int // tcgen05.ld not yet waited for
+	stores     []int // tcgen05.st not yet waited for
+	facts      []predFact
+}
+
+// predFact records the value a predicate register holds on a path since
+// it was last written.
+type predFact struct {
+	reg   *builder.Register
+	value bool
+}
+
+func (p tmemPath) key() string {
+	var facts []string
+	for _, f := range p.facts {
+		facts = append(facts, fmt.Sprintf("%s=%v", f.reg.Name, f.value))
+	}
+	sort.Strings(facts)
+	return fmt.Sprint(p.allocs, p.relinquish, p.loads, p.stores, facts)
+}
+
+// assume returns p restricted to paths where r holds v, or false if p
+// already knows r holds !v.
+func (p tmemPath) assume(r *builder.Register, v bool) (tmemPath, bool) {
+	for _, f := range p.facts {
+		if f.reg == r {
+			return p, f.value == v
+		}
+	}
+	p.facts = append(append([]predFact(nil), p.facts...), predFact{reg: r, value: v})
+	return p, true
+}
+
+// kill forgets what p knows about the registers inst writes.
+func (p tmemPath) kill(inst *builder.Instruction) tmemPath {
+	var facts []predFact
+	for _, f := range p.facts {
+		if operandIs(inst.Dst, f.reg) || operandIs(inst.Dst2, f.reg) {
+			continue
+		}
+		facts = append(facts, f)
+	}
+	if len(facts) != len(p.facts) {
+		p.facts = facts
+	}
+	return p
+}
+
+func operandIs(op builder.Operand, r *builder.Register) bool {
+	found := false
+	forEachOperandReg(op, func(o *builder.Register) { found = found || o == r })
+	return found
+}
+
+// tmemChecker follows tensor memory allocations and tcgen05.ld/st through
+// a function. Reports are only made while replaying the fixed point.
+type tmemChecker struct {
+	sites     []Site
+	index     map[Site]int
+	reporting bool
+	report    func(key string, site Site, format string, args ...any)
+}
+
+func (c *tmemChecker) entry() []tmemPath { return []tmemPath{{relinquish: -1}} }
+
+func (c *tmemChecker) clone(s []tmemPath) []tmemPath { return append([]tmemPath(nil), s...) }
+
+func (c *tmemChecker) join(a, b []tmemPath, _ int) ([]tmemPath, bool) {
+	out := canonicalTmemPaths(append(c.clone(a), b...))
+	if len(out) != len(a) {
+		return out, true
+	}
+	for i := range out {
+		if out[i].key() != a[i].key() {
+			return out, true
+		}
+	}
+	return a, false
+}
+
+func (c *tmemChecker) transfer(s []tmemPath, site Site) []tmemPath {
+	inst := site.Inst()
+	var out []tmemPath
+	for _, p := range s {
+		p = p.kill(inst)
+		next := p
+		switch inst.Op {
+		case ptx.OpTcgen05Alloc, ptx.OpTcgen05Dealloc, ptx.OpTcgen05RelinquishAllocPermit,
+			ptx.OpTcgen05Ld, ptx.OpTcgen05St, ptx.OpTcgen05Wait:
+		default:
+			out = append(out, p)
+			continue
+		}
+		if g := inst.Guard; g != nil {
+			if skipped, ok := p.assume(g.Reg, g.Negate); ok {
+				out = append(out, skipped)
+			}
+			var ok bool
+			if next, ok = p.assume(g.Reg, !g.Negate); !ok {
+				continue
+			}
+		}
+		out = append(out, c.apply(next, site))
+	}
+	return canonicalTmemPaths(out)
+}
+
+// apply runs a tensor memory instruction on one path.
+func (c *tmemChecker) apply(p tmemPath, site Site) tmemPath {
+	inst := site.Inst()
+	switch inst.Op {
+	case ptx.OpTcgen05Alloc:
+		i := c.index[site]
+		if p.relinquish >= 0 {
+			c.warn(fmt.Sprintf("%v|relinquish", site), site,
+				"tcgen05.alloc after tcgen05.relinquish_alloc_permit at %s", c.sites[p.relinquish])
+		}
+		if slices.Contains(p.allocs, i) {
+			c.warn(fmt.Sprintf("%v|again", site), site,
+				"tcgen05.alloc runs again before its previous allocation is deallocated")
+			return p
+		}
+		p.allocs = append(append([]int(nil), p.allocs...), i)
+	case ptx.OpTcgen05Dealloc:
+		p.allocs = c.dealloc(p.allocs, site)
+	case ptx.OpTcgen05RelinquishAllocPermit:
+		p.relinquish = c.index[site]
+	case ptx.OpTcgen05Ld:
+		p.loads = unionInts(p.loads, []int{c.index[site]})
+	case ptx.OpTcgen05St:
+		p.stores = unionInts(p.stores, []int{c.index[site]})
+	case ptx.OpTcgen05Wait:
+		switch {
+		case hasModifier(inst, ptx.ModWaitLd):
+			p.loads = nil
+		case hasModifier(inst, ptx.ModWaitSt):
+			p.stores = nil
+		}
+	}
+	return p
+}
+
+// branch keeps the paths on which a guarded branch goes the given way.
+func (c *tmemChecker) branch(s []tmemPath, site Site, taken bool) []tmemPath {
+	g := site.Inst().Guard
+	var out []tmemPath
+	for _, p := range s {
+		if next, ok := p.assume(g.Reg, taken != g.Negate); ok {
+			out = append(out, next)
+		}
+	}
+	return canonicalTmemPaths(out)
+}
+
+// dealloc frees an outstanding allocation. The address operand is loaded
+// back from shared memory and cannot be traced to its alloc, so the dealloc
+// frees the most recent allocation with the same cta_group and column
+// count. With none, the mismatch is reported and the most recent
+// allocation is taken as the one freed.
+func (c *tmemChecker) dealloc(allocs []int, site Site) []int {
+	if len(allocs) == 0 {
+		c.warn(fmt.Sprintf("%v|none", site), site, "tcgen05.dealloc with no allocation outstanding on some path")
+		return allocs
+	}
+	inst := site.Inst()
+	for j := len(allocs) - 1; j >= 0; j-- {
+		alloc := c.sites[allocs[j]].Inst()
+		if ctaGroup(alloc) == ctaGroup(inst) && columns(alloc) == columns(inst) {
+			return slices.Delete(slices.Clone(allocs), j, j+1)
+		}
+	}
+	last := len(allocs) - 1
+	alloc := c.sites[allocs[last]].Inst()
+	if len(allocs) == 1 {
+		c.warn(fmt.Sprintf("%v|%d", site, allocs[last]), site,
+			"tcgen05.dealloc%s of %s columns does not match tcgen05.alloc%s of %s columns at %s",
+			ctaGroup(inst), columns(inst), ctaGroup(alloc), columns(alloc), c.sites[allocs[last]])
+	} else {
+		c.warn(fmt.Sprintf("%v|%d", site, allocs[last]), site,
+			"tcgen05.dealloc%s of %s columns matches none of the %d outstanding allocations; the most recent is tcgen05.alloc%s of %s columns at %s",
+			ctaGroup(inst), columns(inst), len(allocs), ctaGroup(alloc), columns(alloc), c.sites[allocs[last]])
+	}
+	return slices.Delete(slices.Clone(allocs), last, last+1)
+}
+
+func (c *tmemChecker) warn(key string, site Site, format string, args ...any) {
+	if c.reporting {
+		c.report(key, site, format, args...)
+	}
+}
+
+// checkRegisters reports accesses to the registers of tcgen05.ld/st that
+// have not been waited for.
+func (c *tmemChecker) checkRegisters(site Site, s []tmemPath) {
+	inst := site.Inst()
+	if inst.Op == ptx.OpTcgen05Wait {
+		return
+	}
+	written := make(map[*builder.Register]bool)
+	forEachOperandReg(inst.Dst, func(r *builder.Register) { written[r] = true })
+	forEachOperandReg(inst.Dst2, func(r *builder.Register) { written[r] = true })
+	for _, p := range s {
+		for _, i := range p.loads {
+			forEachOperandReg(c.sites[i].Inst().Dst, func(r *builder.Register) {
+				used := written[r]
+				forEachReg(inst, func(o *builder.Register) { used = used || o == r })
+				if used {
+					c.report(fmt.Sprintf("%v|%d|%s", site, i, r.Name), site,
+						"%s uses %s loaded by tcgen05.ld at %s before tcgen05.wait::ld", inst.Op, r.Name, c.sites[i])
+				}
+			})
+		}
+		for _, i := range p.stores {
+			src := c.sites[i].Inst().Src
+			forEachOperandReg(src[len(src)-1], func(r *builder.Register) {
+				if written[r] {
+					c.report(fmt.Sprintf("%v|%d|%s", site, i, r.Name), site,
+						"%s overwrites %s stored by tcgen05.st at %s before tcgen05.wait::st", inst.Op, r.Name, c.sites[i])
+				}
+			})
+		}
+	}
+}
+
+// ctaGroup returns the cta_group modifier of inst; cta_group::1 is the
+// default.
+func ctaGroup(inst *builder.Instruction) ptx.Modifier {
+	if hasModifier(inst, ptx.ModCtaGroup2) {
+		return ptx.ModCtaGroup2
+	}
+	return ptx.ModCtaGroup1
+}
+
+// columns returns the column count operand of an alloc or dealloc as
+// written.
+func columns(inst *builder.Instruction) string {
+	if len(inst.Src) < 2 {
+		return "?"
+	}
+	if n, ok := immInt(inst.Src[1]); ok {
+		return fmt.Sprint(n)
+	}
+	if name := operandName(inst.Src[1]); name != "" {
+		return name
+	}
+	return "?"
+}
+
+// canonicalTmemPaths removes duplicate paths and orders them by key.
+// Beyond maxTmemPaths, all paths are merged into one that keeps every
+// allocation, relinquish and pending access of any of them, and no facts.
+func canonicalTmemPaths(paths []tmemPath) []tmemPath {
+	byKey := make(map[string]tmemPath)
+	for _, p := range paths {
+		byKey[p.key()] = p
+	}
+	if len(byKey) > maxTmemPaths {
+		merged := tmemPath{relinquish: -1}
+		for _, p := range byKey {
+			for _, i := range p.allocs {
+				if !slices.Contains(merged.allocs, i) {
+					merged.allocs = append(merged.allocs, i)
+				}
+			}
+			if merged.relinquish < 0 || (p.relinquish >= 0 && p.relinquish < merged.relinquish) {
+				merged.relinquish = p.relinquish
+			}
+			merged.loads = unionInts(merged.loads, p.loads)
+			merged.stores = unionInts(merged.stores, p.stores)
+		}
+		sort.Ints(merged.allocs)
+		return []tmemPath{merged}
+	}
+	keys := make([]string, 0, len(byKey))
+	for k := range byKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]tmemPath, len(keys))
+	for i, k := range keys {
+		out[i] = byKey[k]
+	}
+	return out
+}
@@ -0,0 +1,179 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// tmemKernel is a kernel holding the tensor memory address in shared
+// memory at slot and a CTA-uniform predicate flag loaded from a parameter.
+type tmemKernel struct {
+	k    *builder.Function
+	bb   *builder.BasicBlock
+	slot builder.Operand
+	addr *builder.Register
+	flag *builder.Register
+}
+
+func newTmemKernel() *tmemKernel {
+	mod := builder.NewModule(ptx.ISA86, ptx.SM100)
+	k := mod.NewKernel("k")
+	k.AddParam(builder.NewParam("f", ptx.U32))
+	k.NewLocal("slot", ptx.Shared, ptx.B32)
+	m := &tmemKernel{
+		k:    k,
+		slot: builder.Addr(builder.Sym("slot"), 0),
+		addr: k.NewReg("taddr", ptx.B32),
+		flag: k.NewReg("flag", ptx.Pred),
+	}
+	f := k.NewReg("fv", ptx.U32)
+	m.bb = k.NewBlock("entry")
+	m.bb.Add(builder.Ld(f, k.Param("f")).Typed(ptx.U32).InSpace(ptx.Param))
+	m.bb.Add(builder.Setp(ptx.CmpNe, m.flag, f, builder.Imm(0)).Typed(ptx.U32))
+	return m
+}
+
+func (m *tmemKernel) alloc(group ptx.Modifier, cols int64) *builder.Instruction {
+	return builder.Tcgen05Alloc(group, m.slot, builder.Imm(cols))
+}
+
+func (m *tmemKernel) dealloc(group ptx.Modifier, cols int64) *builder.Instruction {
+	return builder.Tcgen05Dealloc(group, m.addr, builder.Imm(cols))
+}
+
+func (m *tmemKernel) end() *builder.Function {
+	m.k.NewBlock("end").Add(builder.Ret())
+	return m.k
+}
+
+func TestTensorMemoryAllocations(t *testing.T) {
+	g1, g2 := ptx.ModCtaGroup1, ptx.ModCtaGroup2
+	for _, tt := range []struct {
+		name  string
+		build func(m *tmemKernel)
+		want  []string
+	}{
+		{"paired", func(m *tmemKernel) {
+			m.bb.Add(m.alloc(g1, 32))
+			m.bb.Add(m.dealloc(g1, 32))
+		}, nil},
+		{"nested", func(m *tmemKernel) {
+			m.bb.Add(m.alloc(g1, 32))
+			m.bb.Add(m.alloc(g1, 64))
+			m.bb.Add(m.dealloc(g1, 32))
+			m.bb.Add(m.dealloc(g1, 64))
+		}, nil},
+		{"leak", func(m *tmemKernel) {
+			m.bb.Add(m.alloc(g1, 32))
+		}, []string{"tcgen05.alloc is not deallocated on the path leaving the function"}},
+		{"leak on one path", func(m *tmemKernel) {
+			m.bb.Add(m.alloc(g1, 32))
+			m.bb.Add(builder.Bra("end").Pred(m.flag))
+			m.k.NewBlock("free").Add(m.dealloc(g1, 32))
+		}, []string{"tcgen05.alloc is not deallocated on the path leaving the function"}},
+		{"uniform @p and @!p", func(m *tmemKernel) {
+			m.bb.Add(m.alloc(g1, 32).Pred(m.flag))
+			m.bb.Add(m.dealloc(g1, 32).Pred(m.flag))
+		}, nil},
+		{"dealloc without alloc", func(m *tmemKernel) {
+			m.bb.Add(m.dealloc(g1, 32))
+		}, []string{"tcgen05.dealloc with no allocation outstanding"}},
+		{"column mismatch", func(m *tmemKernel) {
+			m.bb.Add(m.alloc(g1, 32))
+			m.bb.Add(m.dealloc(g1, 64))
+		}, []string{"tcgen05.dealloc.cta_group::1 of 64 columns does not match tcgen05.alloc.cta_group::1 of 32 columns"}},
+		{"cta_group mismatch", func(m *tmemKernel) {
+			m.bb.Add(m.alloc(g1, 32))
+			m.bb.Add(m.dealloc(g2, 32))
+		}, []string{"tcgen05.dealloc.cta_group::2 of 32 columns does not match"}},
+		{"mismatch with several outstanding", func(m *tmemKernel) {
+			m.bb.Add(m.alloc(g1, 32))
+			m.bb.Add(m.alloc(g1, 64))
+			m.bb.Add(m.dealloc(g1, 128))
+			m.bb.Add(m.dealloc(g1, 32))
+		}, []string{"tcgen05.dealloc.cta_group::1 of 128 columns matches none of the 2 outstanding allocations; the most recent is tcgen05.alloc.cta_group::1 of 64 columns"}},
+		{"alloc after relinquish", func(m *tmemKernel) {
+			m.bb.Add(m.alloc(g1, 32))
+			m.bb.Add(builder.Tcgen05RelinquishAllocPermit(g1))
+			m.bb.Add(m.alloc(g1, 64))
+			m.bb.Add(m.dealloc(g1, 64))
+			m.bb.Add(m.dealloc(g1, 32))
+		}, []string{"tcgen05.alloc after tcgen05.relinquish_alloc_permit"}},
+		{"relinquish after the last alloc", func(m *tmemKernel) {
+			m.bb.Add(m.alloc(g1, 32))
+			m.bb.Add(builder.Tcgen05RelinquishAllocPermit(g1))
+			m.bb.Add(m.dealloc(g1, 32))
+		}, nil},
+	} {
+		m := newTmemKernel()
+		tt.build(m)
+		checkFindings(t, tt.name, TensorMemory(m.end()), tt.want...)
+	}
+}
+
+func TestTensorMemoryAllocInLoop(t *testing.T) {
+	m := newTmemKernel()
+	i := m.k.NewReg("i", ptx.U32)
+	p := m.k.NewReg("p", ptx.Pred)
+	m.bb.Add(builder.Mov(i, builder.Imm(0)).Typed(ptx.U32))
+	m.bb = m.k.NewBlock("loop")
+	m.bb.Add(m.alloc(ptx.ModCtaGroup1, 32))
+	m.bb.Add(builder.Add(i, i, builder.Imm(1)).Typed(ptx.U32))
+	m.bb.Add(builder.Setp(ptx.CmpLt, p, i, builder.Imm(4)).Typed(ptx.U32))
+	m.bb.Add(builder.Bra("loop").Pred(p))
+	m.bb = m.k.NewBlock("free")
+	m.bb.Add(m.dealloc(ptx.ModCtaGroup1, 32))
+	checkFindings(t, "alloc in loop", TensorMemory(m.end()),
+		"tcgen05.alloc runs again before its previous allocation is deallocated")
+}
+
+func TestTensorMemoryWait(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		build func(m *tmemKernel, r, s *builder.Register)
+		want  []string
+	}{
+		{"ld then wait then use", func(m *tmemKernel, r, s *builder.Register) {
+			m.bb.Add(builder.Tcgen05Ld(ptx.ModShape32x32b, ptx.ModNumX1, r, m.addr))
+			m.bb.Add(builder.Tcgen05Wait(ptx.ModWaitLd))
+			m.bb.Add(builder.Add(s, r, r).Typed(ptx.U32))
+		}, nil},
+		{"use before wait::ld", func(m *tmemKernel, r, s *builder.Register) {
+			m.bb.Add(builder.Tcgen05Ld(ptx.ModShape32x32b, ptx.ModNumX1, r, m.addr))
+			m.bb.Add(builder.Add(s, r, r).Typed(ptx.U32))
+			m.bb.Add(builder.Tcgen05Wait(ptx.ModWaitLd))
+		}, []string{"add uses %r loaded by tcgen05.ld"}},
+		{"wait::st does not cover ld", func(m *tmemKernel, r, s *builder.Register) {
+			m.bb.Add(builder.Tcgen05Ld(ptx.ModShape32x32b, ptx.ModNumX1, r, m.addr))
+			m.bb.Add(builder.Tcgen05Wait(ptx.ModWaitSt))
+			m.bb.Add(builder.Mov(s, r).Typed(ptx.U32))
+		}, []string{"mov uses %r loaded by tcgen05.ld"}},
+		{"wait::ld on one path", func(m *tmemKernel, r, s *builder.Register) {
+			m.bb.Add(builder.Tcgen05Ld(ptx.ModShape32x32b, ptx.ModNumX1, r, m.addr))
+			m.bb.Add(builder.Bra("use").Pred(m.flag))
+			m.k.NewBlock("wait").Add(builder.Tcgen05Wait(ptx.ModWaitLd))
+			m.bb = m.k.NewBlock("use")
+			m.bb.Add(builder.Mov(s, r).Typed(ptx.U32))
+		}, []string{"mov uses %r loaded by tcgen05.ld"}},
+		{"st source overwritten before wait::st", func(m *tmemKernel, r, s *builder.Register) {
+			m.bb.Add(builder.Tcgen05St(ptx.ModShape32x32b, ptx.ModNumX1, m.addr, r))
+			m.bb.Add(builder.Mov(r, builder.Imm(0)).Typed(ptx.U32))
+			m.bb.Add(builder.Tcgen05Wait(ptx.ModWaitSt))
+		}, []string{"mov overwrites %r stored by tcgen05.st"}},
+		{"st source read before wait::st", func(m *tmemKernel, r, s *builder.Register) {
+			m.bb.Add(builder.Tcgen05St(ptx.ModShape32x32b, ptx.ModNumX1, m.addr, r))
+			m.bb.Add(builder.Mov(s, r).Typed(ptx.U32))
+			m.bb.Add(builder.Tcgen05Wait(ptx.ModWaitSt))
+			m.bb.Add(builder.Mov(r, builder.Imm(0)).Typed(ptx.U32))
+		}, nil},
+	} {
+		m := newTmemKernel()
+		r := m.k.NewReg("r", ptx.U32)
+		s := m.k.NewReg("s", ptx.U32)
+		m.bb.Add(builder.Ld(m.addr, m.slot).Typed(ptx.B32).InSpace(ptx.Shared))
+		tt.build(m, r, s)
+		checkFindings(t, tt.name, TensorMemory(m.end()), tt.want...)
+	}
+}
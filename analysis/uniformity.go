@@ -0,0 +1,569 @@
+package analysis
+
+import (
+	"fmt"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// Uniformity classifies how a value, or the control flow reaching an
+// instruction, varies across threads. Levels are ordered from most to least
+// uniform.
+type Uniformity int
+
+const (
+	CTAUniform  Uniformity = iota // the same in every thread of the CTA
+	WarpUniform                   // the same in every active lane of a warp
+	Divergent                     // may differ between lanes of a warp
+)
+
+func (u Uniformity) String() string {
+	switch u {
+	case CTAUniform:
+		return "cta-uniform"
+	case WarpUniform:
+		return "warp-uniform"
+	}
+	return "divergent"
+}
+
+// UniformityInfo is the result of AnalyzeUniformity.
+type UniformityInfo struct {
+	Func      *builder.Function
+	Registers map[*builder.Register]Uniformity // every register f reads or writes
+	Branches  map[Site]Uniformity              // every bra, brx.idx and guarded ret/exit/trap
+
+	g       *instGraph
+	control []Uniformity // per node: how uniformly control reaches it
+	cause   []Site       // per node: the branch responsible for control
+}
+
+// AnalyzeUniformity classifies every register and branch of f.
+//
+// Values start from the special registers (%tid, %laneid and the clocks are
+// divergent, %warpid is warp-uniform, %ctaid, %ntid and the cluster
+// registers are CTA-uniform), immediates, symbols and, in kernels, ld.param,
+// and are propagated through data flow to a fixed point. Loads from a
+// uniform address are warp-uniform; shfl, atom and matrix fragments are
+// divergent. A predicated write takes the uniformity of its guard.
+//
+// Control dependence is tracked through divergent regions: the code between
+// a branch and its immediate post-dominator. A register written inside the
+// region of a non-uniform branch that is also read or written outside it
+// takes on that branch's uniformity, as lanes leave the region with
+// different values. Lanes that leave the function (ret, exit) before
+// rejoining are not waited for, so an early-exit bounds check does not make
+// the rest of a kernel divergent.
+//
+// The result is flow insensitive: a register is as divergent as its least
+// uniform definition.
+func AnalyzeUniformity(f *builder.Function) *UniformityInfo {
+	g := newInstGraph(NewCFG(f))
+	u := &UniformityInfo{
+		Func:      f,
+		Registers: make(map[*builder.Register]Uniformity),
+		Branches:  make(map[Site]Uniformity),
+		g:         g,
+		control:   make([]Uniformity, len(g.sites)),
+		cause:     make([]Site, len(g.sites)),
+	}
+
+	occurs := make(map[*builder.Register][]int)
+	var branches []int
+	for n, site := range g.sites {
+		inst := site.Inst()
+		if inst == nil {
+			continue
+		}
+		forEachReg(inst, func(r *builder.Register) {
+			occurs[r] = append(occurs[r], n)
+			u.Registers[r] = CTAUniform
+		})
+		if isBranch(inst) {
+			branches = append(branches, n)
+		}
+	}
+
+	ipdom := g.postDominators()
+	regions := make(map[int][]bool)
+	for _, n := range branches {
+		if len(g.succs[n]) > 1 {
+			regions[n] = g.region(n, ipdom[n])
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		raise := func(r *builder.Register, to Uniformity) {
+			if to > u.Registers[r] {
+				u.Registers[r] = to
+				changed = true
+			}
+		}
+		for _, site := range g.sites {
+			if inst := site.Inst(); inst != nil && !pseudo(inst) {
+				level := u.result(inst)
+				forEachOperandReg(inst.Dst, func(r *builder.Register) { raise(r, level) })
+				forEachOperandReg(inst.Dst2, func(r *builder.Register) { raise(r, level) })
+			}
+		}
+		for _, n := range branches {
+			level := u.branch(g.sites[n].Inst())
+			u.Branches[g.sites[n]] = level
+			region := regions[n]
+			if level == CTAUniform || region == nil {
+				continue
+			}
+			for m, in := range region {
+				if !in || g.sites[m].Inst() == nil || pseudo(g.sites[m].Inst()) {
+					continue
+				}
+				inst := g.sites[m].Inst()
+				escapes := func(r *builder.Register) {
+					for _, o := range occurs[r] {
+						if !region[o] {
+							raise(r, level)
+							return
+						}
+					}
+				}
+				forEachOperandReg(inst.Dst, escapes)
+				forEachOperandReg(inst.Dst2, escapes)
+			}
+		}
+	}
+
+	for _, n := range branches {
+		level := u.Branches[g.sites[n]]
+		for m, in := range regions[n] {
+			if in && level > u.control[m] {
+				u.control[m], u.cause[m] = level, g.sites[n]
+			}
+		}
+	}
+	return u
+}
+
+// Of returns the uniformity of r. Registers f never mentions are CTA-uniform.
+func (u *UniformityInfo) Of(r *builder.Register) Uniformity {
+	return u.Registers[r]
+}
+
+// Control returns how uniformly control reaches s and, when it is not
+// CTA-uniform, the innermost branch responsible.
+func (u *UniformityInfo) Control(s Site) (Uniformity, Site) {
+	n := u.g.node(s)
+	return u.control[n], u.cause[n]
+}
+
+// operand returns the uniformity of a source operand.
+func (u *UniformityInfo) operand(op builder.Operand) Uniformity {
+	switch o := op.(type) {
+	case *builder.Register:
+		return u.Registers[o]
+	case *builder.Address:
+		return u.operand(o.Base)
+	case *builder.VectorOp:
+		level := CTAUniform
+		for _, el := range o.Elements {
+			level = max(level, u.operand(el))
+		}
+		return level
+	case *builder.SpecialRegOp:
+		return specialUniformity(o.Reg)
+	}
+	return CTAUniform
+}
+
+// result returns the uniformity of the values inst writes.
+func (u *UniformityInfo) result(inst *builder.Instruction) Uniformity {
+	level := CTAUniform
+	for _, src := range inst.Src {
+		level = max(level, u.operand(src))
+	}
+
+	switch inst.Op {
+	case ptx.OpLd, ptx.OpLdNC, ptx.OpLdu:
+		switch inst.Space {
+		case ptx.Param, ptx.ParamEntry, ptx.ParamFunc:
+			// Kernel parameters are shared by the grid; device function
+			// parameters are passed per thread.
+			if !u.Func.IsKernel {
+				level = Divergent
+			}
+		case ptx.Const:
+		case ptx.Local:
+			level = Divergent
+		default:
+			// Other warps may store between their loads.
+			level = max(level, WarpUniform)
+		}
+	case ptx.OpVote, ptx.OpVoteSync, ptx.OpActivemask, ptx.OpReduxSync:
+		level = WarpUniform
+	case ptx.OpShfl, ptx.OpAtom, ptx.OpMatchSync, ptx.OpElectSync, ptx.OpCall,
+		ptx.OpMultimemLdReduce, ptx.OpSuld, ptx.OpSured,
+		ptx.OpWmmaLoad, ptx.OpWmmaMma, ptx.OpMma, ptx.OpWgmma, ptx.OpWgmmaMmaAsync,
+		ptx.OpLdMatrix, ptx.OpMovMatrix, ptx.OpTcgen05Ld:
+		level = Divergent
+	}
+
+	if inst.Guard != nil {
+		level = max(level, u.Registers[inst.Guard.Reg])
+	}
+	return level
+}
+
+// branch returns the uniformity of the condition that selects between the
+// successors of a branch instruction.
+func (u *UniformityInfo) branch(inst *builder.Instruction) Uniformity {
+	level := CTAUniform
+	if inst.Guard != nil {
+		level = u.Registers[inst.Guard.Reg]
+	}
+	if inst.Op == ptx.OpBrxIdx && len(inst.Src) > 0 {
+		level = max(level, u.operand(inst.Src[0]))
+	}
+	return level
+}
+
+// specialUniformity classifies a special register.
+func specialUniformity(r ptx.SpecialReg) Uniformity {
+	switch r {
+	case ptx.RegTidX, ptx.RegTidY, ptx.RegTidZ, ptx.RegLaneId,
+		ptx.RegLanemaskEq, ptx.RegLanemaskLe, ptx.RegLanemaskLt, ptx.RegLanemaskGe, ptx.RegLanemaskGt,
+		ptx.RegClock, ptx.RegClockHi, ptx.RegClock64,
+		ptx.RegGlobalTimer, ptx.RegGlobalTimerLo, ptx.RegGlobalTimerHi,
+		ptx.RegPM0, ptx.RegPM1, ptx.RegPM2, ptx.RegPM3, ptx.RegPM4, ptx.RegPM5, ptx.RegPM6, ptx.RegPM7:
+		return Divergent
+	case ptx.RegWarpId:
+		return WarpUniform
+	}
+	return CTAUniform
+}
+
+// isBranch reports whether inst may send lanes to different places.
+func isBranch(inst *builder.Instruction) bool {
+	switch inst.Op {
+	case ptx.OpBra, ptx.OpBrxIdx:
+		return true
+	case ptx.OpRet, ptx.OpExit, ptx.OpTrap:
+		return inst.Guard != nil
+	}
+	return false
+}
+
+// forEachReg calls fn for every register inst reads or writes.
+func forEachReg(inst *builder.Instruction, fn func(*builder.Register)) {
+	if pseudo(inst) {
+		return
+	}
+	forEachOperandReg(inst.Dst, fn)
+	forEachOperandReg(inst.Dst2, fn)
+	for _, src := range inst.Src {
+		forEachOperandReg(src, fn)
+	}
+	if inst.Guard != nil {
+		fn(inst.Guard.Reg)
+	}
+}
+
+// forEachOperandReg calls fn for every register named by op.
+func forEachOperandReg(op builder.Operand, fn func(*builder.Register)) {
+	switch o := op.(type) {
+	case *builder.Register:
+		fn(o)
+	case *builder.Address:
+		forEachOperandReg(o.Base, fn)
+	case *builder.VectorOp:
+		for _, el := range o.Elements {
+			forEachOperandReg(el, fn)
+		}
+	}
+}
+
+// DivergentBarriers reports every bar.sync, barrier.cta and barrier.cluster
+// that not all threads of the CTA may reach together: one under a guard or
+// inside the region of a branch that is not CTA-uniform. A bar.sync with a
+// thread count may be skipped by whole warps, so it is only reported when
+// control diverges within a warp.
+func DivergentBarriers(f *builder.Function) []Finding {
+	u := AnalyzeUniformity(f)
+	var findings []Finding
+	for _, site := range u.g.sites {
+		inst := site.Inst()
+		if inst == nil || (inst.Op != ptx.OpBar && inst.Op != ptx.OpBarrierCluster) {
+			continue
+		}
+		allowed := CTAUniform
+		if inst.Op == ptx.OpBar && len(inst.Src) > 1 {
+			allowed = WarpUniform
+		}
+		if inst.Guard != nil {
+			if level := u.Of(inst.Guard.Reg); level > allowed {
+				findings = append(findings, Finding{Site: site,
+					Message: fmt.Sprintf("%s guarded by %s predicate %s", inst.Op, level, inst.Guard.Reg.Name)})
+				continue
+			}
+		}
+		if level, cause := u.Control(site); level > allowed {
+			findings = append(findings, Finding{Site: site,
+				Message: fmt.Sprintf("%s in %s code (branch at %s)", inst.Op, level, cause)})
+		}
+	}
+	return findings
+}
+
+// MarkUniformBranches adds .uni to every bra in f whose condition is the
+// same for all active lanes of a warp, including unconditional branches, and
+// returns how many it changed.
+func MarkUniformBranches(f *builder.Function) int {
+	u := AnalyzeUniformity(f)
+	changed := 0
+	for site, level := range u.Branches {
+		inst := site.Inst()
+		if inst.Op != ptx.OpBra || level > WarpUniform || hasModifier(inst, ptx.ModUni) {
+			continue
+		}
+		inst.Modifiers = append(inst.Modifiers, ptx.ModUni)
+		changed++
+	}
+	return changed
+}
+
+func hasModifier(inst *builder.Instruction, m ptx.Modifier) bool {
+	for _, x := range inst.Modifiers {
+		if x == m {
+			return true
+		}
+	}
+	return false
+}
+
+// instGraph is the control-flow graph at instruction granularity: one node
+// per instruction, one for the fall-through point at the end of each block,
+// and a final exit node.
+type instGraph struct {
+	sites []Site  // node -> site; Index == len(Instructions) for block ends
+	base  []int   // block -> node of its first instruction
+	succs [][]int // node -> successor nodes
+	exit  int
+}
+
+func newInstGraph(g *CFG) *instGraph {
+	f := g.Func
+	ig := &instGraph{base: make([]int, len(f.Blocks))}
+	for b, bb := range f.Blocks {
+		ig.base[b] = len(ig.sites)
+		for i := 0; i <= len(bb.Instructions); i++ {
+			ig.sites = append(ig.sites, Site{Func: f, Block: b, Index: i})
+		}
+	}
+	ig.exit = len(ig.sites)
+	ig.succs = make([][]int, len(ig.sites))
+
+	start := func(b int) int {
+		if b == Exit {
+			return ig.exit
+		}
+		return ig.base[b]
+	}
+	for n, site := range ig.sites {
+		inst := site.Inst()
+		if inst == nil {
+			if site.Block+1 < len(f.Blocks) {
+				ig.succs[n] = []int{start(site.Block + 1)}
+			} else {
+				ig.succs[n] = []int{ig.exit}
+			}
+			continue
+		}
+		if to, ok := g.target(inst); ok {
+			ig.succs[n] = append(ig.succs[n], start(to))
+		}
+		if !terminates(inst) {
+			ig.succs[n] = append(ig.succs[n], n+1)
+		}
+	}
+
+	// Nodes that can only go on to leave the function are folded into the
+	// exit, so a branch to a block holding just ret or exit does not wait.
+	leaves := make([]bool, len(ig.sites))
+	for changed := true; changed; {
+		changed = false
+		for n, site := range ig.sites {
+			inst := site.Inst()
+			out := false
+			switch {
+			case inst == nil || (inst.Op == ptx.OpBra && inst.Guard == nil):
+				s := ig.succs[n]
+				out = len(s) == 1 && (s[0] == ig.exit || leaves[s[0]])
+			case inst.Guard == nil:
+				out = inst.Op == ptx.OpRet || inst.Op == ptx.OpExit || inst.Op == ptx.OpTrap
+			}
+			if out && !leaves[n] {
+				leaves[n] = true
+				changed = true
+			}
+		}
+	}
+	for _, ss := range ig.succs {
+		for i, s := range ss {
+			if s != ig.exit && leaves[s] {
+				ss[i] = ig.exit
+			}
+		}
+	}
+	return ig
+}
+
+// node returns the node of a site.
+func (ig *instGraph) node(s Site) int {
+	return ig.base[s.Block] + s.Index
+}
+
+// postDominators returns the immediate post-dominator of every node. Nodes
+// that cannot reach the exit are given the exit.
+func (ig *instGraph) postDominators() []int {
+	n := len(ig.sites) + 1
+	preds := make([][]int, n)
+	for v, ss := range ig.succs {
+		for _, s := range ss {
+			preds[s] = append(preds[s], v)
+		}
+	}
+
+	// Post-order of the reverse graph from the exit.
+	rank := make([]int, n)
+	for i := range rank {
+		rank[i] = -1
+	}
+	var order []int
+	var visit func(v int)
+	visit = func(v int) {
+		rank[v] = 0
+		for _, p := range preds[v] {
+			if rank[p] < 0 {
+				visit(p)
+			}
+		}
+		rank[v] = len(order)
+		order = append(order, v)
+	}
+	visit(ig.exit)
+
+	ipdom := make([]int, n)
+	for i := range ipdom {
+		ipdom[i] = -1
+	}
+	ipdom[ig.exit] = ig.exit
+	intersect := func(a, b int) int {
+		for a != b {
+			for rank[a] < rank[b] {
+				a = ipdom[a]
+			}
+			for rank[b] < rank[a] {
+				b = ipdom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(order) - 1; i >= 0; i-- {
+			v := order[i]
+			if v == ig.exit {
+				continue
+			}
+			next := -1
+			for _, s := range ig.succs[v] {
+				if ipdom[s] < 0 {
+					continue
+				}
+				if next < 0 {
+					next = s
+				} else {
+					next = intersect(s, next)
+				}
+			}
+			if next != ipdom[v] {
+				ipdom[v] = next
+				changed = true
+			}
+		}
+	}
+	for i := range ipdom {
+		if ipdom[i] < 0 {
+			ipdom[i] = ig.exit
+		}
+	}
+	return ipdom[:len(ig.sites)]
+}
+
+// reach returns the nodes reachable from n without passing through stop or
+// the exit.
+func (ig *instGraph) reach(n, stop int) []bool {
+	seen := make([]bool, len(ig.sites))
+	work := []int{n}
+	for len(work) > 0 {
+		v := work[len(work)-1]
+		work = work[:len(work)-1]
+		if v == stop || v == ig.exit || seen[v] {
+			continue
+		}
+		seen[v] = true
+		work = append(work, ig.succs[v]...)
+	}
+	return seen
+}
+
+// region returns the nodes that may execute while lanes that took different
+// successors of branch node n have not yet reconverged at ipdom. When the
+// branch only reconverges at the exit, a successor whose lanes never meet
+// another successor's again has left for good, so the other side's code
+// does not wait for it.
+func (ig *instGraph) region(n, ipdom int) []bool {
+	succs := ig.succs[n]
+	reach := make([][]bool, len(succs))
+	for i, s := range succs {
+		reach[i] = ig.reach(s, ipdom)
+	}
+	live := make([]bool, len(succs))
+	for i := range succs {
+		if ipdom != ig.exit {
+			live[i] = true
+			continue
+		}
+		for j := range succs {
+			if j != i && overlaps(reach[i], reach[j]) {
+				live[i] = true
+			}
+		}
+	}
+
+	region := make([]bool, len(ig.sites))
+	for i := range succs {
+		waited := false
+		for j := range succs {
+			if j != i && live[j] {
+				waited = true
+			}
+		}
+		if !waited {
+			continue
+		}
+		for v, in := range reach[i] {
+			region[v] = region[v] || in
+		}
+	}
+	return region
+}
+
+func overlaps(a, b []bool) bool {
+	for i := range a {
+		if a[i] && b[i] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/codegen"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// uniKernel is a kernel whose entry block sets a divergent predicate from
+// %tid.x, a warp-uniform one from %warpid and a CTA-uniform one from the
+// parameter n.
+type uniKernel struct {
+	mod        *builder.Module
+	k          *builder.Function
+	bb         *builder.BasicBlock
+	tid, n     *builder.Register
+	pt, pw, pn *builder.Register
+}
+
+func newUniKernel() *uniKernel {
+	mod := builder.NewModule(ptx.ISA80, ptx.SM80)
+	k := mod.NewKernel("k")
+	k.AddParam(builder.NewParam("n", ptx.U32))
+	m := &uniKernel{
+		mod: mod,
+		k:   k,
+		tid: k.NewReg("tid", ptx.U32),
+		n:   k.NewReg("n", ptx.U32),
+		pt:  k.NewReg("pt", ptx.Pred),
+		pw:  k.NewReg("pw", ptx.Pred),
+		pn:  k.NewReg("pn", ptx.Pred),
+	}
+	warp := k.NewReg("warp", ptx.U32)
+	m.bb = k.NewBlock("entry")
+	m.bb.Add(builder.Mov(m.tid, builder.SReg(ptx.RegTidX)).Typed(ptx.U32))
+	m.bb.Add(builder.Mov(warp, builder.SReg(ptx.RegWarpId)).Typed(ptx.U32))
+	m.bb.Add(builder.Ld(m.n, builder.Addr(k.Param("n"), 0)).Typed(ptx.U32).InSpace(ptx.Param))
+	m.bb.Add(builder.Setp(ptx.CmpLt, m.pt, m.tid, builder.Imm(16)).Typed(ptx.U32))
+	m.bb.Add(builder.Setp(ptx.CmpEq, m.pw, warp, builder.Imm(0)).Typed(ptx.U32))
+	m.bb.Add(builder.Setp(ptx.CmpNe, m.pn, m.n, builder.Imm(0)).Typed(ptx.U32))
+	return m
+}
+
+// ifThen adds a branch on p around a block holding body, then a join block.
+func (m *uniKernel) ifThen(label string, p *builder.Register, body ...*builder.Instruction) Site {
+	m.bb.Add(builder.Bra(label + "_end").PredNot(p))
+	branch := Site{Func: m.k, Block: len(m.k.Blocks) - 1, Index: len(m.bb.Instructions) - 1}
+	then := m.k.NewBlock(label)
+	for _, inst := range body {
+		then.Add(inst)
+	}
+	m.bb = m.k.NewBlock(label + "_end")
+	return branch
+}
+
+// end finishes the kernel with code after the last join, so that branches
+// to it are not taken as early returns.
+func (m *uniKernel) end() *builder.Function {
+	m.bb.Add(builder.Mov(m.k.NewReg("out", ptx.U32), m.n).Typed(ptx.U32))
+	m.bb.Add(builder.Ret())
+	return m.k
+}
+
+func TestUniformityRegisters(t *testing.T) {
+	m := newUniKernel()
+	x := m.k.NewReg("x", ptx.U32)
+	y := m.k.NewReg("y", ptx.U32)
+	z := m.k.NewReg("z", ptx.U32)
+	m.bb.Add(builder.Mov(x, builder.Imm(0)).Typed(ptx.U32))
+	m.bb.Add(builder.Mov(y, builder.Imm(0)).Typed(ptx.U32))
+	m.bb.Add(builder.Add(z, m.tid, m.n).Typed(ptx.U32))
+	// x is written under a divergent branch and read after it; y under a
+	// uniform one.
+	m.ifThen("div", m.pt, builder.Add(x, m.n, builder.Imm(1)).Typed(ptx.U32))
+	m.ifThen("uni", m.pn, builder.Add(y, m.n, builder.Imm(1)).Typed(ptx.U32))
+	m.bb.Add(builder.Add(x, x, y).Typed(ptx.U32))
+	u := AnalyzeUniformity(m.end())
+
+	for r, want := range map[*builder.Register]Uniformity{
+		m.tid: Divergent,
+		m.n:   CTAUniform,
+		m.pt:  Divergent,
+		m.pw:  WarpUniform,
+		m.pn:  CTAUniform,
+		z:     Divergent,
+		x:     Divergent,
+		y:     CTAUniform,
+	} {
+		if got := u.Of(r); got != want {
+			t.Errorf("%s is %v, want %v", r.Name, got, want)
+		}
+	}
+}
+
+func TestUniformityBranches(t *testing.T) {
+	m := newUniKernel()
+	div := m.ifThen("div", m.pt, builder.Mov(m.k.NewReg("a", ptx.U32), builder.Imm(1)).Typed(ptx.U32))
+	warp := m.ifThen("warp", m.pw, builder.Mov(m.k.NewReg("b", ptx.U32), builder.Imm(1)).Typed(ptx.U32))
+	cta := m.ifThen("cta", m.pn, builder.Mov(m.k.NewReg("c", ptx.U32), builder.Imm(1)).Typed(ptx.U32))
+	f := m.end()
+	u := AnalyzeUniformity(f)
+
+	for _, tt := range []struct {
+		site Site
+		want Uniformity
+	}{{div, Divergent}, {warp, WarpUniform}, {cta, CTAUniform}} {
+		if got := u.Branches[tt.site]; got != tt.want {
+			t.Errorf("branch at %s is %v, want %v", tt.site, got, tt.want)
+		}
+	}
+	block := func(label string) int {
+		for b, bb := range f.Blocks {
+			if bb.Label == label {
+				return b
+			}
+		}
+		t.Fatalf("no block %s", label)
+		return -1
+	}
+	for _, tt := range []struct {
+		label string
+		want  Uniformity
+		cause Site
+	}{
+		{"div", Divergent, div},
+		{"div_end", CTAUniform, Site{}},
+		{"warp", WarpUniform, warp},
+		{"cta", CTAUniform, Site{}},
+	} {
+		level, cause := u.Control(Site{Func: f, Block: block(tt.label), Index: 0})
+		if level != tt.want || (tt.want != CTAUniform && cause != tt.cause) {
+			t.Errorf("control in %s is %v from %s, want %v from %s", tt.label, level, cause, tt.want, tt.cause)
+		}
+	}
+}
+
+func TestDivergentBarriers(t *testing.T) {
+	bar := func() *builder.Instruction { return builder.BarSync(builder.Imm(0)) }
+	for _, tt := range []struct {
+		name  string
+		build func(m *uniKernel)
+		want  []string
+	}{
+		{"top level", func(m *uniKernel) {
+			m.bb.Add(bar())
+		}, nil},
+		{"under a divergent branch", func(m *uniKernel) {
+			m.ifThen("div", m.pt, bar())
+		}, []string{"bar.sync in divergent code (branch at k/entry#6)"}},
+		{"under a warp-uniform branch", func(m *uniKernel) {
+			m.ifThen("warp", m.pw, bar())
+		}, []string{"bar.sync in warp-uniform code"}},
+		{"bar.sync with a count under a warp-uniform branch", func(m *uniKernel) {
+			m.ifThen("warp", m.pw, builder.BarSyncCount(builder.Imm(1), builder.Imm(64)))
+		}, nil},
+		{"under a uniform branch", func(m *uniKernel) {
+			m.ifThen("cta", m.pn, bar())
+		}, nil},
+		{"after a divergent branch", func(m *uniKernel) {
+			m.ifThen("div", m.pt, builder.Mov(m.k.NewReg("a", ptx.U32), builder.Imm(1)).Typed(ptx.U32))
+			m.bb.Add(bar())
+		}, nil},
+		{"guarded by a divergent predicate", func(m *uniKernel) {
+			m.bb.Add(bar().Pred(m.pt))
+		}, []string{"bar.sync guarded by divergent predicate %pt"}},
+		{"after an early exit", func(m *uniKernel) {
+			m.bb.Add(builder.Ret().PredNot(m.pt))
+			m.bb.Add(bar())
+		}, nil},
+		{"before a branch to ret", func(m *uniKernel) {
+			m.bb.Add(builder.Bra("out").PredNot(m.pt))
+			m.k.NewBlock("body").Add(bar())
+			m.k.NewBlock("out").Add(builder.Ret())
+			m.bb = m.k.NewBlock("unreached")
+		}, nil},
+	} {
+		m := newUniKernel()
+		tt.build(m)
+		checkFindings(t, tt.name, DivergentBarriers(m.end()), tt.want...)
+	}
+}
+
+func TestMarkUniformBranches(t *testing.T) {
+	m := newUniKernel()
+	div := m.ifThen("div", m.pt, builder.Bra("div_end"))
+	warp := m.ifThen("warp", m.pw)
+	cta := m.ifThen("cta", m.pn)
+	f := m.end()
+
+	if n := MarkUniformBranches(f); n != 3 {
+		t.Errorf("MarkUniformBranches changed %d branches, want 3", n)
+	}
+	for _, tt := range []struct {
+		site Site
+		uni  bool
+	}{
+		{div, false},
+		{Site{Func: f, Block: div.Block + 1, Index: 0}, true}, // unconditional
+		{warp, true},
+		{cta, true},
+	} {
+		if got := hasModifier(tt.site.Inst(), ptx.ModUni); got != tt.uni {
+			t.Errorf("bra at %s: .uni %v, want %v", tt.site, got, tt.uni)
+		}
+	}
+	if n := MarkUniformBranches(f); n != 0 {
+		t.Errorf("second MarkUniformBranches changed %d branches, want 0", n)
+	}
+	src := strings.Join(strings.Fields(codegen.Emit(m.mod)), " ")
+	if !strings.Contains(src, "@!%pw bra.uni warp_end;") || !strings.Contains(src, "@!%pt bra div_end;") {
+		t.Errorf("branches not emitted as expected:\n%s", src)
+	}
+}
@@ -0,0 +1,787 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// sym names a warp-uniform quantity whose concrete value is not known
+// statically: a kernel parameter, a %ctaid component, the address of a
+// module variable, or a register carried around a loop.
+type sym string
+
+// value is the abstract value of an integer register across one warp.
+//
+// Lane l holds lanes[l] + Σ terms[s]·s: the lane-varying part is known
+// exactly, while the warp-uniform part is affine over unknown symbols.
+// A value with known == false could not be evaluated. Values are treated
+// as immutable once built.
+type value struct {
+	known bool
+	lanes [WarpSize]int64
+	terms map[sym]int64
+}
+
+var unknown = value{}
+
+// constant returns a value that is c in every lane.
+func constant(c int64) value {
+	v := value{known: true}
+	for l := range v.lanes {
+		v.lanes[l] = c
+	}
+	return v
+}
+
+// symbol returns a value that is the uniform symbol s in every lane.
+func symbol(s sym) value {
+	return value{known: true, terms: map[sym]int64{s: 1}}
+}
+
+// concrete reports whether v has no symbolic part.
+func (v value) concrete() bool {
+	return v.known && len(v.terms) == 0
+}
+
+// uniform reports whether v is the same in every active lane.
+func (v value) uniform(active *[WarpSize]bool) bool {
+	if !v.known {
+		return false
+	}
+	first := true
+	var c int64
+	for l := range v.lanes {
+		if !active[l] {
+			continue
+		}
+		if first {
+			c, first = v.lanes[l], false
+		} else if v.lanes[l] != c {
+			return false
+		}
+	}
+	return true
+}
+
+// scalar returns the single value of a concrete, lane-invariant v.
+func (v value) scalar() (int64, bool) {
+	if !v.concrete() {
+		return 0, false
+	}
+	for _, x := range v.lanes[1:] {
+		if x != v.lanes[0] {
+			return 0, false
+		}
+	}
+	return v.lanes[0], true
+}
+
+func (v value) equal(o value) bool {
+	if v.known != o.known {
+		return false
+	}
+	if !v.known {
+		return true
+	}
+	if v.lanes != o.lanes || len(v.terms) != len(o.terms) {
+		return false
+	}
+	for s, c := range v.terms {
+		if o.terms[s] != c {
+			return false
+		}
+	}
+	return true
+}
+
+// combine returns a·x + b·y.
+func combine(a int64, x value, b int64, y value) value {
+	if !x.known || !y.known {
+		return unknown
+	}
+	r := value{known: true}
+	for l := range r.lanes {
+		r.lanes[l] = a*x.lanes[l] + b*y.lanes[l]
+	}
+	for s, c := range x.terms {
+		r.addTerm(s, a*c)
+	}
+	for s, c := range y.terms {
+		r.addTerm(s, b*c)
+	}
+	return r
+}
+
+func (v *value) addTerm(s sym, c int64) {
+	if c == 0 {
+		return
+	}
+	if v.terms == nil {
+		v.terms = make(map[sym]int64)
+	}
+	v.terms[s] += c
+	if v.terms[s] == 0 {
+		delete(v.terms, s)
+	}
+}
+
+func add(x, y value) value { return combine(1, x, 1, y) }
+func sub(x, y value) value { return combine(1, x, -1, y) }
+
+// mul multiplies two values; the product stays affine only when one side
+// is a lane-invariant constant.
+func mul(x, y value) value {
+	if c, ok := x.scalar(); ok {
+		return combine(c, y, 0, y)
+	}
+	if c, ok := y.scalar(); ok {
+		return combine(c, x, 0, x)
+	}
+	return lanewise(x, y, func(a, b int64) (int64, bool) { return a * b, true })
+}
+
+// lanewise applies op in every lane; both operands must be concrete.
+func lanewise(x, y value, op func(a, b int64) (int64, bool)) value {
+	if !x.concrete() || !y.concrete() {
+		return unknown
+	}
+	r := value{known: true}
+	for l := range r.lanes {
+		v, ok := op(x.lanes[l], y.lanes[l])
+		if !ok {
+			return unknown
+		}
+		r.lanes[l] = v
+	}
+	return r
+}
+
+// termAlign returns the largest power-of-two alignment guaranteed for the
+// uniform symbolic part of v, or 0 when v has no symbolic part.
+func (v value) termAlign(align map[sym]int64) int64 {
+	var g int64
+	for s, c := range v.terms {
+		a := align[s]
+		if a == 0 {
+			a = 1
+		}
+		g = gcd(g, abs(c)*a)
+	}
+	return pow2(g)
+}
+
+// String formats v as an affine expression, e.g. "4*lane + 128*%ctaid.x + a".
+func (v value) String() string {
+	if !v.known {
+		return "?"
+	}
+	var parts []string
+	base, stride, linear := v.lanes[0], int64(0), true
+	if WarpSize > 1 {
+		stride = v.lanes[1] - v.lanes[0]
+	}
+	for l := range v.lanes {
+		if v.lanes[l] != base+int64(l)*stride {
+			linear = false
+			break
+		}
+	}
+	switch {
+	case !linear:
+		parts = append(parts, "f(lane)")
+	case stride == 1:
+		parts = append(parts, "lane")
+	case stride != 0:
+		parts = append(parts, fmt.Sprintf("%d*lane", stride))
+	}
+	names := make([]string, 0, len(v.terms))
+	for s := range v.terms {
+		names = append(names, string(s))
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if c := v.terms[sym(n)]; c == 1 {
+			parts = append(parts, n)
+		} else {
+			parts = append(parts, fmt.Sprintf("%d*%s", c, n))
+		}
+	}
+	if linear && base != 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%d", base))
+	}
+	return strings.Join(parts, " + ")
+}
+
+// warpEnv maps registers to their abstract values for one warp.
+type warpEnv map[*builder.Register]value
+
+// warpEval evaluates integer register values for one warp of a CTA.
+// It implements problem[warpEnv].
+type warpEval struct {
+	launch Launch
+	warp   int
+	tid    [3][WarpSize]int64
+	active [WarpSize]bool
+	params map[string]*builder.Param
+	align  map[sym]int64 // known power-of-two alignment of each symbol
+}
+
+// defaultPtrAlign is the alignment assumed for pointer parameters without
+// an explicit .align: the allocation granularity of cudaMalloc.
+const defaultPtrAlign = 256
+
+func newWarpEval(f *builder.Function, launch Launch, warp int) *warpEval {
+	e := &warpEval{
+		launch: launch,
+		warp:   warp,
+		params: make(map[string]*builder.Param),
+		align:  make(map[sym]int64),
+	}
+	bx, by, _ := launch.dims()
+	n := launch.Threads()
+	for l := 0; l < WarpSize; l++ {
+		t := warp*WarpSize + l
+		e.tid[0][l] = int64(t % bx)
+		e.tid[1][l] = int64((t / bx) % by)
+		e.tid[2][l] = int64(t / (bx * by))
+		e.active[l] = t < n
+	}
+	for _, p := range f.Params {
+		e.params[p.Name] = p
+	}
+	return e
+}
+
+// run solves the function and calls visit with the register state before
+// every reachable instruction.
+func (e *warpEval) run(g *CFG, visit func(Site, warpEnv)) {
+	in, reached := solve[warpEnv](g, e)
+	replay[warpEnv](g, e, in, reached, visit, nil)
+}
+
+// addresses evaluates the address operand (Src[0]) of every reachable
+// instruction accepted by match.
+func (e *warpEval) addresses(g *CFG, match func(*builder.Instruction) bool) map[Site]value {
+	addrs := make(map[Site]value)
+	e.run(g, func(site Site, env warpEnv) {
+		if inst := site.Inst(); len(inst.Src) > 0 && match(inst) {
+			addrs[site] = e.operand(env, inst.Src[0])
+		}
+	})
+	return addrs
+}
+
+func (e *warpEval) entry() warpEnv { return warpEnv{} }
+
+func (e *warpEval) clone(s warpEnv) warpEnv {
+	c := make(warpEnv, len(s))
+	for r, v := range s {
+		c[r] = v
+	}
+	return c
+}
+
+// join merges register values at the head of block. A register whose
+// values differ only by a warp-uniform amount (a loop counter, a pointer
+// advanced each iteration) keeps its lane pattern and gets a fresh symbol
+// for the uniform part.
+func (e *warpEval) join(a, b warpEnv, block int) (warpEnv, bool) {
+	changed := false
+	for r, vb := range b {
+		va, ok := a[r]
+		if !ok {
+			a[r] = vb
+			changed = true
+			continue
+		}
+		if va.equal(vb) || !va.known {
+			continue
+		}
+		j := e.joinValue(va, vb, sym(fmt.Sprintf("%s@%d", r.Name, block)))
+		if !j.equal(va) {
+			a[r] = j
+			changed = true
+		}
+	}
+	return a, changed
+}
+
+func (e *warpEval) joinValue(a, b value, phi sym) value {
+	if !a.known || !b.known {
+		return unknown
+	}
+	r := value{known: true}
+	for l := range r.lanes {
+		da, db := a.lanes[l]-a.lanes[0], b.lanes[l]-b.lanes[0]
+		if da != db {
+			return unknown
+		}
+		r.lanes[l] = da
+	}
+	al := gcd(gcd(abs(a.lanes[0]), a.termAlign(e.align)), gcd(abs(b.lanes[0]), b.termAlign(e.align)))
+	e.align[phi] = pow2(gcd(e.align[phi], al))
+	r.terms = map[sym]int64{phi: 1}
+	return r
+}
+
+func (e *warpEval) transfer(s warpEnv, site Site) warpEnv {
+	inst := site.Inst()
+	assign := func(r *builder.Register, v value) {
+		if inst.Guard != nil {
+			if old, ok := s[r]; ok && !old.equal(v) {
+				v = unknown
+			}
+		}
+		s[r] = v
+	}
+	first := unknown
+	if r, ok := inst.Dst.(*builder.Register); ok {
+		first = e.eval(s, site, inst)
+		assign(r, first)
+	} else if vec, ok := inst.Dst.(*builder.VectorOp); ok {
+		for _, el := range vec.Elements {
+			if r, ok := el.(*builder.Register); ok {
+				s[r] = unknown
+			}
+		}
+	}
+	if r, ok := inst.Dst2.(*builder.Register); ok {
+		assign(r, e.second(inst, first))
+	}
+	return s
+}
+
+// eval computes the value an instruction writes to its destination.
+func (e *warpEval) eval(s warpEnv, site Site, inst *builder.Instruction) value {
+	src := func(i int) value {
+		if i >= len(inst.Src) {
+			return unknown
+		}
+		return e.operand(s, inst.Src[i])
+	}
+	if r, ok := inst.Dst.(*builder.Register); ok {
+		if r.Typ == ptx.Pred {
+			return e.predicate(s, inst)
+		}
+		if r.Typ.IsFloat() {
+			return unknown
+		}
+	}
+	for _, m := range inst.Modifiers {
+		if m == ptx.ModHi {
+			return unknown
+		}
+	}
+
+	switch inst.Op {
+	case ptx.OpMov, ptx.OpCvt, ptx.OpCvta:
+		return src(0)
+	case ptx.OpAdd:
+		return add(src(0), src(1))
+	case ptx.OpSub:
+		return sub(src(0), src(1))
+	case ptx.OpNeg:
+		return combine(-1, src(0), 0, src(0))
+	case ptx.OpMul, ptx.OpMul24:
+		return mul(src(0), src(1))
+	case ptx.OpMad, ptx.OpMad24:
+		return add(mul(src(0), src(1)), src(2))
+	case ptx.OpShl:
+		if c, ok := src(1).scalar(); ok && c >= 0 && c < 63 {
+			return combine(1<<c, src(0), 0, src(0))
+		}
+		return unknown
+	case ptx.OpShr:
+		return lanewise(src(0), src(1), func(a, b int64) (int64, bool) { return a >> uint64(b&63), true })
+	case ptx.OpDiv:
+		return lanewise(src(0), src(1), func(a, b int64) (int64, bool) { return safeDiv(a, b) })
+	case ptx.OpRem:
+		return lanewise(src(0), src(1), func(a, b int64) (int64, bool) {
+			if b == 0 {
+				return 0, false
+			}
+			return a % b, true
+		})
+	case ptx.OpAnd:
+		return lanewise(src(0), src(1), func(a, b int64) (int64, bool) { return a & b, true })
+	case ptx.OpOr:
+		return lanewise(src(0), src(1), func(a, b int64) (int64, bool) { return a | b, true })
+	case ptx.OpXor:
+		return lanewise(src(0), src(1), func(a, b int64) (int64, bool) { return a ^ b, true })
+	case ptx.OpMin:
+		return lanewise(src(0), src(1), func(a, b int64) (int64, bool) { return min(a, b), true })
+	case ptx.OpMax:
+		return lanewise(src(0), src(1), func(a, b int64) (int64, bool) { return max(a, b), true })
+	case ptx.OpSelp:
+		if a, b := src(0), src(1); a.equal(b) {
+			return a
+		}
+		return unknown
+	case ptx.OpLd, ptx.OpLdNC, ptx.OpLdu:
+		return e.load(s, site, inst)
+	}
+	return unknown
+}
+
+// predicate evaluates an instruction writing a predicate register; each
+// lane holds 0 or 1. Integer comparisons are decided from the difference of
+// their operands, so both sides must share the same symbolic part and are
+// assumed not to wrap around.
+func (e *warpEval) predicate(s warpEnv, inst *builder.Instruction) value {
+	src := func(i int) value {
+		if i >= len(inst.Src) {
+			return unknown
+		}
+		return e.operand(s, inst.Src[i])
+	}
+	and := func(a, b int64) (int64, bool) { return a & b, true }
+	or := func(a, b int64) (int64, bool) { return a | b, true }
+	xor := func(a, b int64) (int64, bool) { return a ^ b, true }
+
+	switch inst.Op {
+	case ptx.OpSetp:
+		d := sub(src(0), src(1))
+		if inst.Typ.IsFloat() || !d.concrete() {
+			return unknown
+		}
+		r := value{known: true}
+		for l, x := range d.lanes {
+			t, ok := compare(inst.Cmp, x)
+			if !ok {
+				return unknown
+			}
+			if t {
+				r.lanes[l] = 1
+			}
+		}
+		switch inst.BoolOp {
+		case ptx.BoolAnd:
+			return lanewise(r, src(2), and)
+		case ptx.BoolOr:
+			return lanewise(r, src(2), or)
+		case ptx.BoolXor:
+			return lanewise(r, src(2), xor)
+		}
+		return r
+	case ptx.OpMov:
+		return src(0)
+	case ptx.OpAnd:
+		return lanewise(src(0), src(1), and)
+	case ptx.OpOr:
+		return lanewise(src(0), src(1), or)
+	case ptx.OpXor:
+		return lanewise(src(0), src(1), xor)
+	case ptx.OpNot:
+		return lanewise(src(0), constant(1), xor)
+	}
+	return unknown
+}
+
+// second evaluates the value an instruction writes to Dst2, given the
+// value it wrote to Dst: the complement for setp p|q, and the elected lane
+// for elect.sync (assuming every lane of the warp takes part).
+func (e *warpEval) second(inst *builder.Instruction, first value) value {
+	switch inst.Op {
+	case ptx.OpSetp:
+		if inst.BoolOp == ptx.BoolNone {
+			return lanewise(first, constant(1), func(a, b int64) (int64, bool) { return a ^ b, true })
+		}
+	case ptx.OpElectSync:
+		r := value{known: true}
+		for l := range r.lanes {
+			if e.active[l] {
+				r.lanes[l] = 1
+				break
+			}
+		}
+		return r
+	}
+	return unknown
+}
+
+// compare applies an integer comparison to the difference of its operands.
+func compare(c ptx.CmpOp, d int64) (result, ok bool) {
+	switch c {
+	case ptx.CmpEq:
+		return d == 0, true
+	case ptx.CmpNe:
+		return d != 0, true
+	case ptx.CmpLt, ptx.CmpLo:
+		return d < 0, true
+	case ptx.CmpLe, ptx.CmpLs:
+		return d <= 0, true
+	case ptx.CmpGt, ptx.CmpHi:
+		return d > 0, true
+	case ptx.CmpGe, ptx.CmpHs:
+		return d >= 0, true
+	}
+	return false, false
+}
+
+// laneMask is the set of lanes of a warp that execute an instruction.
+type laneMask struct {
+	lanes [WarpSize]bool
+	exact bool // false if a guard or branch condition could not be evaluated
+}
+
+func (m laneMask) count() int {
+	n := 0
+	for _, on := range m.lanes {
+		if on {
+			n++
+		}
+	}
+	return n
+}
+
+// masks computes which lanes of the warp execute every reachable
+// instruction by following guard and branch predicates. Lanes whose paths
+// meet are assumed to run together, so a lane that runs a loop body several
+// times is counted once, and every loop is assumed to terminate.
+func (e *warpEval) masks(g *CFG) map[Site]laneMask {
+	guards := make(map[Site]value)
+	e.run(g, func(site Site, env warpEnv) {
+		if inst := site.Inst(); inst.Guard != nil {
+			guards[site] = e.operand(env, inst.Guard.Reg)
+		}
+	})
+
+	n := len(g.Func.Blocks)
+	idom := g.dominators()
+	out := make(map[Site]laneMask)
+	if n == 0 {
+		return out
+	}
+	in := make([]laneMask, n)
+	reached := make([]bool, n)
+	in[0], reached[0] = laneMask{lanes: e.active, exact: true}, true
+	work := []int{0}
+	send := func(to int, m laneMask) {
+		if to == Exit || m.count() == 0 {
+			return
+		}
+		if !reached[to] {
+			in[to], reached[to] = m, true
+			work = append(work, to)
+			return
+		}
+		merged := mergeMasks(in[to], m)
+		if merged != in[to] {
+			in[to] = merged
+			work = append(work, to)
+		}
+	}
+
+	for len(work) > 0 {
+		b := work[0]
+		work = work[1:]
+		m := in[b]
+		insts := g.Func.Blocks[b].Instructions
+		done := false
+		for i, inst := range insts {
+			if pseudo(inst) {
+				continue
+			}
+			site := Site{Func: g.Func, Block: b, Index: i}
+			exec := m
+			if inst.Guard != nil {
+				if v := guards[site]; v.concrete() {
+					for l := range exec.lanes {
+						exec.lanes[l] = m.lanes[l] && (v.lanes[l] != 0) != inst.Guard.Negate
+					}
+				} else {
+					exec.exact = false
+				}
+			}
+			if prev, ok := out[site]; ok {
+				out[site] = mergeMasks(prev, exec)
+			} else {
+				out[site] = exec
+			}
+			if to, ok := g.target(inst); ok {
+				if !exec.exact && to != Exit && dominates(idom, to, b) {
+					// Lanes repeating a loop on an unknown condition,
+					// such as a try_wait retry, are assumed to leave it
+					// eventually.
+					send(to, m)
+				} else {
+					send(to, exec)
+					if exec.exact {
+						for l := range m.lanes {
+							m.lanes[l] = m.lanes[l] && !exec.lanes[l]
+						}
+					} else {
+						m.exact = false
+					}
+				}
+			}
+			if terminates(inst) {
+				done = true
+				break
+			}
+		}
+		if !done {
+			if b+1 < n {
+				send(b+1, m)
+			}
+		}
+	}
+	return out
+}
+
+func mergeMasks(a, b laneMask) laneMask {
+	for l := range a.lanes {
+		a.lanes[l] = a.lanes[l] || b.lanes[l]
+	}
+	a.exact = a.exact && b.exact
+	return a
+}
+
+// load evaluates the result of a load. Parameter loads become symbols; a
+// load from a warp-uniform address yields a uniform (if unknown) value.
+func (e *warpEval) load(s warpEnv, site Site, inst *builder.Instruction) value {
+	if len(inst.Src) == 0 {
+		return unknown
+	}
+	addr, ok := inst.Src[0].(*builder.Address)
+	if !ok {
+		return unknown
+	}
+	if base, ok := addr.Base.(*builder.Symbol); ok {
+		if p, ok := e.params[base.Name]; ok {
+			name := sym(base.Name)
+			if addr.Offset != 0 {
+				name = sym(fmt.Sprintf("%s+%d", base.Name, addr.Offset))
+			}
+			if p.IsPointer && addr.Offset == 0 {
+				a := int64(p.Align)
+				if a == 0 {
+					a = defaultPtrAlign
+				}
+				e.align[name] = pow2(a)
+			}
+			return symbol(name)
+		}
+	}
+	if e.operand(s, addr).uniform(&e.active) {
+		return symbol(sym(fmt.Sprintf("ld@%d.%d", site.Block, site.Index)))
+	}
+	return unknown
+}
+
+// operand evaluates a source operand.
+func (e *warpEval) operand(s warpEnv, op builder.Operand) value {
+	switch o := op.(type) {
+	case *builder.Register:
+		if v, ok := s[o]; ok {
+			return v
+		}
+		return unknown
+	case *builder.Immediate:
+		if c, ok := immInt(o); ok {
+			return constant(c)
+		}
+		return unknown
+	case *builder.Symbol:
+		return symbol(sym(o.Name))
+	case *builder.Address:
+		return add(e.operand(s, o.Base), constant(o.Offset))
+	case *builder.SpecialRegOp:
+		return e.special(o.Reg)
+	}
+	return unknown
+}
+
+// special evaluates a special register for this warp.
+func (e *warpEval) special(r ptx.SpecialReg) value {
+	bx, by, bz := e.launch.dims()
+	v := value{known: true}
+	switch r {
+	case ptx.RegTidX, ptx.RegTidY, ptx.RegTidZ:
+		v.lanes = e.tid[r-ptx.RegTidX]
+	case ptx.RegNTidX:
+		return constant(int64(bx))
+	case ptx.RegNTidY:
+		return constant(int64(by))
+	case ptx.RegNTidZ:
+		return constant(int64(bz))
+	case ptx.RegLaneId:
+		for l := range v.lanes {
+			v.lanes[l] = int64(l)
+		}
+	case ptx.RegLanemaskEq, ptx.RegLanemaskLe, ptx.RegLanemaskLt, ptx.RegLanemaskGe, ptx.RegLanemaskGt:
+		for l := range v.lanes {
+			eq := int64(1) << l
+			switch r {
+			case ptx.RegLanemaskEq:
+				v.lanes[l] = eq
+			case ptx.RegLanemaskLt:
+				v.lanes[l] = eq - 1
+			case ptx.RegLanemaskLe:
+				v.lanes[l] = eq<<1 - 1
+			case ptx.RegLanemaskGt:
+				v.lanes[l] = ^(eq<<1 - 1) & 0xFFFFFFFF
+			case ptx.RegLanemaskGe:
+				v.lanes[l] = ^(eq - 1) & 0xFFFFFFFF
+			}
+		}
+	case ptx.RegClock, ptx.RegClockHi, ptx.RegClock64,
+		ptx.RegGlobalTimer, ptx.RegGlobalTimerLo, ptx.RegGlobalTimerHi:
+		return unknown
+	default:
+		return symbol(sym(r.String()))
+	}
+	return v
+}
+
+// immInt returns the value of an integer immediate operand.
+func immInt(op builder.Operand) (int64, bool) {
+	imm, ok := op.(*builder.Immediate)
+	if !ok {
+		return 0, false
+	}
+	switch x := imm.Value.(type) {
+	case int:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	case uint32:
+		return int64(x), true
+	case uint64:
+		return int64(x), true
+	}
+	return 0, false
+}
+
+func safeDiv(a, b int64) (int64, bool) {
+	if b == 0 {
+		return 0, false
+	}
+	return a / b, true
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func gcd(a, b int64) int64 {
+	a, b = abs(a), abs(b)
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// pow2 returns the largest power of two dividing x, or 0 for x == 0.
+func pow2(x int64) int64 {
+	return x & -x
+}
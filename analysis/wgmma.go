@@ -0,0 +1,253 @@
+package analysis
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// wgmmaFamily tracks wgmma.mma_async through wgmma.commit_group and
+// wgmma.wait_group. Its hazards are register accesses rather than shared
+// addresses, so only the group bookkeeping of groupChecker is used.
+var wgmmaFamily = &groupFamily{
+	copy: func(inst *builder.Instruction) (builder.Operand, bool) {
+		return inst.Dst, inst.Op == ptx.OpWgmmaMmaAsync
+	},
+	commit: ptx.OpWgmmaCommitGroup,
+	wait:   ptx.OpWgmmaWaitGroup,
+}
+
+// WgmmaGroups checks the fence/commit/wait protocol of wgmma.mma_async in
+// f. The registers of an MMA are its accumulators and, when A is given as
+// a register fragment rather than a descriptor, the A registers. It
+// reports:
+//
+//   - an MMA with no wgmma.fence before it on some path, or one whose
+//     registers may have been accessed by another instruction since the
+//     last wgmma.fence (MMAs chaining on the same accumulators need none);
+//   - reads and writes of an MMA's registers by any instruction other than
+//     wgmma.mma_async before a wgmma.wait_group covers it;
+//   - MMAs still uncommitted at a wgmma.wait_group, which does not wait for
+//     them, and MMAs a kernel never waits for before it exits.
+func WgmmaGroups(f *builder.Function) []Finding {
+	g := NewCFG(f)
+	c := &groupChecker{fam: wgmmaFamily, index: make(map[Site]int)}
+	regs := make(map[*builder.Register]map[int]bool) // register -> MMAs using it
+	for b, bb := range f.Blocks {
+		for i, inst := range bb.Instructions {
+			if inst.Op != ptx.OpWgmmaMmaAsync {
+				continue
+			}
+			site := Site{Func: f, Block: b, Index: i}
+			n := len(c.copies)
+			c.index[site] = n
+			c.copies = append(c.copies, site)
+			forEachMmaReg(inst, func(r *builder.Register) {
+				if regs[r] == nil {
+					regs[r] = make(map[int]bool)
+				}
+				regs[r][n] = true
+			})
+		}
+	}
+	if len(c.copies) == 0 {
+		return nil
+	}
+
+	findings := checkWgmmaFences(g, c.copies, regs)
+	found := make(map[string]Finding)
+	in, reached := solve[[]groupPath](g, c)
+	visit := func(site Site, s []groupPath) {
+		inst := site.Inst()
+		if inst.Op == ptx.OpWgmmaWaitGroup {
+			for _, p := range s {
+				for _, i := range p.open {
+					found[fmt.Sprintf("%v|%d", site, i)] = Finding{Site: site, Message: fmt.Sprintf(
+						"wgmma.mma_async at %s is not committed, so wgmma.wait_group does not wait for it", c.copies[i])}
+				}
+			}
+		}
+		if isWgmmaOp(inst) {
+			return
+		}
+		for _, how := range []string{"writes", "reads"} {
+			var names []string
+			seen := make(map[int]bool)
+			access := func(r *builder.Register) {
+				hit := false
+				for _, p := range s {
+					for _, i := range p.pending() {
+						if regs[r][i] {
+							hit, seen[i] = true, true
+						}
+					}
+				}
+				if hit && !slices.Contains(names, r.Name) {
+					names = append(names, r.Name)
+				}
+			}
+			if how == "writes" {
+				forEachOperandReg(inst.Dst, access)
+				forEachOperandReg(inst.Dst2, access)
+			} else {
+				for _, src := range inst.Src {
+					forEachOperandReg(src, access)
+				}
+				if inst.Guard != nil {
+					access(inst.Guard.Reg)
+				}
+			}
+			if len(names) > 0 {
+				var mmas []string
+				for _, i := range sortedKeys(seen) {
+					mmas = append(mmas, c.copies[i].String())
+				}
+				found[fmt.Sprintf("%v|%s", site, how)] = Finding{Site: site, Message: fmt.Sprintf(
+					"%s %s %s before wgmma.wait_group covers wgmma.mma_async at %s",
+					inst.Op, how, strings.Join(names, ", "), strings.Join(mmas, ", "))}
+			}
+		}
+	}
+	edge := func(site Site, to int, s []groupPath) {
+		if to != Exit || !f.IsKernel {
+			return
+		}
+		for _, p := range s {
+			for _, i := range p.pending() {
+				found[fmt.Sprintf("exit|%d", i)] = Finding{Site: c.copies[i],
+					Message: "wgmma.mma_async is never waited for before the kernel exits"}
+			}
+		}
+	}
+	replay[[]groupPath](g, c, in, reached, visit, edge)
+
+	keys := make([]string, 0, len(found))
+	for k := range found {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		findings = append(findings, found[k])
+	}
+	sortFindings(findings)
+	return findings
+}
+
+// forEachMmaReg calls fn for the accumulator and A-fragment registers of
+// a wgmma.mma_async.
+func forEachMmaReg(inst *builder.Instruction, fn func(*builder.Register)) {
+	forEachOperandReg(inst.Dst, fn)
+	if len(inst.Src) > 0 {
+		if a, ok := inst.Src[0].(*builder.VectorOp); ok {
+			forEachOperandReg(a, fn)
+		}
+	}
+}
+
+func isWgmmaOp(inst *builder.Instruction) bool {
+	switch inst.Op {
+	case ptx.OpWgmmaMmaAsync, ptx.OpWgmmaFence, ptx.OpWgmmaCommitGroup, ptx.OpWgmmaWaitGroup:
+		return true
+	}
+	return false
+}
+
+// fenceState is the set of MMA registers that may have been accessed since
+// the last wgmma.fence; unfenced is set while no fence has run on some path.
+type fenceState struct {
+	unfenced bool
+	dirty    map[*builder.Register]bool
+}
+
+// fenceChecker follows which MMA registers need a wgmma.fence.
+type fenceChecker struct {
+	regs map[*builder.Register]map[int]bool
+}
+
+func (c *fenceChecker) entry() fenceState {
+	return fenceState{unfenced: true, dirty: make(map[*builder.Register]bool)}
+}
+
+func (c *fenceChecker) clone(s fenceState) fenceState {
+	out := fenceState{unfenced: s.unfenced, dirty: make(map[*builder.Register]bool, len(s.dirty))}
+	for r := range s.dirty {
+		out.dirty[r] = true
+	}
+	return out
+}
+
+func (c *fenceChecker) join(a, b fenceState, _ int) (fenceState, bool) {
+	changed := b.unfenced && !a.unfenced
+	for r := range b.dirty {
+		if !a.dirty[r] {
+			changed = true
+		}
+	}
+	if !changed {
+		return a, false
+	}
+	out := c.clone(a)
+	out.unfenced = out.unfenced || b.unfenced
+	for r := range b.dirty {
+		out.dirty[r] = true
+	}
+	return out, true
+}
+
+func (c *fenceChecker) transfer(s fenceState, site Site) fenceState {
+	inst := site.Inst()
+	switch {
+	case inst.Op == ptx.OpWgmmaFence:
+		if inst.Guard == nil {
+			return fenceState{dirty: make(map[*builder.Register]bool)}
+		}
+	case !isWgmmaOp(inst):
+		forEachReg(inst, func(r *builder.Register) {
+			if c.regs[r] != nil {
+				s.dirty[r] = true
+			}
+		})
+	}
+	return s
+}
+
+// checkWgmmaFences reports MMAs not separated by a wgmma.fence from the
+// start of the function or from other accesses to their registers.
+func checkWgmmaFences(g *CFG, mmas []Site, regs map[*builder.Register]map[int]bool) []Finding {
+	c := &fenceChecker{regs: regs}
+	in, reached := solve[fenceState](g, c)
+	before := make(map[Site]fenceState)
+	replay[fenceState](g, c, in, reached, func(site Site, s fenceState) {
+		if site.Inst().Op == ptx.OpWgmmaMmaAsync {
+			before[site] = c.clone(s)
+		}
+	}, nil)
+
+	var findings []Finding
+	for _, site := range mmas {
+		s, ok := before[site]
+		if !ok {
+			continue
+		}
+		if s.unfenced {
+			findings = append(findings, Finding{Site: site, Message: "no wgmma.fence before wgmma.mma_async on some path"})
+			continue
+		}
+		var names []string
+		forEachMmaReg(site.Inst(), func(r *builder.Register) {
+			if s.dirty[r] {
+				names = append(names, r.Name)
+				delete(s.dirty, r)
+			}
+		})
+		if len(names) > 0 {
+			findings = append(findings, Finding{Site: site, Message: fmt.Sprintf(
+				"wgmma.mma_async registers %s may have been accessed since the last wgmma.fence", strings.Join(names, ", "))})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,156 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// wgmmaKernel is a kernel with shared-memory descriptors a and b, two f32
+// accumulators d0 and d1, and a register fragment for A.
+type wgmmaKernel struct {
+	k         *builder.Function
+	bb        *builder.BasicBlock
+	a, b      *builder.Register
+	d0, d1    *builder.Register
+	frag      *builder.Register
+	out, flag *builder.Register
+}
+
+func newWgmmaKernel() *wgmmaKernel {
+	mod := builder.NewModule(ptx.ISA80, ptx.SM90a)
+	k := mod.NewKernel("k")
+	k.AddParam(builder.NewParam("f", ptx.U32))
+	m := &wgmmaKernel{
+		k:    k,
+		a:    k.NewReg("a", ptx.B64),
+		b:    k.NewReg("b", ptx.B64),
+		d0:   k.NewReg("d0", ptx.F32),
+		d1:   k.NewReg("d1", ptx.F32),
+		frag: k.NewReg("frag", ptx.B32),
+		out:  k.NewReg("out", ptx.F32),
+		flag: k.NewReg("flag", ptx.Pred),
+	}
+	fv := k.NewReg("fv", ptx.U32)
+	m.bb = k.NewBlock("entry")
+	m.bb.Add(builder.Ld(fv, builder.Addr(k.Param("f"), 0)).Typed(ptx.U32).InSpace(ptx.Param))
+	m.bb.Add(builder.Setp(ptx.CmpNe, m.flag, fv, builder.Imm(0)).Typed(ptx.U32))
+	return m
+}
+
+// mma accumulates into d from descriptors a and b.
+func (m *wgmmaKernel) mma(d *builder.Register) *builder.Instruction {
+	return builder.WgmmaMmaAsync(ptx.ModShapeM64N8K16, ptx.ModTypeF32, ptx.ModTypeF16, ptx.ModTypeF16,
+		builder.Vec(d), m.a, m.b, builder.Imm(1))
+}
+
+// mmaFrag accumulates into d from the A fragment and descriptor b.
+func (m *wgmmaKernel) mmaFrag(d *builder.Register) *builder.Instruction {
+	return builder.WgmmaMmaAsync(ptx.ModShapeM64N8K16, ptx.ModTypeF32, ptx.ModTypeF16, ptx.ModTypeF16,
+		builder.Vec(d), builder.Vec(m.frag), m.b, builder.Imm(1))
+}
+
+func (m *wgmmaKernel) use(r *builder.Register) *builder.Instruction {
+	return builder.Add(m.out, r, r).Typed(ptx.F32)
+}
+
+func (m *wgmmaKernel) end() *builder.Function {
+	m.k.NewBlock("end").Add(builder.Ret())
+	return m.k
+}
+
+func TestWgmmaGroups(t *testing.T) {
+	fence, commit := builder.WgmmaFence, builder.WgmmaCommitGroup
+	wait := func(n int64) *builder.Instruction { return builder.WgmmaWaitGroup(builder.Imm(n)) }
+	for _, tt := range []struct {
+		name  string
+		build func(m *wgmmaKernel)
+		want  []string
+	}{
+		{"fence, commit, wait, read", func(m *wgmmaKernel) {
+			m.bb.Add(fence())
+			m.bb.Add(m.mma(m.d0))
+			m.bb.Add(commit())
+			m.bb.Add(wait(0))
+			m.bb.Add(m.use(m.d0))
+		}, nil},
+		{"chained on the same accumulator", func(m *wgmmaKernel) {
+			m.bb.Add(fence())
+			m.bb.Add(m.mma(m.d0))
+			m.bb.Add(m.mma(m.d0))
+			m.bb.Add(commit())
+			m.bb.Add(wait(0))
+		}, nil},
+		{"no fence", func(m *wgmmaKernel) {
+			m.bb.Add(m.mma(m.d0))
+			m.bb.Add(commit())
+			m.bb.Add(wait(0))
+		}, []string{"no wgmma.fence before wgmma.mma_async on some path"}},
+		{"fence on one path", func(m *wgmmaKernel) {
+			m.bb.Add(builder.Bra("mma").Pred(m.flag))
+			m.k.NewBlock("fence").Add(fence())
+			m.bb = m.k.NewBlock("mma")
+			m.bb.Add(m.mma(m.d0))
+			m.bb.Add(commit())
+			m.bb.Add(wait(0))
+		}, []string{"no wgmma.fence before wgmma.mma_async on some path"}},
+		{"accumulator written after the fence", func(m *wgmmaKernel) {
+			m.bb.Add(fence())
+			m.bb.Add(builder.Mov(m.d0, builder.Imm(0)).Typed(ptx.F32))
+			m.bb.Add(m.mma(m.d0))
+			m.bb.Add(commit())
+			m.bb.Add(wait(0))
+		}, []string{"wgmma.mma_async registers %d0 may have been accessed since the last wgmma.fence"}},
+		{"fragment written after the fence", func(m *wgmmaKernel) {
+			m.bb.Add(builder.Mov(m.d0, builder.Imm(0)).Typed(ptx.F32))
+			m.bb.Add(fence())
+			m.bb.Add(builder.Mov(m.frag, builder.Imm(0)).Typed(ptx.B32))
+			m.bb.Add(m.mmaFrag(m.d0))
+			m.bb.Add(commit())
+			m.bb.Add(wait(0))
+		}, []string{"wgmma.mma_async registers %frag may have been accessed since the last wgmma.fence"}},
+		{"read before wait", func(m *wgmmaKernel) {
+			m.bb.Add(fence())
+			m.bb.Add(m.mma(m.d0))
+			m.bb.Add(commit())
+			m.bb.Add(m.use(m.d0))
+			m.bb.Add(wait(0))
+		}, []string{"add reads %d0 before wgmma.wait_group covers wgmma.mma_async at k/entry#3"}},
+		{"fragment overwritten before wait", func(m *wgmmaKernel) {
+			m.bb.Add(fence())
+			m.bb.Add(m.mmaFrag(m.d0))
+			m.bb.Add(commit())
+			m.bb.Add(builder.Mov(m.frag, builder.Imm(0)).Typed(ptx.B32))
+			m.bb.Add(wait(0))
+		}, []string{"mov writes %frag before wgmma.wait_group covers wgmma.mma_async"}},
+		{"wait 1 leaves the latest group pending", func(m *wgmmaKernel) {
+			m.bb.Add(fence())
+			m.bb.Add(m.mma(m.d0))
+			m.bb.Add(commit())
+			m.bb.Add(m.mma(m.d1))
+			m.bb.Add(commit())
+			m.bb.Add(wait(1))
+			m.bb.Add(m.use(m.d0))
+			m.bb.Add(m.use(m.d1))
+			m.bb.Add(wait(0))
+		}, []string{"add reads %d1 before wgmma.wait_group covers wgmma.mma_async at k/entry#5"}},
+		{"uncommitted at the wait", func(m *wgmmaKernel) {
+			m.bb.Add(fence())
+			m.bb.Add(m.mma(m.d0))
+			m.bb.Add(wait(0))
+		}, []string{
+			"wgmma.mma_async is never waited for before the kernel exits",
+			"wgmma.mma_async at k/entry#3 is not committed, so wgmma.wait_group does not wait for it",
+		}},
+		{"never waited for", func(m *wgmmaKernel) {
+			m.bb.Add(fence())
+			m.bb.Add(m.mma(m.d0))
+			m.bb.Add(commit())
+		}, []string{"wgmma.mma_async is never waited for before the kernel exits"}},
+	} {
+		m := newWgmmaKernel()
+		tt.build(m)
+		checkFindings(t, tt.name, WgmmaGroups(m.end()), tt.want...)
+	}
+}
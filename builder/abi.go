@@ -0,0 +1,204 @@
+package builder
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// CallFunc adds the complete call sequence for callee to the block and
+// returns the registers holding its result, or nil if it returns nothing.
+// The block must have been created with Function.NewBlock, which supplies
+// the result registers.
+//
+// Scalar parameters are passed in registers, as callee declares them:
+//
+//	call (%fd0), relu, (%fd1);
+//
+// Byte-array parameters (by-value structs) are staged in .param variables
+// inside a nested scope. Their argument is a Vec of registers, laid out
+// like a C struct with each element aligned to its width, a single
+// register, or an immediate of at most 8 bytes that fills the whole
+// parameter. The elements must cover the parameter up to its tail padding.
+// A byte-array result is loaded into registers of the widest bit type its
+// size and alignment allow:
+//
+//	{
+//		.param .align 8 .b8 param0[16];
+//		st.param.b64 [param0], %rd0;
+//		st.param.b64 [param0+8], %rd1;
+//		.param .align 4 .b8 retval0[8];
+//		call (retval0), mix, (param0);
+//		ld.param.b32 %r0, [retval0];
+//		ld.param.b32 %r1, [retval0+4];
+//	}
+//
+// It returns an error if the block has no function, the argument count
+// differs from the callee's, or an argument does not fit its byte-array
+// parameter.
+func (bb *BasicBlock) CallFunc(callee *Function, args []Operand) ([]*Register, error) {
+	if bb.fn == nil {
+		return nil, fmt.Errorf("builder: CallFunc needs a block created by Function.NewBlock")
+	}
+	if len(args) != len(callee.Params) {
+		return nil, fmt.Errorf("builder: call to %s has %d arguments, want %d", callee.Name, len(args), len(callee.Params))
+	}
+
+	var seq []*Instruction
+	scoped := false
+	declare := func(name string, p *Param) *Symbol {
+		if !scoped {
+			seq = append(seq, ScopeBegin())
+			scoped = true
+		}
+		seq = append(seq, Declare(&Global{Name: name, Space: ptx.Param, Typ: p.Typ, Count: p.Size, Align: p.Align}))
+		return &Symbol{Name: name}
+	}
+
+	passed := make([]Operand, len(args))
+	for i, arg := range args {
+		p := callee.Params[i]
+		if p.Size == 0 {
+			passed[i] = arg
+			continue
+		}
+		sym := declare(fmt.Sprintf("param%d", i), p)
+		stores, err := storeParam(sym, arg, p)
+		if err != nil {
+			return nil, fmt.Errorf("builder: call to %s: argument %d: %v", callee.Name, i, err)
+		}
+		seq = append(seq, stores...)
+		passed[i] = sym
+	}
+
+	var dst Operand
+	var results []*Register
+	var loads []*Instruction
+	if len(callee.ReturnParams) > 0 {
+		rp := callee.ReturnParams[0]
+		if rp.Size == 0 {
+			r := bb.fn.TempReg(rp.Typ)
+			dst, results = r, []*Register{r}
+		} else {
+			sym := declare("retval0", rp)
+			dst = sym
+			t := chunkType(rp)
+			width := t.BitWidth() / 8
+			for off := 0; off < rp.Size; off += width {
+				r := bb.fn.TempReg(t)
+				results = append(results, r)
+				loads = append(loads, Ld(r, Addr(sym, int64(off))).Typed(t).InSpace(ptx.Param))
+			}
+		}
+	}
+
+	call := &Instruction{Op: ptx.OpCall, Dst: dst, Src: passed, CallTarget: callee.Name}
+	seq = append(seq, call)
+	seq = append(seq, loads...)
+	if scoped {
+		seq = append(seq, ScopeEnd())
+	}
+	for _, inst := range seq {
+		bb.Add(inst)
+	}
+	return results, nil
+}
+
+// storeParam stores arg into the byte-array parameter p, declared as sym.
+// Registers are stored as the bit type of their width at the next offset
+// aligned to it; an immediate is split into chunks covering the parameter.
+func storeParam(sym *Symbol, arg Operand, p *Param) ([]*Instruction, error) {
+	if imm, ok := arg.(*Immediate); ok {
+		return storeImmediate(sym, imm, p)
+	}
+	elems := []Operand{arg}
+	if v, ok := arg.(*VectorOp); ok {
+		elems = v.Elements
+	}
+	align := max(p.Align, 1)
+	var seq []*Instruction
+	off := 0
+	for i, el := range elems {
+		r, ok := el.(*Register)
+		if !ok || r == nil {
+			return nil, fmt.Errorf("element %d is not a register", i)
+		}
+		t := bitsType(r.Typ.BitWidth())
+		width := t.BitWidth() / 8
+		if width > align {
+			return nil, fmt.Errorf("element %d needs %d-byte alignment, the parameter has %d", i, width, align)
+		}
+		off = (off + width - 1) / width * width
+		if off+width > p.Size {
+			return nil, fmt.Errorf("elements overflow the %d-byte parameter", p.Size)
+		}
+		seq = append(seq, St(Addr(sym, int64(off)), r).Typed(t).InSpace(ptx.Param))
+		off += width
+	}
+	if (off+align-1)/align*align < p.Size {
+		return nil, fmt.Errorf("elements fill %d of the %d-byte parameter", off, p.Size)
+	}
+	return seq, nil
+}
+
+// storeImmediate stores the bits of imm, little endian, over the whole of
+// the byte-array parameter p in its chunk type.
+func storeImmediate(sym *Symbol, imm *Immediate, p *Param) ([]*Instruction, error) {
+	var bits uint64
+	switch v := imm.Value.(type) {
+	case int64:
+		bits = uint64(v)
+	case uint64:
+		bits = v
+	case float32:
+		bits = uint64(math.Float32bits(v))
+	case float64:
+		bits = math.Float64bits(v)
+	default:
+		return nil, fmt.Errorf("unsupported immediate %v", imm.Value)
+	}
+	if p.Size > 8 {
+		return nil, fmt.Errorf("an immediate cannot fill a %d-byte parameter", p.Size)
+	}
+	t := chunkType(p)
+	width := t.BitWidth() / 8
+	var seq []*Instruction
+	for off := 0; off < p.Size; off += width {
+		chunk := bits >> (8 * off)
+		if width < 8 {
+			chunk &= 1<<(8*width) - 1
+		}
+		seq = append(seq, St(Addr(sym, int64(off)), ImmU(chunk)).Typed(t).InSpace(ptx.Param))
+	}
+	return seq, nil
+}
+
+// chunkType returns the widest bit type that evenly divides a byte-array
+// parameter and does not exceed its alignment (1 if unset).
+func chunkType(p *Param) ptx.Type {
+	for _, t := range []ptx.Type{ptx.B64, ptx.B32, ptx.B16} {
+		n := t.BitWidth() / 8
+		if p.Size%n == 0 && p.Align >= n {
+			return t
+		}
+	}
+	return ptx.B8
+}
+
+// bitsType returns the .bN type of the given width, or .b8 for widths
+// below a byte.
+func bitsType(bits int) ptx.Type {
+	switch {
+	case bits >= 128:
+		return ptx.B128
+	case bits >= 64:
+		return ptx.B64
+	case bits >= 32:
+		return ptx.B32
+	case bits >= 16:
+		return ptx.B16
+	default:
+		return ptx.B8
+	}
+}
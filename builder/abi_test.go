@@ -0,0 +1,136 @@
+package builder_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/codegen"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// callBody emits the module and returns the body of function caller.
+func callBody(t *testing.T, mod *builder.Module) string {
+	t.Helper()
+	src, err := codegen.EmitChecked(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := strings.Index(src, ".func caller(")
+	if i < 0 {
+		t.Fatalf("no caller in:\n%s", src)
+	}
+	body := src[i:]
+	return body[strings.Index(body, "{")+1 : strings.LastIndex(body, "}")]
+}
+
+func TestCallFuncScalar(t *testing.T) {
+	mod := builder.NewModule(ptx.ISA80, ptx.SM80)
+	relu := mod.NewFunc("relu")
+	relu.AddParam(builder.NewParam("x", ptx.F32)).AddReturnParam(builder.NewParam("y", ptx.F32))
+	relu.NewBlock("").Add(builder.Ret())
+	caller := mod.NewFunc("caller")
+	x := caller.NewReg("x", ptx.F32)
+	bb := caller.NewBlock("")
+	res, err := bb.CallFunc(relu, []builder.Operand{x})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb.Add(builder.Ret())
+	if len(res) != 1 || res[0].Typ != ptx.F32 {
+		t.Fatalf("results = %v, want one .f32 register", res)
+	}
+	want := "call (" + res[0].Name + "), relu, (%x);\nret;"
+	if got := normalize(callBody(t, mod)); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCallFuncByteArray(t *testing.T) {
+	mod := builder.NewModule(ptx.ISA80, ptx.SM80)
+	mix := mod.NewFunc("mix")
+	mix.AddParam(builder.NewByteArrayParam("s", 16, 8))
+	mix.AddParam(builder.NewByteArrayParam("c", 8, 8))
+	mix.AddReturnParam(builder.NewByteArrayParam("r", 8, 4))
+	mix.NewBlock("").Add(builder.Ret())
+	caller := mod.NewFunc("caller")
+	n := caller.NewReg("n", ptx.U32)
+	ptr := caller.NewReg("ptr", ptx.U64)
+	bb := caller.NewBlock("")
+	res, err := bb.CallFunc(mix, []builder.Operand{builder.Vec(n, ptr), builder.Imm(5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb.Add(builder.Ret())
+	if len(res) != 2 {
+		t.Fatalf("got %d result registers, want 2", len(res))
+	}
+
+	want := `
+	{
+	.param .align 8 .b8 param0[16];
+	st.param.b32 [param0], %n;
+	st.param.b64 [param0+8], %ptr;
+	.param .align 8 .b8 param1[8];
+	st.param.b64 [param1], 5;
+	.param .align 4 .b8 retval0[8];
+	call (retval0), mix, (param0, param1);
+	ld.param.b32 R0, [retval0];
+	ld.param.b32 R1, [retval0+4];
+	}
+	ret;
+`
+	want = strings.NewReplacer("R0", res[0].Name, "R1", res[1].Name).Replace(want)
+	if got := normalize(callBody(t, mod)); got != normalize(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, normalize(want))
+	}
+}
+
+// normalize drops declarations of registers and collapses white space, so
+// the comparison covers only the call sequence.
+func normalize(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" && !strings.HasPrefix(line, ".reg ") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestCallFuncErrors(t *testing.T) {
+	mod := builder.NewModule(ptx.ISA80, ptx.SM80)
+	f := mod.NewFunc("f")
+	f.AddParam(builder.NewByteArrayParam("s", 16, 8))
+	small := mod.NewFunc("small")
+	small.AddParam(builder.NewByteArrayParam("s", 8, 4))
+	caller := mod.NewFunc("caller")
+	a := caller.NewReg("a", ptx.U64)
+	b := caller.NewReg("b", ptx.U32)
+	bb := caller.NewBlock("")
+
+	for _, tt := range []struct {
+		name   string
+		bb     *builder.BasicBlock
+		callee *builder.Function
+		args   []builder.Operand
+		want   string
+	}{
+		{"no function", &builder.BasicBlock{}, f, []builder.Operand{builder.Vec(a, a)}, "Function.NewBlock"},
+		{"argument count", bb, f, nil, "0 arguments, want 1"},
+		{"overflow", bb, f, []builder.Operand{builder.Vec(a, a, b)}, "overflow"},
+		{"underfill", bb, f, []builder.Operand{builder.Vec(b)}, "fill 4 of the 16-byte"},
+		{"misaligned", bb, small, []builder.Operand{a}, "8-byte alignment"},
+		{"large immediate", bb, f, []builder.Operand{builder.Imm(1)}, "cannot fill"},
+		{"non-register element", bb, f, []builder.Operand{builder.Vec(a, builder.Imm(1))}, "not a register"},
+	} {
+		_, err := tt.bb.CallFunc(tt.callee, tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+	if len(bb.Instructions) != 0 {
+		t.Errorf("failed calls added %d instructions", len(bb.Instructions))
+	}
+}
@@ -5,10 +5,27 @@ package builder
 type BasicBlock struct {
     Label        string
     Instructions []*Instruction
+    Annotation   string // comment printed after the label, or above the first instruction if unlabeled
+
+    fn *Function // owning function, if created through NewBlock
 }
 
-// Add appends an instruction to this block.
+// Add appends an instruction to this block. If the module has
+// CaptureLocations set, the caller's source line is recorded in inst.Loc.
 func (bb *BasicBlock) Add(inst *Instruction) *BasicBlock {
+    if inst != nil && inst.Loc == nil && bb.capturing() {
+        inst.Loc = callerLoc()
+    }
     bb.Instructions = append(bb.Instructions, inst)
     return bb
-}
\ No newline at end of file
+}
+
+// Comment attaches a comment to the block's label.
+func (bb *BasicBlock) Comment(text string) *BasicBlock {
+    bb.Annotation = text
+    return bb
+}
+
+func (bb *BasicBlock) capturing() bool {
+    return bb.fn != nil && bb.fn.mod != nil && bb.fn.mod.CaptureLocations
+}
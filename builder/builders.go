@@ -1668,4 +1668,41 @@ func SetMaxNReg(action ptx.Modifier, count Operand) *Instruction {
             ptx.ModTypeU32,
         },
     }
-}
\ No newline at end of file
+}
+
+// Comment creates a standalone comment line in a block. It emits no code.
+//
+// Syntax: // text
+func Comment(text string) *Instruction {
+    return &Instruction{Op: ptx.OpComment, Annotation: text}
+}
+
+// ScopeBegin opens a nested scope. Variables declared inside it with Declare
+// are visible until the matching ScopeEnd.
+//
+// Syntax: {
+func ScopeBegin() *Instruction {
+    return &Instruction{Op: ptx.OpScopeBegin}
+}
+
+// ScopeEnd closes the innermost nested scope.
+//
+// Syntax: }
+func ScopeEnd() *Instruction {
+    return &Instruction{Op: ptx.OpScopeEnd}
+}
+
+// Declare declares a variable in the enclosing scope, such as the .param
+// staging a call argument. It emits no code.
+//
+// Syntax: .param .align 8 .b8 param0[16];
+func Declare(v *Global) *Instruction {
+    return &Instruction{Op: ptx.OpDecl, Var: v}
+}
+
+// DeclareReg declares a register in the enclosing scope. It emits no code.
+//
+// Syntax: .reg .u32 %tmp;
+func DeclareReg(r *Register) *Instruction {
+    return &Instruction{Op: ptx.OpDecl, Dst: r}
+}
@@ -0,0 +1,222 @@
+package builder
+
+import (
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// Clone returns a deep copy of the module. Nothing in the copy is shared
+// with m, and each function's copy refers to its own registers, so the two
+// can be modified independently.
+func (m *Module) Clone() *Module {
+	nm := *m
+	nm.Files = append([]string(nil), m.Files...)
+	nm.Globals = make([]*Global, len(m.Globals))
+	for i, g := range m.Globals {
+		nm.Globals[i] = g.clone()
+	}
+	nm.Functions = make([]*Function, len(m.Functions))
+	for i, f := range m.Functions {
+		if f != nil {
+			f = f.Clone()
+			f.mod = &nm
+		}
+		nm.Functions[i] = f
+	}
+	return &nm
+}
+
+// Clone returns a deep copy of the function. Every register the function
+// uses, including registers declared in nested scopes, is replaced by a
+// new register of the same name and type, so two instructions that shared
+// a register in f share its copy. The copy belongs to no module until it
+// is added with Module.AddFunction.
+func (f *Function) Clone() *Function {
+	c := &cloner{regs: make(map[*Register]*Register), vars: make(map[*Global]*Global)}
+	nf := *f
+	nf.mod = nil
+	nf.Params = cloneParams(f.Params)
+	nf.ReturnParams = cloneParams(f.ReturnParams)
+	nf.Attributes = cloneAttributes(f.Attributes)
+
+	nf.Registers = make([]*Register, len(f.Registers))
+	for i, r := range f.Registers {
+		nf.Registers[i] = c.reg(r)
+	}
+	nf.Locals = make([]*Global, len(f.Locals))
+	for i, v := range f.Locals {
+		nf.Locals[i] = c.global(v)
+	}
+	nf.Directives = make([]*Directive, len(f.Directives))
+	for i, d := range f.Directives {
+		if d != nil {
+			nd := *d
+			nd.Values = append([]int(nil), d.Values...)
+			d = &nd
+		}
+		nf.Directives[i] = d
+	}
+	nf.Prototypes = make([]*CallPrototype, len(f.Prototypes))
+	for i, p := range f.Prototypes {
+		if p != nil {
+			p = &CallPrototype{Label: p.Label, Params: cloneParams(p.Params), ReturnParams: cloneParams(p.ReturnParams)}
+		}
+		nf.Prototypes[i] = p
+	}
+	nf.CallTargetLists = make([]*CallTargetList, len(f.CallTargetLists))
+	for i, l := range f.CallTargetLists {
+		if l != nil {
+			l = &CallTargetList{Label: l.Label, Targets: append([]string(nil), l.Targets...)}
+		}
+		nf.CallTargetLists[i] = l
+	}
+
+	nf.Blocks = make([]*BasicBlock, len(f.Blocks))
+	for i, bb := range f.Blocks {
+		if bb == nil {
+			continue
+		}
+		nb := *bb
+		nb.fn = &nf
+		nb.Instructions = make([]*Instruction, len(bb.Instructions))
+		for j, inst := range bb.Instructions {
+			nb.Instructions[j] = c.inst(inst)
+		}
+		nf.Blocks[i] = &nb
+	}
+
+	if f.regCounter != nil {
+		nf.regCounter = make(map[string]int, len(f.regCounter))
+		for k, v := range f.regCounter {
+			nf.regCounter[k] = v
+		}
+	}
+	return &nf
+}
+
+// cloner copies the parts of a function, keeping one copy per register and
+// per declared variable.
+type cloner struct {
+	regs map[*Register]*Register
+	vars map[*Global]*Global
+}
+
+func (c *cloner) reg(r *Register) *Register {
+	if r == nil {
+		return nil
+	}
+	nr, ok := c.regs[r]
+	if !ok {
+		reg := *r
+		nr = &reg
+		c.regs[r] = nr
+	}
+	return nr
+}
+
+func (c *cloner) global(g *Global) *Global {
+	if g == nil {
+		return nil
+	}
+	ng, ok := c.vars[g]
+	if !ok {
+		ng = g.clone()
+		c.vars[g] = ng
+	}
+	return ng
+}
+
+func (c *cloner) inst(inst *Instruction) *Instruction {
+	if inst == nil {
+		return nil
+	}
+	ni := *inst
+	ni.Dst = c.operand(inst.Dst)
+	ni.Dst2 = c.operand(inst.Dst2)
+	ni.Src = make([]Operand, len(inst.Src))
+	for i, op := range inst.Src {
+		ni.Src[i] = c.operand(op)
+	}
+	if inst.Src == nil {
+		ni.Src = nil
+	}
+	ni.Modifiers = append([]ptx.Modifier(nil), inst.Modifiers...)
+	if inst.Guard != nil {
+		ni.Guard = &Predicate{Reg: c.reg(inst.Guard.Reg), Negate: inst.Guard.Negate}
+	}
+	if inst.Loc != nil {
+		loc := *inst.Loc
+		ni.Loc = &loc
+	}
+	ni.Var = c.global(inst.Var)
+	return &ni
+}
+
+func (c *cloner) operand(op Operand) Operand {
+	switch o := op.(type) {
+	case *Register:
+		if o != nil {
+			return c.reg(o)
+		}
+	case *Immediate:
+		if o != nil {
+			return &Immediate{Value: o.Value}
+		}
+	case *Symbol:
+		if o != nil {
+			return &Symbol{Name: o.Name}
+		}
+	case *Address:
+		if o != nil {
+			return &Address{Base: c.operand(o.Base), Offset: o.Offset}
+		}
+	case *VectorOp:
+		if o != nil {
+			elems := make([]Operand, len(o.Elements))
+			for i, el := range o.Elements {
+				elems[i] = c.operand(el)
+			}
+			return &VectorOp{Elements: elems}
+		}
+	case *SpecialRegOp:
+		if o != nil {
+			return &SpecialRegOp{Reg: o.Reg}
+		}
+	}
+	return op
+}
+
+func (g *Global) clone() *Global {
+	if g == nil {
+		return nil
+	}
+	ng := *g
+	ng.Initializer = append([]interface{}(nil), g.Initializer...)
+	ng.Attributes = cloneAttributes(g.Attributes)
+	return &ng
+}
+
+func cloneParams(params []*Param) []*Param {
+	if params == nil {
+		return nil
+	}
+	out := make([]*Param, len(params))
+	for i, p := range params {
+		if p != nil {
+			param := *p
+			p = &param
+		}
+		out[i] = p
+	}
+	return out
+}
+
+func cloneAttributes(attrs []VarAttribute) []VarAttribute {
+	if attrs == nil {
+		return nil
+	}
+	out := make([]VarAttribute, len(attrs))
+	for i, a := range attrs {
+		out[i] = VarAttribute{Name: a.Name, Params: append([]interface{}(nil), a.Params...)}
+	}
+	return out
+}
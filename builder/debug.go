@@ -0,0 +1,58 @@
+package builder
+
+import (
+	"runtime"
+	"strings"
+)
+
+// SourceLoc is the source position an instruction was generated from. It
+// is emitted as a .loc directive, with File resolved to a .file index.
+type SourceLoc struct {
+	File   string
+	Line   int
+	Column int // 0 if unknown
+}
+
+// AddFile declares a source file for .file and returns its index. Files
+// named by instruction locations are declared automatically when the
+// module is emitted; AddFile fixes their order up front.
+func (m *Module) AddFile(name string) int {
+	for i, f := range m.Files {
+		if f == name {
+			return i + 1
+		}
+	}
+	m.Files = append(m.Files, name)
+	return len(m.Files)
+}
+
+// At records the source position the instruction came from.
+func (i *Instruction) At(file string, line, column int) *Instruction {
+	i.Loc = &SourceLoc{File: file, Line: line, Column: column}
+	return i
+}
+
+// builderPkg is the prefix of function names in this package, as reported
+// by the runtime.
+var builderPkg = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name() // .../builder.init.func1
+	slash := strings.LastIndex(name, "/")
+	return name[:slash+strings.Index(name[slash:], ".")+1]
+}()
+
+// callerLoc returns the position of the first caller outside this package,
+// i.e. the line of generator code that added an instruction.
+func callerLoc() *SourceLoc {
+	var pcs [16]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs[:])])
+	for {
+		fr, more := frames.Next()
+		if !strings.HasPrefix(fr.Function, builderPkg) {
+			return &SourceLoc{File: fr.File, Line: fr.Line}
+		}
+		if !more {
+			return nil
+		}
+	}
+}
@@ -20,6 +20,30 @@ const (
 	DirAlias                                 // .alias
 )
 
+var directiveNames = [...]string{
+	DirMaxNReg:           ".maxnreg",
+	DirMaxNTid:           ".maxntid",
+	DirReqNTid:           ".reqntid",
+	DirMinNCTAPerSM:      ".minnctapersm",
+	DirMaxNCTAPerSM:      ".maxnctapersm",
+	DirPragma:            ".pragma",
+	DirReqNCluster:       ".reqnctapercluster",
+	DirNoReturn:          ".noreturn",
+	DirAbiPreserve:       ".abi_preserve",
+	DirAbiPreserveCtrl:   ".abi_preserve_control",
+	DirExplicitCluster:   ".explicitcluster",
+	DirMaxClusterRank:    ".maxclusterrank",
+	DirBlocksAreClusters: ".blocksareclusters",
+	DirAlias:             ".alias",
+}
+
+func (k DirectiveKind) String() string {
+	if k >= 0 && int(k) < len(directiveNames) {
+		return directiveNames[k]
+	}
+	return ""
+}
+
 // Directive represents a single performance-tuning directive on a function.
 type Directive struct {
 	Kind   DirectiveKind
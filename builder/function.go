@@ -10,6 +10,7 @@ import (
 type Function struct {
 	Name     string
 	IsKernel bool        // true = .entry, false = .func
+	IsDecl   bool        // declaration only: printed as a prototype with no body
 	Linkage  ptx.Linkage // .visible, .extern, .weak, etc.
 
 	// Parameters
@@ -19,16 +20,26 @@ type Function struct {
 	// Body
 	Blocks    []*BasicBlock // ordered basic blocks
 	Registers []*Register   // all declared registers (collected for .reg declarations)
+	Locals    []*Global     // function-scope .local, .shared and .param variables
 
 	// Performance tuning directives
 	Directives []*Directive
 
+	// Indirect call declarations (.callprototype, .calltargets)
+	Prototypes      []*CallPrototype
+	CallTargetLists []*CallTargetList
+
 	// Function Attributes (Section 5.4.8)
 	// e.g. .attribute(.unified(uuid1, uuid2))
 	Attributes []VarAttribute
 
+	// Comment printed above the function signature
+	Annotation string
+
 	// Internal counter for auto-naming registers
 	regCounter map[string]int
+
+	mod *Module // owning module, if created through it
 }
 
 // AddParam appends a kernel or function input parameter.
@@ -43,6 +54,12 @@ func (f *Function) AddReturnParam(p *Param) *Function {
 	return f
 }
 
+// Comment attaches a comment that is printed above the function signature.
+func (f *Function) Comment(text string) *Function {
+	f.Annotation = text
+	return f
+}
+
 // Param looks up a parameter by name and returns it as a Symbol operand
 // suitable for use in ld.param / st.param instructions.
 func (f *Function) Param(name string) *Symbol {
@@ -103,6 +120,7 @@ func tempPrefix(t ptx.Type) string {
 func (f *Function) NewBlock(label string) *BasicBlock {
 	bb := &BasicBlock{
 		Label: label,
+		fn:    f,
 	}
 	f.Blocks = append(f.Blocks, bb)
 	return bb
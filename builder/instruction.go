@@ -27,6 +27,10 @@ type Instruction struct {
 
     SrcType   ptx.Type          // For cvt (source type)
     CallTarget string           // For call
+
+    Annotation string           // comment printed after the instruction, or the text of an OpComment
+    Loc        *SourceLoc       // source position for .loc, if known
+    Var        *Global          // For OpDecl: the variable declared, or nil to declare the Dst register
 }
 
 // Predicate represents a guard predicate on an instruction: @p or @!p
@@ -114,4 +118,11 @@ func (i *Instruction) WithDst2(dst Operand) *Instruction {
 func (i *Instruction) SourceTyped(t ptx.Type) *Instruction {
 	i.SrcType = t
 	return i
-}
\ No newline at end of file
+}
+
+// Comment attaches a comment that is printed after the instruction:
+//   ld.global.f32  %f0, [%rd0];  // load A tile
+func (i *Instruction) Comment(text string) *Instruction {
+	i.Annotation = text
+	return i
+}
@@ -0,0 +1,932 @@
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// irFormat is the version of the JSON layout written by MarshalJSON. It
+// changes only when the layout does; new enum values need no new format
+// because enums are stored by name.
+const irFormat = 1
+
+// MarshalJSON encodes the module and everything in it as JSON.
+//
+// Enums are written by their PTX spelling (".u32", "ld", "%tid.x"), so
+// stored IR keeps loading when new values are added to the ptx package. A
+// spelling that several constants share is numbered in declaration order
+// from the second one on: ModCasB is ".cas#2". Zero values of optional
+// instruction fields are left out. Registers are listed once per function
+// and referred to by index, so operands that shared a register still share
+// it after UnmarshalJSON.
+func (m *Module) MarshalJSON() ([]byte, error) {
+	jm, err := encodeModule(m)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jm)
+}
+
+// UnmarshalJSON replaces m with the module encoded in data by MarshalJSON.
+// Data from a newer format, or naming an enum value this version of the
+// package does not know, is rejected.
+func (m *Module) UnmarshalJSON(data []byte) error {
+	var jm jsonModule
+	if err := json.Unmarshal(data, &jm); err != nil {
+		return err
+	}
+	switch {
+	case jm.Format == 0:
+		return errors.New("builder: JSON module has no format version")
+	case jm.Format > irFormat:
+		return fmt.Errorf("builder: JSON module format %d is newer than supported format %d", jm.Format, irFormat)
+	}
+	nm, err := decodeModule(&jm)
+	if err != nil {
+		return err
+	}
+	*m = *nm
+	for _, f := range m.Functions {
+		if f != nil {
+			f.mod = m
+		}
+	}
+	return nil
+}
+
+// --- Wire types ---
+
+type jsonModule struct {
+	Format           int             `json:"format"`
+	Version          string          `json:"version"`
+	Target           string          `json:"target"`
+	AddressSize      int             `json:"address_size"`
+	Files            []string        `json:"files,omitempty"`
+	CaptureLocations bool            `json:"capture_locations,omitempty"`
+	Globals          []*jsonGlobal   `json:"globals,omitempty"`
+	Functions        []*jsonFunction `json:"functions,omitempty"`
+}
+
+type jsonGlobal struct {
+	Name        string          `json:"name"`
+	Space       string          `json:"space"`
+	Type        string          `json:"type"`
+	Vec         string          `json:"vec,omitempty"`
+	Count       int             `json:"count,omitempty"`
+	Align       int             `json:"align,omitempty"`
+	Linkage     string          `json:"linkage,omitempty"`
+	Initializer []jsonValue     `json:"init,omitempty"`
+	Attributes  []jsonAttribute `json:"attributes,omitempty"`
+}
+
+type jsonAttribute struct {
+	Name   string      `json:"name"`
+	Params []jsonValue `json:"params,omitempty"`
+}
+
+// jsonValue is an initializer, attribute parameter or immediate, with its
+// Go type so that it decodes to the same dynamic type.
+type jsonValue struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type jsonParam struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Size     int    `json:"size,omitempty"`
+	Align    int    `json:"align,omitempty"`
+	Pointer  bool   `json:"pointer,omitempty"`
+	PtrSpace string `json:"ptr_space,omitempty"`
+}
+
+type jsonFunction struct {
+	Name         string            `json:"name"`
+	Kernel       bool              `json:"kernel,omitempty"`
+	Decl         bool              `json:"decl,omitempty"`
+	Linkage      string            `json:"linkage,omitempty"`
+	Comment      string            `json:"comment,omitempty"`
+	Params       []*jsonParam      `json:"params,omitempty"`
+	ReturnParams []*jsonParam      `json:"return_params,omitempty"`
+	Registers    []jsonRegister    `json:"registers,omitempty"` // every register the function uses
+	Declared     []int             `json:"declared,omitempty"`  // Function.Registers, as indices into Registers
+	Vars         []*jsonGlobal     `json:"vars,omitempty"`      // every variable the function declares
+	Locals       []int             `json:"locals,omitempty"`    // Function.Locals, as indices into Vars
+	Directives   []*jsonDirective  `json:"directives,omitempty"`
+	Prototypes   []*jsonPrototype  `json:"prototypes,omitempty"`
+	CallTargets  []*jsonTargetList `json:"call_targets,omitempty"`
+	Attributes   []jsonAttribute   `json:"attributes,omitempty"`
+	Blocks       []*jsonBlock      `json:"blocks,omitempty"`
+	Counters     map[string]int    `json:"counters,omitempty"` // NewReg naming state
+}
+
+type jsonRegister struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type jsonDirective struct {
+	Kind   string `json:"kind"`
+	Values []int  `json:"values,omitempty"`
+	Text   string `json:"text,omitempty"`
+}
+
+type jsonPrototype struct {
+	Label        string       `json:"label"`
+	Params       []*jsonParam `json:"params,omitempty"`
+	ReturnParams []*jsonParam `json:"return_params,omitempty"`
+}
+
+type jsonTargetList struct {
+	Label   string   `json:"label"`
+	Targets []string `json:"targets"`
+}
+
+type jsonBlock struct {
+	Label        string      `json:"label,omitempty"`
+	Comment      string      `json:"comment,omitempty"`
+	Instructions []*jsonInst `json:"instructions"`
+}
+
+type jsonInst struct {
+	Op         string         `json:"op"`
+	Type       string         `json:"type,omitempty"`
+	SrcType    string         `json:"src_type,omitempty"`
+	Space      string         `json:"space,omitempty"`
+	Cmp        string         `json:"cmp,omitempty"`
+	BoolOp     string         `json:"bool_op,omitempty"`
+	Rounding   string         `json:"rounding,omitempty"`
+	Cache      string         `json:"cache,omitempty"`
+	Scope      string         `json:"scope,omitempty"`
+	Vec        string         `json:"vec,omitempty"`
+	Modifiers  []string       `json:"modifiers,omitempty"`
+	Guard      *jsonGuard     `json:"guard,omitempty"`
+	Dst        *jsonOperand   `json:"dst,omitempty"`
+	Dst2       *jsonOperand   `json:"dst2,omitempty"`
+	Src        []*jsonOperand `json:"src,omitempty"`
+	CallTarget string         `json:"call_target,omitempty"`
+	Comment    string         `json:"comment,omitempty"`
+	Loc        *jsonLoc       `json:"loc,omitempty"`
+	Var        *int           `json:"var,omitempty"` // index into the function's Vars
+}
+
+type jsonGuard struct {
+	Reg    int  `json:"reg"`
+	Negate bool `json:"negate,omitempty"`
+}
+
+type jsonLoc struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column,omitempty"`
+}
+
+// jsonOperand is one of: {"kind":"reg","reg":i}, {"kind":"imm","type":t,
+// "value":v}, {"kind":"sym","name":n}, {"kind":"addr","base":o,"offset":n},
+// {"kind":"vec","elems":[...]} or {"kind":"sreg","sreg":"%tid.x"}.
+type jsonOperand struct {
+	Kind   string         `json:"kind"`
+	Reg    int            `json:"reg,omitempty"`
+	Type   string         `json:"type,omitempty"`
+	Value  string         `json:"value,omitempty"`
+	Name   string         `json:"name,omitempty"`
+	Base   *jsonOperand   `json:"base,omitempty"`
+	Offset int64          `json:"offset,omitempty"`
+	Elems  []*jsonOperand `json:"elems,omitempty"`
+	SReg   string         `json:"sreg,omitempty"`
+}
+
+// --- Enum names ---
+
+// enumNames maps the values of one enum to the names used in JSON.
+type enumNames[T ~int] struct {
+	kind   string
+	names  map[T]string
+	values map[string]T
+}
+
+// enumLimit bounds the values scanned when building an enum's name table.
+const enumLimit = 4096
+
+// newEnumNames returns a lazily built name table for an enum whose values
+// run from 0 upwards. Values spelled like an out-of-range value are taken
+// as unused, except 0. Values in pinned get the name given there; other
+// values sharing a spelling get a "#n" suffix, which depends on their
+// order, so every such value belongs in pinned.
+func newEnumNames[T ~int](kind string, str func(T) string, pinned map[T]string) func() *enumNames[T] {
+	return sync.OnceValue(func() *enumNames[T] {
+		t := &enumNames[T]{kind: kind, names: make(map[T]string), values: make(map[string]T)}
+		for v, s := range pinned {
+			t.names[v] = s
+			t.values[s] = v
+		}
+		unknown := str(-1)
+		for v := T(0); v < enumLimit; v++ {
+			s := str(v)
+			if _, ok := t.names[v]; ok || (v != 0 && (s == unknown || s == "")) {
+				continue
+			}
+			name := s
+			for n := 2; ; n++ {
+				if _, taken := t.values[name]; !taken {
+					break
+				}
+				name = s + "#" + strconv.Itoa(n)
+			}
+			t.names[v] = name
+			t.values[name] = v
+		}
+		return t
+	})
+}
+
+// modifierSpellings names the modifiers that share a PTX spelling, so the
+// names stored for them do not depend on the order of the constants.
+var modifierSpellings = map[ptx.Modifier]string{
+	ptx.ModShflDown:   ".down",
+	ptx.ModShiftDown:  ".down#2",
+	ptx.ModAtomMin:    ".min",
+	ptx.ModRedMin:     ".min#2",
+	ptx.ModAtomMax:    ".max",
+	ptx.ModRedMax:     ".max#2",
+	ptx.ModAtomInc:    ".inc",
+	ptx.ModInc:        ".inc#2",
+	ptx.ModAtomDec:    ".dec",
+	ptx.ModDec:        ".dec#2",
+	ptx.ModAtomCAS:    ".cas",
+	ptx.ModCas:        ".cas#2",
+	ptx.ModAtomExch:   ".exch",
+	ptx.ModExch:       ".exch#2",
+	ptx.ModClamp:      ".clamp",
+	ptx.ModClampClamp: ".clamp#2",
+	ptx.ModRight:      ".r",
+	ptx.ModCompR:      ".r#2",
+	ptx.ModDim1D:      ".1d",
+	ptx.ModGeom1D:     ".1d#2",
+	ptx.ModDim2D:      ".2d",
+	ptx.ModGeom2D:     ".2d#2",
+	ptx.ModDim3D:      ".3d",
+	ptx.ModGeom3D:     ".3d#2",
+	ptx.ModCompB:      ".b",
+	ptx.ModB:          ".b#2",
+	ptx.ModMatrixB:    ".b#3",
+	ptx.ModCompA:      ".a",
+	ptx.ModMatrixA:    ".a#2",
+	ptx.ModTypeB1:     ".b1",
+	ptx.ModB1:         ".b1#2",
+}
+
+var (
+	opcodeNames        = newEnumNames("opcode", ptx.Opcode.String, nil)
+	typeNames          = newEnumNames("type", ptx.Type.String, nil)
+	spaceNames         = newEnumNames("state space", ptx.StateSpace.String, nil)
+	cmpNames           = newEnumNames("comparison", ptx.CmpOp.String, nil)
+	boolOpNames        = newEnumNames("boolean operator", ptx.BoolOp.String, nil)
+	roundingNames      = newEnumNames("rounding mode", ptx.RoundingMode.String, nil)
+	cacheNames         = newEnumNames("cache operator", ptx.CacheOp.String, nil)
+	scopeNames         = newEnumNames("scope", ptx.Scope.String, nil)
+	vecNames           = newEnumNames("vector size", ptx.VectorSize.String, nil)
+	modifierNames      = newEnumNames("modifier", ptx.Modifier.String, modifierSpellings)
+	linkageNames       = newEnumNames("linkage", ptx.Linkage.String, nil)
+	targetNames        = newEnumNames("target", ptx.Target.String, nil)
+	specialRegNames    = newEnumNames("special register", ptx.SpecialReg.String, nil)
+	directiveKindNames = newEnumNames("directive", DirectiveKind.String, nil)
+)
+
+// --- Encoding ---
+
+type encoder struct {
+	err error
+
+	// per function
+	regs  map[*Register]int
+	table []jsonRegister
+	vars  map[*Global]int
+	jvars []*jsonGlobal
+}
+
+func (e *encoder) fail(format string, args ...interface{}) {
+	if e.err == nil {
+		e.err = fmt.Errorf("builder: "+format, args...)
+	}
+}
+
+// name returns the JSON name of v.
+func name[T ~int](e *encoder, t func() *enumNames[T], v T) string {
+	names := t()
+	s, ok := names.names[v]
+	if !ok {
+		e.fail("%s %d has no name", names.kind, int(v))
+	}
+	return s
+}
+
+// optName is name, but "" for the zero value.
+func optName[T ~int](e *encoder, t func() *enumNames[T], v T) string {
+	if v == 0 {
+		return ""
+	}
+	return name(e, t, v)
+}
+
+func encodeModule(m *Module) (*jsonModule, error) {
+	e := &encoder{}
+	jm := &jsonModule{
+		Format:           irFormat,
+		Version:          m.Version.String(),
+		Target:           name(e, targetNames, m.Target),
+		AddressSize:      m.AddressSize,
+		Files:            m.Files,
+		CaptureLocations: m.CaptureLocations,
+	}
+	for _, g := range m.Globals {
+		jm.Globals = append(jm.Globals, e.global(g))
+	}
+	for _, f := range m.Functions {
+		jm.Functions = append(jm.Functions, e.function(f))
+	}
+	return jm, e.err
+}
+
+func (e *encoder) global(g *Global) *jsonGlobal {
+	if g == nil {
+		return nil
+	}
+	return &jsonGlobal{
+		Name:        g.Name,
+		Space:       name(e, spaceNames, g.Space),
+		Type:        name(e, typeNames, g.Typ),
+		Vec:         optName(e, vecNames, g.Vec),
+		Count:       g.Count,
+		Align:       g.Align,
+		Linkage:     optName(e, linkageNames, g.Linkage),
+		Initializer: e.values(g.Initializer),
+		Attributes:  e.attributes(g.Attributes),
+	}
+}
+
+func (e *encoder) attributes(attrs []VarAttribute) []jsonAttribute {
+	var out []jsonAttribute
+	for _, a := range attrs {
+		out = append(out, jsonAttribute{Name: a.Name, Params: e.values(a.Params)})
+	}
+	return out
+}
+
+func (e *encoder) values(vals []interface{}) []jsonValue {
+	var out []jsonValue
+	for _, v := range vals {
+		out = append(out, e.value(v))
+	}
+	return out
+}
+
+func (e *encoder) value(v interface{}) jsonValue {
+	switch v := v.(type) {
+	case int:
+		return jsonValue{"int", strconv.FormatInt(int64(v), 10)}
+	case int8:
+		return jsonValue{"int8", strconv.FormatInt(int64(v), 10)}
+	case int16:
+		return jsonValue{"int16", strconv.FormatInt(int64(v), 10)}
+	case int32:
+		return jsonValue{"int32", strconv.FormatInt(int64(v), 10)}
+	case int64:
+		return jsonValue{"int64", strconv.FormatInt(v, 10)}
+	case uint:
+		return jsonValue{"uint", strconv.FormatUint(uint64(v), 10)}
+	case uint8:
+		return jsonValue{"uint8", strconv.FormatUint(uint64(v), 10)}
+	case uint16:
+		return jsonValue{"uint16", strconv.FormatUint(uint64(v), 10)}
+	case uint32:
+		return jsonValue{"uint32", strconv.FormatUint(uint64(v), 10)}
+	case uint64:
+		return jsonValue{"uint64", strconv.FormatUint(v, 10)}
+	case float32:
+		return jsonValue{"float32", strconv.FormatFloat(float64(v), 'g', -1, 32)}
+	case float64:
+		return jsonValue{"float64", strconv.FormatFloat(v, 'g', -1, 64)}
+	case bool:
+		return jsonValue{"bool", strconv.FormatBool(v)}
+	case string:
+		return jsonValue{"string", v}
+	}
+	e.fail("cannot encode value of type %T", v)
+	return jsonValue{}
+}
+
+func (e *encoder) params(params []*Param) []*jsonParam {
+	var out []*jsonParam
+	for _, p := range params {
+		var jp *jsonParam
+		if p != nil {
+			jp = &jsonParam{
+				Name:     p.Name,
+				Type:     name(e, typeNames, p.Typ),
+				Size:     p.Size,
+				Align:    p.Align,
+				Pointer:  p.IsPointer,
+				PtrSpace: optName(e, spaceNames, p.PtrSpace),
+			}
+		}
+		out = append(out, jp)
+	}
+	return out
+}
+
+func (e *encoder) function(f *Function) *jsonFunction {
+	if f == nil {
+		return nil
+	}
+	e.regs, e.table = make(map[*Register]int), nil
+	e.vars, e.jvars = make(map[*Global]int), nil
+
+	jf := &jsonFunction{
+		Name:         f.Name,
+		Kernel:       f.IsKernel,
+		Decl:         f.IsDecl,
+		Linkage:      optName(e, linkageNames, f.Linkage),
+		Comment:      f.Annotation,
+		Params:       e.params(f.Params),
+		ReturnParams: e.params(f.ReturnParams),
+		Attributes:   e.attributes(f.Attributes),
+		Counters:     f.regCounter,
+	}
+	for _, r := range f.Registers {
+		jf.Declared = append(jf.Declared, e.reg(r))
+	}
+	for _, v := range f.Locals {
+		jf.Locals = append(jf.Locals, e.variable(v))
+	}
+	for _, d := range f.Directives {
+		var jd *jsonDirective
+		if d != nil {
+			jd = &jsonDirective{Kind: name(e, directiveKindNames, d.Kind), Values: d.Values, Text: d.Text}
+		}
+		jf.Directives = append(jf.Directives, jd)
+	}
+	for _, p := range f.Prototypes {
+		var jp *jsonPrototype
+		if p != nil {
+			jp = &jsonPrototype{Label: p.Label, Params: e.params(p.Params), ReturnParams: e.params(p.ReturnParams)}
+		}
+		jf.Prototypes = append(jf.Prototypes, jp)
+	}
+	for _, l := range f.CallTargetLists {
+		var jl *jsonTargetList
+		if l != nil {
+			jl = &jsonTargetList{Label: l.Label, Targets: l.Targets}
+		}
+		jf.CallTargets = append(jf.CallTargets, jl)
+	}
+	for _, bb := range f.Blocks {
+		var jb *jsonBlock
+		if bb != nil {
+			jb = &jsonBlock{Label: bb.Label, Comment: bb.Annotation, Instructions: []*jsonInst{}}
+			for _, inst := range bb.Instructions {
+				jb.Instructions = append(jb.Instructions, e.inst(inst))
+			}
+		}
+		jf.Blocks = append(jf.Blocks, jb)
+	}
+	jf.Registers = e.table
+	jf.Vars = e.jvars
+	return jf
+}
+
+// reg returns the index of r in the function's register table, adding it
+// on first use. A nil register is encoded as -1.
+func (e *encoder) reg(r *Register) int {
+	if r == nil {
+		return -1
+	}
+	i, ok := e.regs[r]
+	if !ok {
+		i = len(e.table)
+		e.regs[r] = i
+		e.table = append(e.table, jsonRegister{Name: r.Name, Type: name(e, typeNames, r.Typ)})
+	}
+	return i
+}
+
+// variable returns the index of v in the function's variable table, adding
+// it on first use. A nil variable is encoded as -1.
+func (e *encoder) variable(v *Global) int {
+	if v == nil {
+		return -1
+	}
+	i, ok := e.vars[v]
+	if !ok {
+		i = len(e.jvars)
+		e.vars[v] = i
+		e.jvars = append(e.jvars, e.global(v))
+	}
+	return i
+}
+
+func (e *encoder) inst(inst *Instruction) *jsonInst {
+	if inst == nil {
+		return nil
+	}
+	ji := &jsonInst{
+		Op:         name(e, opcodeNames, inst.Op),
+		Type:       optName(e, typeNames, inst.Typ),
+		SrcType:    optName(e, typeNames, inst.SrcType),
+		Space:      optName(e, spaceNames, inst.Space),
+		Cmp:        optName(e, cmpNames, inst.Cmp),
+		BoolOp:     optName(e, boolOpNames, inst.BoolOp),
+		Rounding:   optName(e, roundingNames, inst.Rounding),
+		Cache:      optName(e, cacheNames, inst.Cache),
+		Scope:      optName(e, scopeNames, inst.Scope),
+		Vec:        optName(e, vecNames, inst.Vec),
+		Dst:        e.operand(inst.Dst),
+		Dst2:       e.operand(inst.Dst2),
+		CallTarget: inst.CallTarget,
+		Comment:    inst.Annotation,
+	}
+	for _, m := range inst.Modifiers {
+		ji.Modifiers = append(ji.Modifiers, name(e, modifierNames, m))
+	}
+	for _, op := range inst.Src {
+		ji.Src = append(ji.Src, e.operand(op))
+	}
+	if inst.Guard != nil {
+		ji.Guard = &jsonGuard{Reg: e.reg(inst.Guard.Reg), Negate: inst.Guard.Negate}
+	}
+	if inst.Loc != nil {
+		ji.Loc = &jsonLoc{File: inst.Loc.File, Line: inst.Loc.Line, Column: inst.Loc.Column}
+	}
+	if inst.Var != nil {
+		i := e.variable(inst.Var)
+		ji.Var = &i
+	}
+	return ji
+}
+
+func (e *encoder) operand(op Operand) *jsonOperand {
+	switch o := op.(type) {
+	case *Register:
+		if o != nil {
+			return &jsonOperand{Kind: "reg", Reg: e.reg(o)}
+		}
+	case *Immediate:
+		if o != nil {
+			v := e.value(o.Value)
+			return &jsonOperand{Kind: "imm", Type: v.Type, Value: v.Value}
+		}
+	case *Symbol:
+		if o != nil {
+			return &jsonOperand{Kind: "sym", Name: o.Name}
+		}
+	case *Address:
+		if o != nil {
+			return &jsonOperand{Kind: "addr", Base: e.operand(o.Base), Offset: o.Offset}
+		}
+	case *VectorOp:
+		if o != nil {
+			jo := &jsonOperand{Kind: "vec", Elems: []*jsonOperand{}}
+			for _, el := range o.Elements {
+				jo.Elems = append(jo.Elems, e.operand(el))
+			}
+			return jo
+		}
+	case *SpecialRegOp:
+		if o != nil {
+			return &jsonOperand{Kind: "sreg", SReg: name(e, specialRegNames, o.Reg)}
+		}
+	}
+	return nil
+}
+
+// --- Decoding ---
+
+type decoder struct {
+	err error
+
+	// per function
+	regs []*Register
+	vars []*Global
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("builder: "+format, args...)
+	}
+}
+
+// value returns the enum value named s; "" is the zero value.
+func value[T ~int](d *decoder, t func() *enumNames[T], s string) T {
+	if s == "" {
+		return 0
+	}
+	names := t()
+	v, ok := names.values[s]
+	if !ok {
+		d.fail("unknown %s %q", names.kind, s)
+	}
+	return v
+}
+
+func decodeModule(jm *jsonModule) (*Module, error) {
+	d := &decoder{}
+	m := &Module{
+		Target:           value(d, targetNames, jm.Target),
+		AddressSize:      jm.AddressSize,
+		Files:            jm.Files,
+		CaptureLocations: jm.CaptureLocations,
+	}
+	major, minor, ok := strings.Cut(jm.Version, ".")
+	var err1, err2 error
+	m.Version.Major, err1 = strconv.Atoi(major)
+	m.Version.Minor, err2 = strconv.Atoi(minor)
+	if !ok || err1 != nil || err2 != nil {
+		d.fail("bad PTX version %q", jm.Version)
+	}
+	for _, jg := range jm.Globals {
+		m.Globals = append(m.Globals, d.global(jg))
+	}
+	for _, jf := range jm.Functions {
+		m.Functions = append(m.Functions, d.function(jf))
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return m, nil
+}
+
+func (d *decoder) global(jg *jsonGlobal) *Global {
+	if jg == nil {
+		return nil
+	}
+	return &Global{
+		Name:        jg.Name,
+		Space:       value(d, spaceNames, jg.Space),
+		Typ:         value(d, typeNames, jg.Type),
+		Vec:         value(d, vecNames, jg.Vec),
+		Count:       jg.Count,
+		Align:       jg.Align,
+		Linkage:     value(d, linkageNames, jg.Linkage),
+		Initializer: d.values(jg.Initializer),
+		Attributes:  d.attributes(jg.Attributes),
+	}
+}
+
+func (d *decoder) attributes(attrs []jsonAttribute) []VarAttribute {
+	var out []VarAttribute
+	for _, a := range attrs {
+		out = append(out, VarAttribute{Name: a.Name, Params: d.values(a.Params)})
+	}
+	return out
+}
+
+func (d *decoder) values(vals []jsonValue) []interface{} {
+	var out []interface{}
+	for _, v := range vals {
+		out = append(out, d.value(v))
+	}
+	return out
+}
+
+func (d *decoder) value(v jsonValue) interface{} {
+	var (
+		x   interface{}
+		err error
+	)
+	switch v.Type {
+	case "int":
+		var n int64
+		n, err = strconv.ParseInt(v.Value, 10, strconv.IntSize)
+		x = int(n)
+	case "int8":
+		var n int64
+		n, err = strconv.ParseInt(v.Value, 10, 8)
+		x = int8(n)
+	case "int16":
+		var n int64
+		n, err = strconv.ParseInt(v.Value, 10, 16)
+		x = int16(n)
+	case "int32":
+		var n int64
+		n, err = strconv.ParseInt(v.Value, 10, 32)
+		x = int32(n)
+	case "int64":
+		x, err = strconv.ParseInt(v.Value, 10, 64)
+	case "uint":
+		var n uint64
+		n, err = strconv.ParseUint(v.Value, 10, strconv.IntSize)
+		x = uint(n)
+	case "uint8":
+		var n uint64
+		n, err = strconv.ParseUint(v.Value, 10, 8)
+		x = uint8(n)
+	case "uint16":
+		var n uint64
+		n, err = strconv.ParseUint(v.Value, 10, 16)
+		x = uint16(n)
+	case "uint32":
+		var n uint64
+		n, err = strconv.ParseUint(v.Value, 10, 32)
+		x = uint32(n)
+	case "uint64":
+		x, err = strconv.ParseUint(v.Value, 10, 64)
+	case "float32":
+		var f float64
+		f, err = strconv.ParseFloat(v.Value, 32)
+		x = float32(f)
+	case "float64":
+		x, err = strconv.ParseFloat(v.Value, 64)
+	case "bool":
+		x, err = strconv.ParseBool(v.Value)
+	case "string":
+		x = v.Value
+	default:
+		d.fail("unknown value type %q", v.Type)
+		return nil
+	}
+	if err != nil {
+		d.fail("bad %s value %q", v.Type, v.Value)
+	}
+	return x
+}
+
+func (d *decoder) params(params []*jsonParam) []*Param {
+	var out []*Param
+	for _, jp := range params {
+		var p *Param
+		if jp != nil {
+			p = &Param{
+				Name:      jp.Name,
+				Typ:       value(d, typeNames, jp.Type),
+				Size:      jp.Size,
+				Align:     jp.Align,
+				IsPointer: jp.Pointer,
+				PtrSpace:  value(d, spaceNames, jp.PtrSpace),
+			}
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func (d *decoder) function(jf *jsonFunction) *Function {
+	if jf == nil {
+		return nil
+	}
+	d.regs = make([]*Register, len(jf.Registers))
+	for i, jr := range jf.Registers {
+		d.regs[i] = &Register{Name: jr.Name, Typ: value(d, typeNames, jr.Type)}
+	}
+	d.vars = make([]*Global, len(jf.Vars))
+	for i, jv := range jf.Vars {
+		d.vars[i] = d.global(jv)
+	}
+
+	f := &Function{
+		Name:         jf.Name,
+		IsKernel:     jf.Kernel,
+		IsDecl:       jf.Decl,
+		Linkage:      value(d, linkageNames, jf.Linkage),
+		Annotation:   jf.Comment,
+		Params:       d.params(jf.Params),
+		ReturnParams: d.params(jf.ReturnParams),
+		Attributes:   d.attributes(jf.Attributes),
+		regCounter:   jf.Counters,
+	}
+	for _, i := range jf.Declared {
+		f.Registers = append(f.Registers, d.reg(i))
+	}
+	for _, i := range jf.Locals {
+		f.Locals = append(f.Locals, d.variable(i))
+	}
+	for _, jd := range jf.Directives {
+		var dir *Directive
+		if jd != nil {
+			dir = &Directive{Kind: value(d, directiveKindNames, jd.Kind), Values: jd.Values, Text: jd.Text}
+		}
+		f.Directives = append(f.Directives, dir)
+	}
+	for _, jp := range jf.Prototypes {
+		var p *CallPrototype
+		if jp != nil {
+			p = &CallPrototype{Label: jp.Label, Params: d.params(jp.Params), ReturnParams: d.params(jp.ReturnParams)}
+		}
+		f.Prototypes = append(f.Prototypes, p)
+	}
+	for _, jl := range jf.CallTargets {
+		var l *CallTargetList
+		if jl != nil {
+			l = &CallTargetList{Label: jl.Label, Targets: jl.Targets}
+		}
+		f.CallTargetLists = append(f.CallTargetLists, l)
+	}
+	for _, jb := range jf.Blocks {
+		var bb *BasicBlock
+		if jb != nil {
+			bb = &BasicBlock{Label: jb.Label, Annotation: jb.Comment, fn: f}
+			for _, ji := range jb.Instructions {
+				bb.Instructions = append(bb.Instructions, d.inst(ji))
+			}
+		}
+		f.Blocks = append(f.Blocks, bb)
+	}
+	return f
+}
+
+func (d *decoder) reg(i int) *Register {
+	if i == -1 {
+		return nil
+	}
+	if i < 0 || i >= len(d.regs) {
+		d.fail("register index %d out of range", i)
+		return nil
+	}
+	return d.regs[i]
+}
+
+func (d *decoder) variable(i int) *Global {
+	if i == -1 {
+		return nil
+	}
+	if i < 0 || i >= len(d.vars) {
+		d.fail("variable index %d out of range", i)
+		return nil
+	}
+	return d.vars[i]
+}
+
+func (d *decoder) inst(ji *jsonInst) *Instruction {
+	if ji == nil {
+		return nil
+	}
+	inst := &Instruction{
+		Op:         value(d, opcodeNames, ji.Op),
+		Typ:        value(d, typeNames, ji.Type),
+		SrcType:    value(d, typeNames, ji.SrcType),
+		Space:      value(d, spaceNames, ji.Space),
+		Cmp:        value(d, cmpNames, ji.Cmp),
+		BoolOp:     value(d, boolOpNames, ji.BoolOp),
+		Rounding:   value(d, roundingNames, ji.Rounding),
+		Cache:      value(d, cacheNames, ji.Cache),
+		Scope:      value(d, scopeNames, ji.Scope),
+		Vec:        value(d, vecNames, ji.Vec),
+		Dst:        d.operand(ji.Dst),
+		Dst2:       d.operand(ji.Dst2),
+		CallTarget: ji.CallTarget,
+		Annotation: ji.Comment,
+	}
+	if ji.Op == "" {
+		d.fail("instruction has no opcode")
+	}
+	for _, m := range ji.Modifiers {
+		inst.Modifiers = append(inst.Modifiers, value(d, modifierNames, m))
+	}
+	for _, jo := range ji.Src {
+		inst.Src = append(inst.Src, d.operand(jo))
+	}
+	if ji.Guard != nil {
+		inst.Guard = &Predicate{Reg: d.reg(ji.Guard.Reg), Negate: ji.Guard.Negate}
+	}
+	if ji.Loc != nil {
+		inst.Loc = &SourceLoc{File: ji.Loc.File, Line: ji.Loc.Line, Column: ji.Loc.Column}
+	}
+	if ji.Var != nil {
+		inst.Var = d.variable(*ji.Var)
+	}
+	return inst
+}
+
+func (d *decoder) operand(jo *jsonOperand) Operand {
+	if jo == nil {
+		return nil
+	}
+	switch jo.Kind {
+	case "reg":
+		if r := d.reg(jo.Reg); r != nil {
+			return r
+		}
+	case "imm":
+		return &Immediate{Value: d.value(jsonValue{Type: jo.Type, Value: jo.Value})}
+	case "sym":
+		return &Symbol{Name: jo.Name}
+	case "addr":
+		return &Address{Base: d.operand(jo.Base), Offset: jo.Offset}
+	case "vec":
+		elems := make([]Operand, len(jo.Elems))
+		for i, el := range jo.Elems {
+			elems[i] = d.operand(el)
+		}
+		return &VectorOp{Elements: elems}
+	case "sreg":
+		return &SpecialRegOp{Reg: value(d, specialRegNames, jo.SReg)}
+	default:
+		d.fail("unknown operand kind %q", jo.Kind)
+	}
+	return nil
+}
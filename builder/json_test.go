@@ -0,0 +1,115 @@
+package builder
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// TestModifierNames pins the stored names of modifiers that share a PTX
+// spelling. Changing one breaks loading of saved IR.
+func TestModifierNames(t *testing.T) {
+	want := map[ptx.Modifier]string{
+		ptx.ModShflDown:   ".down",
+		ptx.ModShiftDown:  ".down#2",
+		ptx.ModAtomMin:    ".min",
+		ptx.ModRedMin:     ".min#2",
+		ptx.ModAtomMax:    ".max",
+		ptx.ModRedMax:     ".max#2",
+		ptx.ModAtomInc:    ".inc",
+		ptx.ModInc:        ".inc#2",
+		ptx.ModAtomDec:    ".dec",
+		ptx.ModDec:        ".dec#2",
+		ptx.ModAtomCAS:    ".cas",
+		ptx.ModCas:        ".cas#2",
+		ptx.ModAtomExch:   ".exch",
+		ptx.ModExch:       ".exch#2",
+		ptx.ModClamp:      ".clamp",
+		ptx.ModClampClamp: ".clamp#2",
+		ptx.ModRight:      ".r",
+		ptx.ModCompR:      ".r#2",
+		ptx.ModDim1D:      ".1d",
+		ptx.ModGeom1D:     ".1d#2",
+		ptx.ModDim2D:      ".2d",
+		ptx.ModGeom2D:     ".2d#2",
+		ptx.ModDim3D:      ".3d",
+		ptx.ModGeom3D:     ".3d#2",
+		ptx.ModCompB:      ".b",
+		ptx.ModB:          ".b#2",
+		ptx.ModMatrixB:    ".b#3",
+		ptx.ModCompA:      ".a",
+		ptx.ModMatrixA:    ".a#2",
+		ptx.ModTypeB1:     ".b1",
+		ptx.ModB1:         ".b1#2",
+	}
+	names := modifierNames()
+	for m, s := range want {
+		if got := names.names[m]; got != s {
+			t.Errorf("modifier %d (%s) is stored as %q, want %q", int(m), m, got, s)
+		}
+		if got, ok := names.values[s]; !ok || got != m {
+			t.Errorf("%q loads as modifier %d, want %d", s, int(got), int(m))
+		}
+	}
+}
+
+// TestSharedSpellingsPinned checks that every enum value sharing its
+// spelling with another has a pinned name.
+func TestSharedSpellingsPinned(t *testing.T) {
+	checkShared(t, "opcode", ptx.Opcode.String, nil)
+	checkShared(t, "type", ptx.Type.String, nil)
+	checkShared(t, "state space", ptx.StateSpace.String, nil)
+	checkShared(t, "comparison", ptx.CmpOp.String, nil)
+	checkShared(t, "boolean operator", ptx.BoolOp.String, nil)
+	checkShared(t, "rounding mode", ptx.RoundingMode.String, nil)
+	checkShared(t, "cache operator", ptx.CacheOp.String, nil)
+	checkShared(t, "scope", ptx.Scope.String, nil)
+	checkShared(t, "vector size", ptx.VectorSize.String, nil)
+	checkShared(t, "modifier", ptx.Modifier.String, modifierSpellings)
+	checkShared(t, "linkage", ptx.Linkage.String, nil)
+	checkShared(t, "target", ptx.Target.String, nil)
+	checkShared(t, "special register", ptx.SpecialReg.String, nil)
+	checkShared(t, "directive", DirectiveKind.String, nil)
+}
+
+func checkShared[T ~int](t *testing.T, kind string, str func(T) string, pinned map[T]string) {
+	t.Helper()
+	unknown := str(-1)
+	byName := make(map[string][]T)
+	for v := T(0); v < enumLimit; v++ {
+		if s := str(v); v == 0 || (s != unknown && s != "") {
+			byName[s] = append(byName[s], v)
+		}
+	}
+	for s, vs := range byName {
+		if len(vs) < 2 {
+			continue
+		}
+		for _, v := range vs {
+			if _, ok := pinned[v]; !ok {
+				t.Errorf("%s %d shares the spelling %q but has no pinned name", kind, int(v), s)
+			}
+		}
+	}
+}
+
+func TestModifierRoundTrip(t *testing.T) {
+	mod := NewModule(ptx.ISA80, ptx.SM80)
+	k := mod.NewKernel("k")
+	a := k.NewReg("a", ptx.B32)
+	k.NewBlock("").Add(Mov(a, Imm(0)).Typed(ptx.B32).WithMod(ptx.ModCas, ptx.ModRedMin, ptx.ModMatrixB))
+	data, err := json.Marshal(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Module
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	mods := got.Functions[0].Blocks[0].Instructions[0].Modifiers
+	if want := []ptx.Modifier{ptx.ModCas, ptx.ModRedMin, ptx.ModMatrixB}; !slices.Equal(mods, want) {
+		t.Errorf("modifiers = %v, want %v", mods, want)
+	}
+}
@@ -0,0 +1,251 @@
+package builder
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// StructField is one field of a StructLayout.
+type StructField struct {
+	Name   string
+	Typ    ptx.Type
+	Count  int // array element count (0 = scalar)
+	Offset int // byte offset from the start of the struct
+}
+
+// Size returns the size of the field in bytes.
+func (f StructField) Size() int {
+	if f.Count > 0 {
+		return f.elemSize() * f.Count
+	}
+	return f.elemSize()
+}
+
+func (f StructField) elemSize() int {
+	return f.Typ.BitWidth() / 8
+}
+
+// StructLayout lays out a struct passed to a kernel by value, with the
+// offsets and alignment a C compiler would give it: each field at the next
+// multiple of its element size, and the struct padded to its largest one.
+//
+//	cfg := builder.NewStructLayout().
+//		Add("n", ptx.U32).
+//		Add("scale", ptx.F32).
+//		AddArray("dims", ptx.U64, 3)
+//	k.AddParam(cfg.Param("cfg"))             // .param .align 8 .b8 cfg[32]
+//	bb.Add(cfg.Load(n, k.Param("cfg"), "n")) // ld.param.u32 n, [cfg]
+type StructLayout struct {
+	Fields []StructField
+	Size   int // total size in bytes, including tail padding
+	Align  int // alignment in bytes
+
+	index map[string]int
+}
+
+// NewStructLayout returns an empty layout.
+func NewStructLayout() *StructLayout {
+	return &StructLayout{Align: 1, index: make(map[string]int)}
+}
+
+// Add appends a scalar field.
+func (s *StructLayout) Add(name string, typ ptx.Type) *StructLayout {
+	return s.AddArray(name, typ, 0)
+}
+
+// AddArray appends an array field of count elements.
+// It panics if typ has no byte size (such as .pred) or the name is taken.
+func (s *StructLayout) AddArray(name string, typ ptx.Type, count int) *StructLayout {
+	end := 0
+	if n := len(s.Fields); n > 0 {
+		last := s.Fields[n-1]
+		end = last.Offset + last.Size()
+	}
+	f := StructField{Name: name, Typ: typ, Count: count}
+	f.Offset = alignTo(end, max(f.elemSize(), 1))
+	if err := s.place(f); err != nil {
+		panic(err.Error())
+	}
+	return s
+}
+
+// place adds f at its offset and grows the struct to cover it.
+func (s *StructLayout) place(f StructField) error {
+	align := f.elemSize()
+	if align == 0 {
+		return fmt.Errorf("builder: struct field %s has type %s with no byte size", f.Name, f.Typ)
+	}
+	if f.Offset%align != 0 {
+		return fmt.Errorf("builder: struct field %s at offset %d is not aligned to its size", f.Name, f.Offset)
+	}
+	if _, ok := s.index[f.Name]; ok {
+		return fmt.Errorf("builder: struct field %s declared twice", f.Name)
+	}
+	if s.index == nil {
+		s.index = make(map[string]int)
+	}
+	if align > s.Align {
+		s.Align = align
+	}
+	s.index[f.Name] = len(s.Fields)
+	s.Fields = append(s.Fields, f)
+	s.Size = max(s.Size, alignTo(f.Offset+f.Size(), s.Align))
+	return nil
+}
+
+// StructLayoutOf derives a layout from a Go struct type, given as a value
+// or pointer, such as the host-side struct the kernel argument is copied
+// from. Integer, float and bool fields map to the PTX type of the same
+// width (.u8 for bool, .u64 for uintptr and pointers); arrays become array
+// fields. Nested structs are flattened with dotted names ("inner.x"), and
+// arrays of structs with indexed ones ("items[1].x"). Blank (_) fields are
+// left out as padding. Strings, slices, maps, interfaces and other
+// reference types are rejected, as are structs with no fields to load.
+func StructLayoutOf(v interface{}) (*StructLayout, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("builder: StructLayoutOf needs a struct, got %v", t)
+	}
+	s := NewStructLayout()
+	if err := s.addStruct("", t, 0); err != nil {
+		return nil, err
+	}
+	if len(s.Fields) == 0 {
+		return nil, fmt.Errorf("builder: StructLayoutOf: %v has no fields", t)
+	}
+	s.Size = alignTo(int(t.Size()), s.Align)
+	return s, nil
+}
+
+func (s *StructLayout) addStruct(prefix string, t reflect.Type, base int) error {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Name == "_" {
+			continue
+		}
+		if err := s.addGo(prefix+sf.Name, sf.Type, base+int(sf.Offset)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StructLayout) addGo(name string, t reflect.Type, offset int) error {
+	switch t.Kind() {
+	case reflect.Struct:
+		return s.addStruct(name+".", t, offset)
+	case reflect.Array:
+		elem := t.Elem()
+		if elem.Kind() == reflect.Struct || elem.Kind() == reflect.Array {
+			for i := 0; i < t.Len(); i++ {
+				if err := s.addGo(fmt.Sprintf("%s[%d]", name, i), elem, offset+i*int(elem.Size())); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		typ, ok := goScalarType(elem)
+		if !ok {
+			return fmt.Errorf("builder: struct field %s has unsupported element type %v", name, elem)
+		}
+		if t.Len() == 0 {
+			return nil
+		}
+		return s.placeGo(StructField{Name: name, Typ: typ, Count: t.Len(), Offset: offset})
+	default:
+		typ, ok := goScalarType(t)
+		if !ok {
+			return fmt.Errorf("builder: struct field %s has unsupported type %v", name, t)
+		}
+		return s.placeGo(StructField{Name: name, Typ: typ, Offset: offset})
+	}
+}
+
+// placeGo adds a field at the offset Go gave it. Go and C lay out these
+// types alike on 64-bit platforms; elsewhere Go may align 8-byte fields to
+// 4, which the device would not accept and place reports.
+func (s *StructLayout) placeGo(f StructField) error {
+	return s.place(f)
+}
+
+func goScalarType(t reflect.Type) (ptx.Type, bool) {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Uint8:
+		return ptx.U8, true
+	case reflect.Int8:
+		return ptx.S8, true
+	case reflect.Int16:
+		return ptx.S16, true
+	case reflect.Uint16:
+		return ptx.U16, true
+	case reflect.Int32:
+		return ptx.S32, true
+	case reflect.Uint32:
+		return ptx.U32, true
+	case reflect.Int64:
+		return ptx.S64, true
+	case reflect.Uint64, reflect.Uintptr, reflect.Pointer, reflect.UnsafePointer:
+		return ptx.U64, true
+	case reflect.Int:
+		return bitsInt(t.Size(), ptx.S32, ptx.S64), true
+	case reflect.Uint:
+		return bitsInt(t.Size(), ptx.U32, ptx.U64), true
+	case reflect.Float32:
+		return ptx.F32, true
+	case reflect.Float64:
+		return ptx.F64, true
+	}
+	return 0, false
+}
+
+func bitsInt(size uintptr, t32, t64 ptx.Type) ptx.Type {
+	if size == 4 {
+		return t32
+	}
+	return t64
+}
+
+func alignTo(n, align int) int {
+	return (n + align - 1) / align * align
+}
+
+// Field returns the field with the given name.
+func (s *StructLayout) Field(name string) (StructField, bool) {
+	i, ok := s.index[name]
+	if !ok {
+		return StructField{}, false
+	}
+	return s.Fields[i], true
+}
+
+// Param creates the .param .align A .b8 name[Size] parameter holding the
+// struct.
+func (s *StructLayout) Param(name string) *Param {
+	return NewByteArrayParam(name, s.Size, s.Align)
+}
+
+// Load creates ld.param.<type> dst, [param+offset] for a scalar field, or
+// the first element of an array field.
+// It panics if there is no such field.
+func (s *StructLayout) Load(dst Operand, param *Symbol, field string) *Instruction {
+	return s.LoadElem(dst, param, field, 0)
+}
+
+// LoadElem creates the ld.param for element i of an array field.
+// It panics if there is no such field or i is out of range.
+func (s *StructLayout) LoadElem(dst Operand, param *Symbol, field string, i int) *Instruction {
+	f, ok := s.Field(field)
+	if !ok {
+		panic(fmt.Sprintf("builder: struct has no field %s", field))
+	}
+	if i < 0 || (f.Count == 0 && i > 0) || (f.Count > 0 && i >= f.Count) {
+		panic(fmt.Sprintf("builder: index %d out of range for struct field %s", i, field))
+	}
+	off := f.Offset + i*f.elemSize()
+	return &Instruction{Op: ptx.OpLd, Space: ptx.Param, Typ: f.Typ, Dst: dst, Src: []Operand{&Address{Base: param, Offset: int64(off)}}}
+}
@@ -0,0 +1,138 @@
+package builder_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+func checkFields(t *testing.T, s *builder.StructLayout, want []builder.StructField, size, align int) {
+	t.Helper()
+	if len(s.Fields) != len(want) {
+		t.Fatalf("fields %+v, want %+v", s.Fields, want)
+	}
+	for i, f := range s.Fields {
+		if f != want[i] {
+			t.Errorf("field %d is %+v, want %+v", i, f, want[i])
+		}
+	}
+	if s.Size != size || s.Align != align {
+		t.Errorf("size %d align %d, want %d and %d", s.Size, s.Align, size, align)
+	}
+}
+
+func TestStructLayoutOffsets(t *testing.T) {
+	s := builder.NewStructLayout().
+		Add("c", ptx.U8).
+		Add("n", ptx.U32).
+		Add("h", ptx.F16).
+		AddArray("dims", ptx.U64, 3).
+		Add("tail", ptx.U8)
+	checkFields(t, s, []builder.StructField{
+		{Name: "c", Typ: ptx.U8, Offset: 0},
+		{Name: "n", Typ: ptx.U32, Offset: 4},
+		{Name: "h", Typ: ptx.F16, Offset: 8},
+		{Name: "dims", Typ: ptx.U64, Count: 3, Offset: 16},
+		{Name: "tail", Typ: ptx.U8, Offset: 40},
+	}, 48, 8)
+
+	p := s.Param("cfg")
+	if p.Size != 48 || p.Align != 8 {
+		t.Errorf("Param is %d bytes aligned to %d, want 48 and 8", p.Size, p.Align)
+	}
+}
+
+func TestStructLayoutOf(t *testing.T) {
+	type inner struct {
+		X int16
+		_ [2]byte
+		Y float32
+	}
+	type params struct {
+		N     uint32
+		_     uint32
+		Ptr   *float32
+		Ok    bool
+		Tile  inner
+		Items [2]inner
+		Grid  [2][2]uint16
+		Empty [0]float64
+		Scale float64
+	}
+	s, err := builder.StructLayoutOf(&params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFields(t, s, []builder.StructField{
+		{Name: "N", Typ: ptx.U32, Offset: 0},
+		{Name: "Ptr", Typ: ptx.U64, Offset: 8},
+		{Name: "Ok", Typ: ptx.U8, Offset: 16},
+		{Name: "Tile.X", Typ: ptx.S16, Offset: 20},
+		{Name: "Tile.Y", Typ: ptx.F32, Offset: 24},
+		{Name: "Items[0].X", Typ: ptx.S16, Offset: 28},
+		{Name: "Items[0].Y", Typ: ptx.F32, Offset: 32},
+		{Name: "Items[1].X", Typ: ptx.S16, Offset: 36},
+		{Name: "Items[1].Y", Typ: ptx.F32, Offset: 40},
+		{Name: "Grid[0]", Typ: ptx.U16, Count: 2, Offset: 44},
+		{Name: "Grid[1]", Typ: ptx.U16, Count: 2, Offset: 48},
+		{Name: "Scale", Typ: ptx.F64, Offset: 56},
+	}, 64, 8)
+}
+
+func TestStructLayoutOfErrors(t *testing.T) {
+	for _, tt := range []struct {
+		v    interface{}
+		want string
+	}{
+		{42, "needs a struct"},
+		{struct{}{}, "has no fields"},
+		{struct{ _, _ int32 }{}, "has no fields"},
+		{struct{ S string }{}, "field S has unsupported type"},
+		{struct{ A [2][1]string }{}, "field A[0] has unsupported element type"},
+		{struct{ M [3]map[int]int }{}, "field M has unsupported element type"},
+	} {
+		_, err := builder.StructLayoutOf(tt.v)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("StructLayoutOf(%T): error %v, want one containing %q", tt.v, err, tt.want)
+		}
+	}
+}
+
+func TestStructLayoutLoadElem(t *testing.T) {
+	s := builder.NewStructLayout().Add("n", ptx.U32).AddArray("dims", ptx.U64, 3)
+	k := builder.NewModule(ptx.ISA80, ptx.SM80).NewKernel("k")
+	k.AddParam(s.Param("cfg"))
+	d := k.NewReg("d", ptx.U64)
+	for _, tt := range []struct {
+		field string
+		i     int
+		typ   ptx.Type
+		off   int64
+	}{
+		{"n", 0, ptx.U32, 0},
+		{"dims", 0, ptx.U64, 8},
+		{"dims", 2, ptx.U64, 24},
+	} {
+		inst := s.LoadElem(d, k.Param("cfg"), tt.field, tt.i)
+		addr, ok := inst.Src[0].(*builder.Address)
+		if inst.Op != ptx.OpLd || inst.Space != ptx.Param || inst.Typ != tt.typ || !ok || addr.Offset != tt.off {
+			t.Errorf("LoadElem(%s, %d) = %+v, want ld.param%s at offset %d", tt.field, tt.i, inst, tt.typ, tt.off)
+		}
+	}
+
+	for _, tt := range []struct {
+		field string
+		i     int
+	}{{"n", 1}, {"dims", 3}, {"dims", -1}, {"missing", 0}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("LoadElem(%s, %d) did not panic", tt.field, tt.i)
+				}
+			}()
+			s.LoadElem(d, k.Param("cfg"), tt.field, tt.i)
+		}()
+	}
+}
@@ -12,6 +12,12 @@ type Module struct {
     AddressSize int             // .address_size 64 (32 or 64)
     Globals     []*Global       // module-scope variables (.global, .const, .shared)
     Functions   []*Function     // .entry and .func definitions
+    Files       []string        // .file entries, numbered from 1
+
+    // CaptureLocations makes BasicBlock.Add record the Go source line that
+    // added each instruction, unless it already has a location. Only blocks
+    // created through NewKernel/NewFunc/AddFunction and NewBlock see it.
+    CaptureLocations bool
 }
 
 // NewModule creates a new PTX module with sensible defaults.
@@ -31,6 +37,7 @@ func (m *Module) AddGlobal(g *Global) *Module {
 
 // AddFunction appends a function or kernel definition.
 func (m *Module) AddFunction(f *Function) *Module {
+    f.mod = m
     m.Functions = append(m.Functions, f)
     return m
 }
@@ -41,16 +48,35 @@ func (m *Module) NewKernel(name string) *Function {
         Name:     name,
         IsKernel: true,
         Linkage:  ptx.LinkVisible,
+        mod:      m,
     }
     m.Functions = append(m.Functions, f)
     return f
 }
 
+// DeclareFunc declares a device function whose body is defined later in
+// the module or elsewhere, and adds the declaration to the module. Add its
+// parameters with AddParam and AddReturnParam.
+func (m *Module) DeclareFunc(name string) *Function {
+    f := m.NewFunc(name)
+    f.IsDecl = true
+    return f
+}
+
+// ExternFunc declares an .extern device function defined in another PTX
+// module or library, such as libdevice, and adds it to the module.
+func (m *Module) ExternFunc(name string) *Function {
+    f := m.DeclareFunc(name)
+    f.Linkage = ptx.LinkExtern
+    return f
+}
+
 // NewFunc creates a new device function (.func) and adds it to the module.
 func (m *Module) NewFunc(name string) *Function {
     f := &Function{
         Name:     name,
         IsKernel: false,
+        mod:      m,
     }
     m.Functions = append(m.Functions, f)
     return f
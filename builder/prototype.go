@@ -0,0 +1,66 @@
+package builder
+
+// CallPrototype is a .callprototype declaration: the signature of the
+// functions an indirect call may reach. It is declared inside the calling
+// function under Label, which CallIndirect names as its proto operand.
+//
+//	proto_0: .callprototype (.reg .f32 _) _ (.reg .f32 _, .reg .u32 _);
+type CallPrototype struct {
+	Label        string
+	Params       []*Param
+	ReturnParams []*Param
+}
+
+// CallTargetList is a .calltargets declaration naming every function an
+// indirect call may reach. CallIndirect can name it in place of a
+// prototype.
+//
+//	targets_0: .calltargets relu, gelu, silu;
+type CallTargetList struct {
+	Label   string
+	Targets []string
+}
+
+// NewCallPrototype declares an empty call prototype in the function.
+func (f *Function) NewCallPrototype(label string) *CallPrototype {
+	p := &CallPrototype{Label: label}
+	f.Prototypes = append(f.Prototypes, p)
+	return p
+}
+
+// NewCallPrototypeOf declares a call prototype with the signature of callee.
+func (f *Function) NewCallPrototypeOf(label string, callee *Function) *CallPrototype {
+	p := f.NewCallPrototype(label)
+	p.Params = append(p.Params, callee.Params...)
+	p.ReturnParams = append(p.ReturnParams, callee.ReturnParams...)
+	return p
+}
+
+// NewCallTargets declares the list of functions an indirect call may reach.
+func (f *Function) NewCallTargets(label string, targets ...string) *CallTargetList {
+	l := &CallTargetList{Label: label, Targets: targets}
+	f.CallTargetLists = append(f.CallTargetLists, l)
+	return l
+}
+
+// AddParam appends an input parameter. Parameter names are not printed.
+func (p *CallPrototype) AddParam(param *Param) *CallPrototype {
+	p.Params = append(p.Params, param)
+	return p
+}
+
+// AddReturnParam appends a return parameter.
+func (p *CallPrototype) AddReturnParam(param *Param) *CallPrototype {
+	p.ReturnParams = append(p.ReturnParams, param)
+	return p
+}
+
+// Ref returns the prototype's label as an operand for CallIndirect.
+func (p *CallPrototype) Ref() *Symbol {
+	return &Symbol{Name: p.Label}
+}
+
+// Ref returns the list's label as an operand for CallIndirect.
+func (l *CallTargetList) Ref() *Symbol {
+	return &Symbol{Name: l.Label}
+}
@@ -0,0 +1,304 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// RenameFunction renames every declaration and definition of the function
+// old, along with the call targets, call target lists, .alias directives
+// and symbol operands (function pointers) that refer to it. References in
+// functions that declare a local name old are left alone.
+func (m *Module) RenameFunction(old, new string) error {
+	if err := m.checkNewName(new); err != nil {
+		return err
+	}
+	found := false
+	for _, f := range m.Functions {
+		if f != nil && f.Name == old {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("builder: no function %s", old)
+	}
+	for _, f := range m.Functions {
+		if f == nil {
+			continue
+		}
+		if f.Name == old {
+			f.Name = new
+		}
+		if f.declares(old) {
+			continue
+		}
+		for _, l := range f.CallTargetLists {
+			for i, t := range l.Targets {
+				if t == old {
+					l.Targets[i] = new
+				}
+			}
+		}
+		for _, d := range f.Directives {
+			if d != nil && d.Kind == DirAlias {
+				d.Text = renameList(d.Text, old, new)
+			}
+		}
+		f.renameRefs(old, new, true)
+	}
+	return nil
+}
+
+// RenameGlobal renames the module-scope variable old and every symbol
+// operand that refers to it. References in functions that declare a local
+// name old are left alone.
+func (m *Module) RenameGlobal(old, new string) error {
+	if err := m.checkNewName(new); err != nil {
+		return err
+	}
+	var g *Global
+	for _, v := range m.Globals {
+		if v != nil && v.Name == old {
+			g = v
+		}
+	}
+	if g == nil {
+		return fmt.Errorf("builder: no global %s", old)
+	}
+	g.Name = new
+	for _, f := range m.Functions {
+		if f != nil && !f.declares(old) {
+			f.renameRefs(old, new, false)
+		}
+	}
+	return nil
+}
+
+// checkNewName reports an error if new is empty or already names a
+// function or global; both share the module's namespace.
+func (m *Module) checkNewName(new string) error {
+	if new == "" {
+		return fmt.Errorf("builder: empty name")
+	}
+	for _, f := range m.Functions {
+		if f != nil && f.Name == new {
+			return fmt.Errorf("builder: function %s already exists", new)
+		}
+	}
+	for _, g := range m.Globals {
+		if g != nil && g.Name == new {
+			return fmt.Errorf("builder: global %s already exists", new)
+		}
+	}
+	return nil
+}
+
+// RenameParam renames the parameter old and every symbol operand in the
+// function that refers to it.
+func (f *Function) RenameParam(old, new string) error {
+	if new == "" {
+		return fmt.Errorf("builder: empty name")
+	}
+	var p *Param
+	for _, q := range append(append([]*Param(nil), f.Params...), f.ReturnParams...) {
+		if q != nil && q.Name == new {
+			return fmt.Errorf("builder: %s already has a parameter %s", f.Name, new)
+		}
+		if q != nil && q.Name == old {
+			p = q
+		}
+	}
+	if p == nil {
+		return fmt.Errorf("builder: %s has no parameter %s", f.Name, old)
+	}
+	p.Name = new
+	f.renameRefs(old, new, false)
+	return nil
+}
+
+// RenameLabel renames the block labelled old and every branch target and
+// symbol operand in the function that refers to it.
+func (f *Function) RenameLabel(old, new string) error {
+	if new == "" {
+		return fmt.Errorf("builder: empty name")
+	}
+	var b *BasicBlock
+	for _, bb := range f.Blocks {
+		if bb != nil && bb.Label == new {
+			return fmt.Errorf("builder: %s already has a label %s", f.Name, new)
+		}
+		if bb != nil && old != "" && bb.Label == old {
+			b = bb
+		}
+	}
+	if b == nil {
+		return fmt.Errorf("builder: %s has no label %s", f.Name, old)
+	}
+	b.Label = new
+	f.renameRefs(old, new, false)
+	return nil
+}
+
+// RenameReg renames the register old, which may be given with or without
+// its "%". Instructions hold the register itself, so they need no update;
+// registers declared in nested scopes are found too.
+func (f *Function) RenameReg(old, new string) error {
+	old, new = regName(old), regName(new)
+	if new == "%" {
+		return fmt.Errorf("builder: empty name")
+	}
+	var r *Register
+	clash := false
+	f.forEachReg(func(q *Register) {
+		if q.Name == new {
+			clash = true
+		}
+		if q.Name == old && r == nil {
+			r = q
+		}
+	})
+	switch {
+	case clash:
+		return fmt.Errorf("builder: %s already has a register %s", f.Name, new)
+	case r == nil:
+		return fmt.Errorf("builder: %s has no register %s", f.Name, old)
+	}
+	r.Name = new
+	return nil
+}
+
+func regName(name string) string {
+	if strings.HasPrefix(name, "%") {
+		return name
+	}
+	return "%" + name
+}
+
+// forEachReg calls fn for the function's registers and those declared in
+// its nested scopes.
+func (f *Function) forEachReg(fn func(*Register)) {
+	for _, r := range f.Registers {
+		if r != nil {
+			fn(r)
+		}
+	}
+	for _, bb := range f.Blocks {
+		if bb == nil {
+			continue
+		}
+		for _, inst := range bb.Instructions {
+			if inst != nil && inst.Op == ptx.OpDecl && inst.Var == nil {
+				if r, ok := inst.Dst.(*Register); ok && r != nil {
+					fn(r)
+				}
+			}
+		}
+	}
+}
+
+// declares reports whether name is declared inside the function, as a
+// parameter, local variable, label or call declaration, so that it hides a
+// module-scope symbol of the same name.
+func (f *Function) declares(name string) bool {
+	for _, p := range append(append([]*Param(nil), f.Params...), f.ReturnParams...) {
+		if p != nil && p.Name == name {
+			return true
+		}
+	}
+	for _, v := range f.Locals {
+		if v != nil && v.Name == name {
+			return true
+		}
+	}
+	for _, p := range f.Prototypes {
+		if p != nil && p.Label == name {
+			return true
+		}
+	}
+	for _, l := range f.CallTargetLists {
+		if l != nil && l.Label == name {
+			return true
+		}
+	}
+	for _, bb := range f.Blocks {
+		if bb == nil {
+			continue
+		}
+		if bb.Label == name {
+			return true
+		}
+		for _, inst := range bb.Instructions {
+			if inst != nil && inst.Var != nil && inst.Var.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renameRefs replaces symbol operands named old, including address bases
+// and vector elements, and also call targets if calls is set. Operands are
+// replaced rather than modified, since they may be shared.
+func (f *Function) renameRefs(old, new string, calls bool) {
+	for _, bb := range f.Blocks {
+		if bb == nil {
+			continue
+		}
+		for _, inst := range bb.Instructions {
+			if inst == nil {
+				continue
+			}
+			if calls && inst.CallTarget == old {
+				inst.CallTarget = new
+			}
+			inst.Dst = renameOperand(inst.Dst, old, new)
+			inst.Dst2 = renameOperand(inst.Dst2, old, new)
+			for i, op := range inst.Src {
+				inst.Src[i] = renameOperand(op, old, new)
+			}
+		}
+	}
+}
+
+// renameOperand returns op, or a copy of it with symbols named old
+// renamed.
+func renameOperand(op Operand, old, new string) Operand {
+	switch o := op.(type) {
+	case *Symbol:
+		if o != nil && o.Name == old {
+			return &Symbol{Name: new}
+		}
+	case *Address:
+		if o != nil {
+			if base := renameOperand(o.Base, old, new); base != o.Base {
+				return &Address{Base: base, Offset: o.Offset}
+			}
+		}
+	case *VectorOp:
+		if o != nil {
+			elems := make([]Operand, len(o.Elements))
+			changed := false
+			for i, el := range o.Elements {
+				elems[i] = renameOperand(el, old, new)
+				changed = changed || elems[i] != el
+			}
+			if changed {
+				return &VectorOp{Elements: elems}
+			}
+		}
+	}
+	return op
+}
+
+// renameList renames old in a comma-separated list of names.
+func renameList(list, old, new string) string {
+	names := strings.Split(list, ",")
+	for i, n := range names {
+		if strings.TrimSpace(n) == old {
+			names[i] = strings.Replace(n, old, new, 1)
+		}
+	}
+	return strings.Join(names, ",")
+}
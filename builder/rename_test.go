@@ -0,0 +1,237 @@
+package builder_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/codegen"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// renameModule has a global tbl, a function f that g calls, takes the
+// address of and lists as a call target, and a function h whose parameter
+// f and local tbl hide the module-scope names.
+func renameModule() *builder.Module {
+	mod := builder.NewModule(ptx.ISA80, ptx.SM80)
+	mod.AddGlobal(builder.NewGlobal("tbl", ptx.Global, ptx.U32))
+	f := mod.NewFunc("f")
+	f.AddParam(builder.NewParam("x", ptx.U32))
+	f.NewBlock("").Add(builder.Ret())
+
+	g := mod.NewFunc("g")
+	g.AddParam(builder.NewParam("n", ptx.U32))
+	g.NewCallTargets("targets", "f")
+	g.AddDirective(builder.Alias("f2", "f"))
+	r := g.NewReg("r", ptx.U64)
+	v := g.NewReg("v", ptx.U32)
+	p := g.NewReg("p", ptx.Pred)
+	bb := g.NewBlock("")
+	bb.Add(builder.Ld(v, g.Param("n")).Typed(ptx.U32).InSpace(ptx.Param))
+	bb.Add(builder.Mov(r, builder.Sym("f")).Typed(ptx.U64))
+	bb.Add(builder.Ld(v, builder.Addr(builder.Sym("tbl"), 4)).Typed(ptx.U32).InSpace(ptx.Global))
+	bb.Add(builder.Bra("done").PredNot(p))
+	bb.Add(builder.Call("f", nil, []builder.Operand{v}))
+	g.NewBlock("done").Add(builder.Ret())
+
+	h := mod.NewFunc("h")
+	h.AddParam(builder.NewParam("f", ptx.U64))
+	h.NewLocal("tbl", ptx.Local, ptx.U32)
+	w := h.NewReg("w", ptx.U64)
+	bb = h.NewBlock("")
+	bb.Add(builder.Ld(w, h.Param("f")).Typed(ptx.U64).InSpace(ptx.Param))
+	bb.Add(builder.Mov(w, builder.Sym("tbl")).Typed(ptx.U64))
+	bb.Add(builder.Ret())
+	return mod
+}
+
+// emitFlat emits mod with runs of white space collapsed.
+func emitFlat(mod *builder.Module) string {
+	return strings.Join(strings.Fields(codegen.Emit(mod)), " ")
+}
+
+func TestRenameFunction(t *testing.T) {
+	mod := renameModule()
+	if err := mod.RenameFunction("f", "f_new"); err != nil {
+		t.Fatal(err)
+	}
+	src := emitFlat(mod)
+	for _, want := range []string{
+		".func f_new(",
+		"targets: .calltargets f_new;",
+		".alias f2, f_new",
+		"mov.u64 %r, f_new;",
+		"call f_new, (%v);",
+		"ld.param.u64 %w, f;", // h's parameter f is not the function
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("output does not contain %q:\n%s", want, src)
+		}
+	}
+
+	for _, tt := range []struct{ old, new, want string }{
+		{"missing", "m", "no function missing"},
+		{"f_new", "g", "function g already exists"},
+		{"f_new", "tbl", "global tbl already exists"},
+		{"f_new", "", "empty name"},
+	} {
+		if err := mod.RenameFunction(tt.old, tt.new); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("RenameFunction(%q, %q): error %v, want %q", tt.old, tt.new, err, tt.want)
+		}
+	}
+}
+
+func TestRenameGlobal(t *testing.T) {
+	mod := renameModule()
+	if err := mod.RenameGlobal("tbl", "table"); err != nil {
+		t.Fatal(err)
+	}
+	src := emitFlat(mod)
+	for _, want := range []string{
+		".global .u32 table;",
+		"ld.global.u32 %v, [table+4];",
+		"mov.u64 %w, tbl;", // h's local tbl is not the global
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("output does not contain %q:\n%s", want, src)
+		}
+	}
+	for _, tt := range []struct{ old, new, want string }{
+		{"missing", "m", "no global missing"},
+		{"table", "f", "function f already exists"},
+		{"table", "", "empty name"},
+	} {
+		if err := mod.RenameGlobal(tt.old, tt.new); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("RenameGlobal(%q, %q): error %v, want %q", tt.old, tt.new, err, tt.want)
+		}
+	}
+}
+
+func TestRenameParam(t *testing.T) {
+	mod := renameModule()
+	g := mod.Functions[1]
+	g.AddReturnParam(builder.NewParam("ret", ptx.U32))
+	if err := g.RenameParam("n", "count"); err != nil {
+		t.Fatal(err)
+	}
+	if src := emitFlat(mod); !strings.Contains(src, ".reg .u32 count )") || !strings.Contains(src, "ld.param.u32 %v, count;") {
+		t.Errorf("parameter n not renamed everywhere:\n%s", src)
+	}
+	for _, tt := range []struct{ old, new, want string }{
+		{"n", "m", "has no parameter n"},
+		{"count", "ret", "already has a parameter ret"},
+		{"count", "", "empty name"},
+	} {
+		if err := g.RenameParam(tt.old, tt.new); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("RenameParam(%q, %q): error %v, want %q", tt.old, tt.new, err, tt.want)
+		}
+	}
+}
+
+func TestRenameLabel(t *testing.T) {
+	mod := renameModule()
+	g := mod.Functions[1]
+	if err := g.RenameLabel("done", "exit"); err != nil {
+		t.Fatal(err)
+	}
+	if src := emitFlat(mod); !strings.Contains(src, "@!%p bra exit;") || !strings.Contains(src, "exit: ret;") {
+		t.Errorf("label done not renamed everywhere:\n%s", src)
+	}
+	g.NewBlock("other").Add(builder.Ret())
+	for _, tt := range []struct{ old, new, want string }{
+		{"done", "x", "has no label done"},
+		{"", "x", "has no label"},
+		{"exit", "other", "already has a label other"},
+		{"exit", "", "empty name"},
+	} {
+		if err := g.RenameLabel(tt.old, tt.new); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("RenameLabel(%q, %q): error %v, want %q", tt.old, tt.new, err, tt.want)
+		}
+	}
+}
+
+func TestRenameReg(t *testing.T) {
+	mod := builder.NewModule(ptx.ISA80, ptx.SM80)
+	k := mod.NewKernel("k")
+	a := k.NewReg("a", ptx.U32)
+	bb := k.NewBlock("")
+	scope := bb.OpenScope()
+	s := scope.NewReg("s", ptx.U32)
+	bb.Add(builder.Add(a, a, s).Typed(ptx.U32))
+	scope.Close()
+	bb.Add(builder.Ret())
+
+	if err := k.RenameReg("a", "%acc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.RenameReg("%s", "tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if a.Name != "%acc" || s.Name != "%tmp" {
+		t.Errorf("registers are %s and %s, want %%acc and %%tmp", a.Name, s.Name)
+	}
+	if src := emitFlat(mod); !strings.Contains(src, ".reg .u32 %tmp;") || !strings.Contains(src, "add.u32 %acc, %acc, %tmp;") {
+		t.Errorf("registers not renamed everywhere:\n%s", src)
+	}
+	for _, tt := range []struct{ old, new, want string }{
+		{"a", "b", "has no register %a"},
+		{"acc", "%tmp", "already has a register %tmp"},
+		{"acc", "", "empty name"},
+		{"acc", "%", "empty name"},
+	} {
+		if err := k.RenameReg(tt.old, tt.new); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("RenameReg(%q, %q): error %v, want %q", tt.old, tt.new, err, tt.want)
+		}
+	}
+}
+
+// TestClone checks that a copy shares nothing with the original and that
+// instructions sharing a register in the original share its copy.
+func TestClone(t *testing.T) {
+	mod := renameModule()
+	k := mod.NewKernel("k")
+	k.AddParam(builder.NewParam("n", ptx.U32))
+	a := k.NewReg("a", ptx.U32)
+	p := k.NewReg("p", ptx.Pred)
+	bb := k.NewBlock("")
+	scope := bb.OpenScope()
+	s := scope.NewReg("s", ptx.U32)
+	bb.Add(builder.Ld(a, k.Param("n")).Typed(ptx.U32).InSpace(ptx.Param))
+	bb.Add(builder.Add(s, a, a).Typed(ptx.U32))
+	bb.Add(builder.Bra("end").PredNot(p))
+	scope.Close()
+	k.NewBlock("end").Add(builder.Ret())
+	before := codegen.Emit(mod)
+
+	c := mod.Clone()
+	if got := codegen.Emit(c); got != before {
+		t.Fatalf("copy emits differently:\n%s\nwant:\n%s", got, before)
+	}
+	kc := c.Functions[len(c.Functions)-1]
+	if kc == k || kc.Params[0] == k.Params[0] || c.Globals[0] == mod.Globals[0] {
+		t.Fatal("copy shares a function, parameter or global with the original")
+	}
+	ac, pc := kc.Registers[0], kc.Registers[1]
+	insts := kc.Blocks[0].Instructions
+	sc := insts[1].Dst.(*builder.Register) // .reg .u32 %s; after the {
+	if ac == a || pc == p || sc == s {
+		t.Fatal("copy shares registers with the original")
+	}
+	ld, add, bra := insts[2], insts[3], insts[4]
+	if ld.Dst != ac || add.Dst != sc || add.Src[0] != ac || add.Src[1] != ac || bra.Guard.Reg != pc {
+		t.Errorf("instructions in the copy do not share the copied registers")
+	}
+
+	if err := kc.RenameReg("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RenameFunction("f", "f_new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kc.RenameLabel("end", "out"); err != nil {
+		t.Fatal(err)
+	}
+	if got := codegen.Emit(mod); got != before {
+		t.Errorf("renaming in the copy changed the original:\n%s", got)
+	}
+}
@@ -0,0 +1,70 @@
+package builder
+
+import (
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// Scope is a nested { } block inside a function body. Registers and
+// variables declared in it are visible only until it is closed.
+//
+//	{
+//		.reg .u32 %tmp;
+//		.local .align 4 .b8 spill[64];
+//		...
+//	}
+type Scope struct {
+	fn *Function
+	bb *BasicBlock // block the scope was opened in
+}
+
+// OpenScope adds "{" to the block and returns the new scope. Declarations
+// and the closing "}" go to the function's last block, so a scope may span
+// blocks created while it is open.
+func (bb *BasicBlock) OpenScope() *Scope {
+	bb.Add(ScopeBegin())
+	return &Scope{fn: bb.fn, bb: bb}
+}
+
+// current returns the block that declarations are added to.
+func (s *Scope) current() *BasicBlock {
+	if s.fn != nil && len(s.fn.Blocks) > 0 {
+		return s.fn.Blocks[len(s.fn.Blocks)-1]
+	}
+	return s.bb
+}
+
+// NewReg declares a register visible only inside the scope. It is not
+// added to Function.Registers.
+func (s *Scope) NewReg(name string, typ ptx.Type) *Register {
+	r := &Register{Name: "%" + name, Typ: typ}
+	s.current().Add(DeclareReg(r))
+	return r
+}
+
+// Declare declares a variable visible only inside the scope.
+func (s *Scope) Declare(v *Global) *Global {
+	s.current().Add(Declare(v))
+	return v
+}
+
+// Close adds the closing "}".
+func (s *Scope) Close() {
+	s.current().Add(ScopeEnd())
+}
+
+// AddLocal declares a function-scope variable, such as a .local spill
+// buffer or per-thread array, or .shared memory owned by one kernel.
+func (f *Function) AddLocal(v *Global) *Global {
+	f.Locals = append(f.Locals, v)
+	return v
+}
+
+// NewLocal declares a scalar function-scope variable.
+func (f *Function) NewLocal(name string, space ptx.StateSpace, typ ptx.Type) *Global {
+	return f.AddLocal(NewGlobal(name, space, typ))
+}
+
+// NewLocalArray declares a function-scope array variable.
+func (f *Function) NewLocalArray(name string, space ptx.StateSpace, typ ptx.Type, count int) *Global {
+	return f.AddLocal(NewGlobalArray(name, space, typ, count))
+}
@@ -0,0 +1,546 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// Problem is one reason a module cannot be emitted as valid PTX.
+type Problem struct {
+	Function string // empty for module-scope problems
+	Block    string // block label, or "#N" for an unlabeled block; empty outside a body
+	Index    int    // instruction index within the block, or -1
+	Message  string
+}
+
+// String formats the problem with its location, e.g. "vec_add/loop#3: nil source operand 1".
+func (p Problem) String() string {
+	switch {
+	case p.Function == "":
+		return "module: " + p.Message
+	case p.Block == "":
+		return p.Function + ": " + p.Message
+	default:
+		return fmt.Sprintf("%s/%s#%d: %s", p.Function, p.Block, p.Index, p.Message)
+	}
+}
+
+// EmitError lists every problem found by EmitChecked.
+type EmitError struct {
+	Problems []Problem
+}
+
+func (e *EmitError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = p.String()
+	}
+	return fmt.Sprintf("codegen: %d problem(s):\n\t%s", len(e.Problems), strings.Join(lines, "\n\t"))
+}
+
+// EmitChecked is like Emit but first validates the module. It reports nil
+// operands, enum values with no PTX spelling (which Emit would print as
+// "???", ".unknown" or a default), directives missing their values, call
+// and branch targets that do not resolve, and calls whose arguments or
+// return values do not match the callee or prototype. If any are found it returns
+// an *EmitError listing all of them and no output.
+func EmitChecked(mod *builder.Module) (string, error) {
+	if problems := check(mod); len(problems) > 0 {
+		return "", &EmitError{Problems: problems}
+	}
+	return Emit(mod), nil
+}
+
+// check returns every problem that would make Emit produce invalid PTX
+// for mod, in module order.
+func check(mod *builder.Module) []Problem {
+	c := &checker{mod: mod}
+	if mod == nil {
+		c.module("nil module")
+		return c.problems
+	}
+	c.checkModule()
+	return c.problems
+}
+
+// checker accumulates problems while walking a module.
+type checker struct {
+	mod      *builder.Module
+	problems []Problem
+	fn       string
+	block    string
+	index    int
+}
+
+func (c *checker) module(format string, args ...interface{}) {
+	c.problems = append(c.problems, Problem{Index: -1, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) function(format string, args ...interface{}) {
+	c.problems = append(c.problems, Problem{Function: c.fn, Index: -1, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) inst(format string, args ...interface{}) {
+	c.problems = append(c.problems, Problem{Function: c.fn, Block: c.block, Index: c.index, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) checkModule() {
+	mod := c.mod
+	if mod.Target != ptx.SM50 && mod.Target.String() == "sm_50" {
+		c.module("unknown target %d", int(mod.Target))
+	}
+	if mod.AddressSize != 32 && mod.AddressSize != 64 {
+		c.module("address size %d is not 32 or 64", mod.AddressSize)
+	}
+	for i, g := range mod.Globals {
+		if g == nil {
+			c.module("nil global %d", i)
+			continue
+		}
+		c.checkVar(g, "global", c.module)
+	}
+
+	for i, name := range mod.Files {
+		if name == "" {
+			c.module("source file %d has no name", i+1)
+		}
+	}
+
+	funcs := make(map[string]*builder.Function)
+	defined := make(map[string]bool)
+	for _, f := range mod.Functions {
+		if f == nil {
+			continue
+		}
+		if !f.IsDecl {
+			if defined[f.Name] {
+				c.module("function %s is defined more than once", f.Name)
+			}
+			defined[f.Name] = true
+		}
+		if prev := funcs[f.Name]; prev == nil || prev.IsDecl {
+			funcs[f.Name] = f // prefer the definition's signature
+		}
+	}
+	for i, f := range mod.Functions {
+		if f == nil {
+			c.module("nil function %d", i)
+			continue
+		}
+		c.checkFunction(f, funcs)
+	}
+}
+
+func (c *checker) checkFunction(f *builder.Function, funcs map[string]*builder.Function) {
+	c.fn, c.block, c.index = f.Name, "", -1
+	if f.Name == "" {
+		c.fn = "<unnamed>"
+		c.function("function has no name")
+	}
+	if !knownLinkage(f.Linkage) {
+		c.function("unknown linkage %d", int(f.Linkage))
+	}
+	switch {
+	case f.IsDecl && len(f.Blocks) > 0:
+		c.function("declaration has a body")
+	case !f.IsDecl && f.Linkage == ptx.LinkExtern:
+		c.function(".extern function has a body; use ExternFunc to declare it")
+	}
+	c.checkParams(f.Params, f.ReturnParams)
+
+	protos := make(map[string]*builder.CallPrototype)
+	for i, p := range f.Prototypes {
+		if p == nil {
+			c.function("nil call prototype %d", i)
+			continue
+		}
+		if p.Label == "" {
+			c.function("call prototype %d has no label", i)
+		}
+		protos[p.Label] = p
+		c.checkParams(p.Params, p.ReturnParams)
+	}
+	lists := make(map[string]*builder.CallTargetList)
+	for i, l := range f.CallTargetLists {
+		if l == nil {
+			c.function("nil call target list %d", i)
+			continue
+		}
+		if l.Label == "" {
+			c.function("call target list %d has no label", i)
+		}
+		if len(l.Targets) == 0 {
+			c.function("call target list %s is empty", l.Label)
+		}
+		for _, t := range l.Targets {
+			if funcs[t] == nil {
+				c.function("call target list %s names %s, which is not a function in the module", l.Label, t)
+			}
+		}
+		lists[l.Label] = l
+	}
+
+	for i, r := range f.Registers {
+		if r == nil {
+			c.function("nil register %d", i)
+			continue
+		}
+		if !knownType(r.Typ) {
+			c.function("register %s: unknown type %d", r.Name, int(r.Typ))
+		}
+	}
+	for i, v := range f.Locals {
+		if v == nil {
+			c.function("nil local variable %d", i)
+			continue
+		}
+		c.checkVar(v, "local variable", c.function)
+		if v.Space == ptx.Reg {
+			c.function("local variable %s: declare registers with NewReg", v.Name)
+		}
+	}
+	for i, d := range f.Directives {
+		if d == nil {
+			c.function("nil directive %d", i)
+			continue
+		}
+		c.checkDirective(d)
+	}
+
+	labels := make(map[string]bool)
+	for _, bb := range f.Blocks {
+		if bb != nil && bb.Label != "" {
+			labels[bb.Label] = true
+		}
+	}
+	depth := 0
+	for b, bb := range f.Blocks {
+		if bb == nil {
+			c.function("nil block %d", b)
+			continue
+		}
+		c.block = bb.Label
+		if c.block == "" {
+			c.block = fmt.Sprintf("#%d", b)
+		}
+		for i, inst := range bb.Instructions {
+			c.index = i
+			if inst == nil {
+				c.inst("nil instruction")
+				continue
+			}
+			c.checkInstruction(inst, labels)
+			switch inst.Op {
+			case ptx.OpCall:
+				c.checkCall(inst, funcs, protos, lists)
+			case ptx.OpScopeBegin:
+				depth++
+			case ptx.OpScopeEnd:
+				if depth == 0 {
+					c.inst("} closes no open scope")
+				} else {
+					depth--
+				}
+			case ptx.OpDecl:
+				if inst.Var != nil {
+					c.checkVar(inst.Var, "variable", c.inst)
+				} else if r, ok := inst.Dst.(*builder.Register); !ok || r == nil {
+					c.inst("declaration has no variable or register")
+				} else if !knownType(r.Typ) {
+					c.inst("register %s: unknown type %d", r.Name, int(r.Typ))
+				}
+			}
+		}
+	}
+	if depth > 0 {
+		c.block, c.index = "", -1
+		c.function("%d scope(s) not closed", depth)
+	}
+}
+
+// directiveSpecs gives the spelling of each directive kind and how many
+// values it takes; min and max are 0 for directives that take text or
+// nothing.
+var directiveSpecs = map[builder.DirectiveKind]struct {
+	name     string
+	min, max int
+}{
+	builder.DirMaxNReg:           {".maxnreg", 1, 1},
+	builder.DirMaxNTid:           {".maxntid", 1, 3},
+	builder.DirReqNTid:           {".reqntid", 1, 3},
+	builder.DirMinNCTAPerSM:      {".minnctapersm", 1, 1},
+	builder.DirMaxNCTAPerSM:      {".maxnctapersm", 1, 1},
+	builder.DirPragma:            {".pragma", 0, 0},
+	builder.DirReqNCluster:       {".reqnctapercluster", 1, 3},
+	builder.DirNoReturn:          {".noreturn", 0, 0},
+	builder.DirAbiPreserve:       {".abi_preserve", 1, 1},
+	builder.DirAbiPreserveCtrl:   {".abi_preserve_control", 1, 1},
+	builder.DirExplicitCluster:   {".explicitcluster", 0, 0},
+	builder.DirMaxClusterRank:    {".maxclusterrank", 1, 1},
+	builder.DirBlocksAreClusters: {".blocksareclusters", 0, 0},
+	builder.DirAlias:             {".alias", 0, 0},
+}
+
+func (c *checker) checkDirective(d *builder.Directive) {
+	spec, ok := directiveSpecs[d.Kind]
+	switch {
+	case !ok:
+		c.function("unknown directive kind %d", int(d.Kind))
+	case spec.max > 0 && (len(d.Values) < spec.min || len(d.Values) > spec.max):
+		want := fmt.Sprint(spec.min)
+		if spec.min != spec.max {
+			want = fmt.Sprintf("%d to %d", spec.min, spec.max)
+		}
+		c.function("%s has %d value(s), want %s", spec.name, len(d.Values), want)
+	case (d.Kind == builder.DirPragma || d.Kind == builder.DirAlias) && d.Text == "":
+		c.function("%s has no text", spec.name)
+	}
+}
+
+// checkVar reports a variable declaration with values that have no PTX
+// spelling, through report.
+func (c *checker) checkVar(g *builder.Global, what string, report func(string, ...interface{})) {
+	if !knownLinkage(g.Linkage) {
+		report("%s %s: unknown linkage %d", what, g.Name, int(g.Linkage))
+	}
+	if !knownSpace(g.Space) {
+		report("%s %s: unknown state space %d", what, g.Name, int(g.Space))
+	}
+	if !knownType(g.Typ) {
+		report("%s %s: unknown type %d", what, g.Name, int(g.Typ))
+	}
+	if !knownVec(g.Vec) {
+		report("%s %s: unknown vector size %d", what, g.Name, int(g.Vec))
+	}
+	if len(g.Initializer) > 0 && g.Space != ptx.Global && g.Space != ptx.Const {
+		report("%s %s: only .global and .const variables can be initialized", what, g.Name)
+	}
+}
+
+// checkParams reports parameters that are nil or have no PTX spelling.
+func (c *checker) checkParams(lists ...[]*builder.Param) {
+	for _, params := range lists {
+		for i, p := range params {
+			if p == nil {
+				c.function("nil parameter %d", i)
+				continue
+			}
+			if !knownType(p.Typ) {
+				c.function("parameter %s: unknown type %d", p.Name, int(p.Typ))
+			}
+			if p.IsPointer && !knownSpace(p.PtrSpace) {
+				c.function("parameter %s: unknown pointer state space %d", p.Name, int(p.PtrSpace))
+			}
+		}
+	}
+}
+
+func (c *checker) checkInstruction(inst *builder.Instruction, labels map[string]bool) {
+	if inst.Op.String() == "unknown" {
+		c.inst("unknown opcode %d", int(inst.Op))
+	}
+	if !knownType(inst.Typ) {
+		c.inst("unknown type %d", int(inst.Typ))
+	}
+	if inst.SrcType != 0 && !knownType(inst.SrcType) {
+		c.inst("unknown source type %d", int(inst.SrcType))
+	}
+	if inst.Space != ptx.StateSpace(0) && !knownSpace(inst.Space) {
+		c.inst("unknown state space %d", int(inst.Space))
+	}
+	if inst.Op == ptx.OpSet || inst.Op == ptx.OpSetp {
+		if inst.Cmp.String() == ".unknown" {
+			c.inst("unknown comparison %d", int(inst.Cmp))
+		}
+		if inst.BoolOp != ptx.BoolNone && inst.BoolOp.String() == "" {
+			c.inst("unknown boolean operator %d", int(inst.BoolOp))
+		}
+	}
+	if inst.Rounding != ptx.RoundNone && inst.Rounding.String() == "" {
+		c.inst("unknown rounding mode %d", int(inst.Rounding))
+	}
+	if inst.Cache != ptx.CacheNone && inst.Cache.String() == "" {
+		c.inst("unknown cache operator %d", int(inst.Cache))
+	}
+	if inst.Scope != ptx.ScopeNone && inst.Scope.String() == "" {
+		c.inst("unknown scope %d", int(inst.Scope))
+	}
+	if !knownVec(inst.Vec) {
+		c.inst("unknown vector size %d", int(inst.Vec))
+	}
+	for _, m := range inst.Modifiers {
+		if m.String() == "" {
+			c.inst("unknown modifier %d", int(m))
+		}
+	}
+	if inst.Guard != nil && inst.Guard.Reg == nil {
+		c.inst("guard predicate has no register")
+	}
+	if l := inst.Loc; l != nil && (l.File == "" || l.Line < 1 || l.Column < 0) {
+		c.inst("source location %q line %d column %d is not valid", l.File, l.Line, l.Column)
+	}
+
+	if inst.Dst != nil {
+		c.checkOperand(inst.Dst, "destination")
+	}
+	if inst.Dst2 != nil {
+		c.checkOperand(inst.Dst2, "second destination")
+	}
+	for i, src := range inst.Src {
+		c.checkOperand(src, fmt.Sprintf("source operand %d", i))
+	}
+
+	switch inst.Op {
+	case ptx.OpBra:
+		if len(inst.Src) == 0 {
+			c.inst("bra has no target")
+		} else if s, ok := inst.Src[0].(*builder.Symbol); ok && s != nil && !labels[s.Name] {
+			c.inst("branch target %s is not a label in the function", s.Name)
+		}
+	}
+}
+
+// checkCall reports a call whose target does not resolve, or whose
+// arguments and return value do not match the callee's signature. An
+// indirect call is checked against its prototype, or against every
+// function in its call target list.
+func (c *checker) checkCall(inst *builder.Instruction, funcs map[string]*builder.Function,
+	protos map[string]*builder.CallPrototype, lists map[string]*builder.CallTargetList) {
+	if inst.CallTarget != "" {
+		callee := funcs[inst.CallTarget]
+		if callee == nil {
+			c.inst("call target %s is not a function in the module", inst.CallTarget)
+			return
+		}
+		c.matchCall(inst.CallTarget, callee.Params, callee.ReturnParams, inst.Dst, inst.Src)
+		return
+	}
+	if len(inst.Src) < 2 {
+		c.inst("indirect call needs a function pointer and a prototype")
+		return
+	}
+	args := inst.Src[1 : len(inst.Src)-1]
+	sym, ok := inst.Src[len(inst.Src)-1].(*builder.Symbol)
+	if !ok || sym == nil {
+		c.inst("indirect call does not end with a prototype or call target list label")
+		return
+	}
+	if p := protos[sym.Name]; p != nil {
+		c.matchCall("prototype "+sym.Name, p.Params, p.ReturnParams, inst.Dst, args)
+		return
+	}
+	if l := lists[sym.Name]; l != nil {
+		for _, t := range l.Targets {
+			if callee := funcs[t]; callee != nil {
+				c.matchCall(t, callee.Params, callee.ReturnParams, inst.Dst, args)
+			}
+		}
+		return
+	}
+	c.inst("%s is not a call prototype or call target list in the function", sym.Name)
+}
+
+// matchCall compares a call's operands with a signature. Only register
+// operands are type-checked; symbols and immediates carry no type.
+func (c *checker) matchCall(callee string, params, returns []*builder.Param, dst builder.Operand, args []builder.Operand) {
+	if len(args) != len(params) {
+		c.inst("call to %s passes %d argument(s), want %d", callee, len(args), len(params))
+	}
+	nret := 0
+	if dst != nil {
+		nret = 1
+	}
+	if nret != len(returns) {
+		c.inst("call to %s takes %d return value(s), callee returns %d", callee, nret, len(returns))
+	}
+	match := func(op builder.Operand, p *builder.Param, what string) {
+		r, ok := op.(*builder.Register)
+		if !ok || r == nil || p == nil || p.Size > 0 || compatible(r.Typ, p.Typ) {
+			return
+		}
+		c.inst("call to %s %s %s is %s, want %s", callee, what, r.Name, r.Typ, p.Typ)
+	}
+	for i := 0; i < len(args) && i < len(params); i++ {
+		match(args[i], params[i], fmt.Sprintf("argument %d", i))
+	}
+	if dst != nil && len(returns) > 0 {
+		match(dst, returns[0], "return value")
+	}
+}
+
+// compatible reports whether a register of type a may be passed for a
+// parameter of type b: the types are equal, or have the same width and
+// either one is a bit type or both are integers or both floats.
+func compatible(a, b ptx.Type) bool {
+	switch {
+	case a == b:
+		return true
+	case a == ptx.Pred || b == ptx.Pred || a.BitWidth() != b.BitWidth():
+		return false
+	}
+	return isBits(a) || isBits(b) || a.IsFloat() == b.IsFloat()
+}
+
+func isBits(t ptx.Type) bool {
+	return t >= ptx.B8 && t <= ptx.B128
+}
+
+// checkOperand reports nil operands, including nil elements and bases,
+// and values with no PTX spelling.
+func (c *checker) checkOperand(op builder.Operand, what string) {
+	switch o := op.(type) {
+	case nil:
+		c.inst("nil %s", what)
+	case *builder.Register:
+		if o == nil {
+			c.inst("nil register in %s", what)
+		}
+	case *builder.Symbol:
+		if o == nil {
+			c.inst("nil symbol in %s", what)
+		}
+	case *builder.Immediate:
+		if o == nil {
+			c.inst("nil immediate in %s", what)
+			return
+		}
+		switch o.Value.(type) {
+		case int, int32, int64, uint32, uint64, float32, float64:
+		default:
+			c.inst("immediate of type %T in %s", o.Value, what)
+		}
+	case *builder.Address:
+		if o == nil {
+			c.inst("nil address in %s", what)
+			return
+		}
+		c.checkOperand(o.Base, what+" base")
+	case *builder.VectorOp:
+		if o == nil {
+			c.inst("nil vector in %s", what)
+			return
+		}
+		for i, el := range o.Elements {
+			c.checkOperand(el, fmt.Sprintf("%s element %d", what, i))
+		}
+	case *builder.SpecialRegOp:
+		if o == nil {
+			c.inst("nil special register in %s", what)
+		} else if o.Reg.String() == "%unknown" {
+			c.inst("unknown special register %d in %s", int(o.Reg), what)
+		}
+	default:
+		c.inst("unsupported operand %T in %s", op, what)
+	}
+}
+
+func knownType(t ptx.Type) bool { return t.String() != ".unknown" }
+
+func knownSpace(s ptx.StateSpace) bool { return s.String() != ".unknown" }
+
+func knownVec(v ptx.VectorSize) bool { return v == ptx.Scalar || v.String() != "" }
+
+func knownLinkage(l ptx.Linkage) bool { return l == ptx.LinkNone || l.String() != "" }
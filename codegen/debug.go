@@ -0,0 +1,67 @@
+package codegen
+
+import (
+	"strings"
+
+	"github.com/arc-language/ptx-gen/builder"
+)
+
+// emitFiles numbers the module's source files, those in mod.Files first
+// and then any others named by instruction locations, and declares them.
+//
+// Output example:
+//
+//	.file 1 "/src/kernels/gemm.go"
+func (e *Emitter) emitFiles(mod *builder.Module) {
+	e.files = make(map[string]int)
+	var names []string
+	add := func(name string) {
+		if _, ok := e.files[name]; !ok {
+			names = append(names, name)
+			e.files[name] = len(names)
+		}
+	}
+	for _, name := range mod.Files {
+		add(name)
+	}
+	for _, f := range mod.Functions {
+		if f == nil {
+			continue
+		}
+		for _, bb := range f.Blocks {
+			if bb == nil {
+				continue
+			}
+			for _, inst := range bb.Instructions {
+				if inst != nil && inst.Loc != nil {
+					add(inst.Loc.File)
+				}
+			}
+		}
+	}
+
+	for i, name := range names {
+		e.linef(".file %d \"%s\"", i+1, fileNameEscaper.Replace(name))
+	}
+	if len(names) > 0 {
+		e.blank()
+	}
+}
+
+// fileNameEscaper escapes a file name for a quoted .file string, so
+// Windows paths and names with quotes stay valid.
+var fileNameEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// emitLoc writes a .loc directive for loc unless it repeats the previous
+// one in the function.
+//
+// Output example:
+//
+//	.loc 1 42 0
+func (e *Emitter) emitLoc(loc *builder.SourceLoc) {
+	if loc == nil || e.opts.StripLineInfo || (e.loc != nil && *e.loc == *loc) {
+		return
+	}
+	e.loc = loc
+	e.linef(".loc %d %d %d", e.files[loc.File], loc.Line, loc.Column)
+}
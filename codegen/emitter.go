@@ -1,71 +1,121 @@
 package codegen
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/arc-language/ptx-gen/builder"
 )
 
+// bufferSize is the size of the buffered writer EmitTo streams through.
+const bufferSize = 64 << 10
+
 // Emitter holds state during PTX text generation.
 type Emitter struct {
-    buf    strings.Builder
-    indent int
+	w      *bufio.Writer
+	err    error // first write error; once set, nothing more is written
+	opts   EmitOptions
+	sep    string // between operands and list elements
+	indent int
+	buf    []byte // scratch buffer reused for each instruction line
+	files  map[string]int     // .file index of each source file
+	loc    *builder.SourceLoc // last .loc emitted in the current function
 }
 
 // Emit takes a complete builder.Module and returns the PTX source string.
 func Emit(mod *builder.Module) string {
-    e := &Emitter{}
-    e.emitModule(mod)
-    return e.buf.String()
+	var sb strings.Builder
+	EmitTo(&sb, mod) // a strings.Builder never fails
+	return sb.String()
+}
+
+// EmitTo writes the PTX source for mod to w through a buffered writer. It
+// stops at the first write error and returns it.
+func EmitTo(w io.Writer, mod *builder.Module) error {
+	return EmitToWithOptions(w, mod, DefaultEmitOptions())
 }
 
 // --- Write helpers ---
 
+// failed reports whether a write has failed, so callers can stop early.
+func (e *Emitter) failed() bool {
+	return e.err != nil
+}
+
 // write appends raw text.
 func (e *Emitter) write(s string) {
-    e.buf.WriteString(s)
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+// writeBytes appends raw bytes.
+func (e *Emitter) writeBytes(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
 }
 
 // writef appends formatted text.
 func (e *Emitter) writef(format string, args ...interface{}) {
-    e.buf.WriteString(fmt.Sprintf(format, args...))
+	if e.err == nil {
+		_, e.err = fmt.Fprintf(e.w, format, args...)
+	}
 }
 
 // line writes an indented line followed by a newline.
 func (e *Emitter) line(s string) {
-    e.writeIndent()
-    e.buf.WriteString(s)
-    e.buf.WriteByte('\n')
+	e.writeIndent()
+	e.write(s)
+	e.newline()
 }
 
 // linef writes a formatted indented line.
 func (e *Emitter) linef(format string, args ...interface{}) {
-    e.writeIndent()
-    e.buf.WriteString(fmt.Sprintf(format, args...))
-    e.buf.WriteByte('\n')
+	e.writeIndent()
+	e.writef(format, args...)
+	e.newline()
+}
+
+// newline ends the current line.
+func (e *Emitter) newline() {
+	if e.err == nil {
+		e.err = e.w.WriteByte('\n')
+	}
 }
 
-// blank emits an empty line.
+// blank emits an empty line, except in compact output.
 func (e *Emitter) blank() {
-    e.buf.WriteByte('\n')
+	if !e.opts.Compact {
+		e.newline()
+	}
 }
 
-// writeIndent writes the current indentation (tab-based).
+// writeIndent writes the current indentation.
 func (e *Emitter) writeIndent() {
-    for i := 0; i < e.indent; i++ {
-        e.buf.WriteByte('\t')
-    }
+	for i := 0; i < e.indent; i++ {
+		e.write(e.opts.Indent)
+	}
+}
+
+// appendIndent appends the current indentation to b.
+func (e *Emitter) appendIndent(b []byte) []byte {
+	for i := 0; i < e.indent; i++ {
+		b = append(b, e.opts.Indent...)
+	}
+	return b
 }
 
 // push increases indentation.
 func (e *Emitter) push() {
-    e.indent++
+	e.indent++
 }
 
 // pop decreases indentation.
 func (e *Emitter) pop() {
-    if e.indent > 0 {
-        e.indent--
-    }
-}
\ No newline at end of file
+	if e.indent > 0 {
+		e.indent--
+	}
+}
@@ -0,0 +1,61 @@
+package codegen
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// benchModule builds a module of n vec_add style kernels, the shape of an
+// autotuning sweep.
+func benchModule(n int) *builder.Module {
+	mod := builder.NewModule(ptx.ISA85, ptx.SM90)
+	for i := 0; i < n; i++ {
+		k := mod.NewKernel(fmt.Sprintf("vec_add_%d", i))
+		k.AddParam(builder.NewPtrParam("a", ptx.Global).WithAlign(8))
+		k.AddParam(builder.NewPtrParam("b", ptx.Global).WithAlign(8))
+		k.AddParam(builder.NewParam("n", ptx.U32))
+		tid := k.NewReg("tid", ptx.U32)
+		off := k.NewReg("off", ptx.U64)
+		pa := k.NewReg("pa", ptx.U64)
+		pb := k.NewReg("pb", ptx.U64)
+		x := k.NewReg("x", ptx.F32)
+		p := k.NewReg("p", ptx.Pred)
+
+		entry := k.NewBlock("entry")
+		entry.Add(builder.Mov(tid, builder.SReg(ptx.RegTidX)).Typed(ptx.U32))
+		entry.Add(builder.Setp(ptx.CmpGe, p, tid, builder.Imm(int64(i))).Typed(ptx.U32))
+		entry.Add(builder.Bra("done").Pred(p))
+		entry.Add(builder.Ld(pa, k.Param("a")).Typed(ptx.U64).InSpace(ptx.Param))
+		entry.Add(builder.Ld(pb, k.Param("b")).Typed(ptx.U64).InSpace(ptx.Param))
+		entry.Add(builder.Mul(off, tid, builder.Imm(4)).Typed(ptx.U32).WithMod(ptx.ModWide))
+		entry.Add(builder.Add(pa, pa, off).Typed(ptx.U64))
+		entry.Add(builder.Add(pb, pb, off).Typed(ptx.U64))
+		entry.Add(builder.Ld(x, builder.Addr(pa, 0)).Typed(ptx.F32).InSpace(ptx.Global))
+		entry.Add(builder.Mul(x, x, builder.ImmF32(1.5)).Typed(ptx.F32))
+		entry.Add(builder.St(builder.Addr(pb, 0), x).Typed(ptx.F32).InSpace(ptx.Global))
+		k.NewBlock("done").Add(builder.Ret())
+	}
+	return mod
+}
+
+func BenchmarkEmit(b *testing.B) {
+	mod := benchModule(1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Emit(mod)
+	}
+}
+
+func BenchmarkEmitTo(b *testing.B) {
+	mod := benchModule(1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := EmitTo(io.Discard, mod); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package codegen
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/arc-language/ptx-gen/builder"
@@ -10,23 +11,44 @@ import (
 
 // emitFunction emits a .entry or .func definition with params, registers, directives, and body.
 func (e *Emitter) emitFunction(f *builder.Function) {
-	e.emitFunctionSignature(f)
+	e.loc = nil
+	e.emitComment(f.Annotation)
+	if f.IsDecl {
+		e.emitFunctionSignature(f, ";")
+		return
+	}
+	e.emitFunctionSignature(f, "")
 
 	e.line("{")
 	e.push()
 
 	e.emitRegisterDecls(f)
 
+	for _, v := range f.Locals {
+		e.emitGlobal(v)
+	}
+
 	for _, d := range f.Directives {
 		e.emitDirective(d)
 	}
 
-	if len(f.Registers) > 0 || len(f.Directives) > 0 {
+	for _, p := range f.Prototypes {
+		e.emitPrototype(p)
+	}
+	for _, l := range f.CallTargetLists {
+		e.linef("%s: .calltargets %s;", l.Label, strings.Join(l.Targets, e.sep))
+	}
+
+	if len(f.Registers) > 0 || len(f.Locals) > 0 || len(f.Directives) > 0 ||
+		len(f.Prototypes) > 0 || len(f.CallTargetLists) > 0 {
 		e.blank()
 	}
 
 	for i, bb := range f.Blocks {
-		if i > 0 {
+		if e.failed() {
+			return
+		}
+		if i > 0 && e.opts.BlankBetweenBlocks {
 			e.blank()
 		}
 		e.emitBlock(bb)
@@ -36,7 +58,8 @@ func (e *Emitter) emitFunction(f *builder.Function) {
 	e.line("}")
 }
 
-// emitFunctionSignature emits the .entry/.func line with parameters and attributes.
+// emitFunctionSignature emits the .entry/.func line with parameters and
+// attributes, followed by end (";" for a declaration).
 //
 // Output examples:
 //
@@ -47,7 +70,11 @@ func (e *Emitter) emitFunction(f *builder.Function) {
 //	)
 //
 //	.func .attribute(.unified(0xAB, 0xCD)) bar()
-func (e *Emitter) emitFunctionSignature(f *builder.Function) {
+//
+//	.extern .func (.reg .f32 r) __nv_sinf(
+//	    .reg .f32 x
+//	);
+func (e *Emitter) emitFunctionSignature(f *builder.Function, end string) {
 	var prefix []string
 
 	// Linkage
@@ -71,12 +98,12 @@ func (e *Emitter) emitFunctionSignature(f *builder.Function) {
 				for _, p := range attr.Params {
 					pStrs = append(pStrs, fmt.Sprintf("%v", p))
 				}
-				attrs = append(attrs, fmt.Sprintf(".%s(%s)", attr.Name, strings.Join(pStrs, ", ")))
+				attrs = append(attrs, fmt.Sprintf(".%s(%s)", attr.Name, strings.Join(pStrs, e.sep)))
 			} else {
 				attrs = append(attrs, fmt.Sprintf(".%s", attr.Name))
 			}
 		}
-		prefix = append(prefix, fmt.Sprintf(".attribute(%s)", strings.Join(attrs, ", ")))
+		prefix = append(prefix, fmt.Sprintf(".attribute(%s)", strings.Join(attrs, e.sep)))
 	}
 
 	head := strings.Join(prefix, " ")
@@ -87,7 +114,7 @@ func (e *Emitter) emitFunctionSignature(f *builder.Function) {
 		for i, rp := range f.ReturnParams {
 			retParts[i] = emitParamDecl(rp, f.IsKernel)
 		}
-		e.writef("%s (%s) %s", head, strings.Join(retParts, ", "), f.Name)
+		e.writef("%s (%s) %s", head, strings.Join(retParts, e.sep), f.Name)
 	} else {
 		e.writeIndent()
 		if len(prefix) > 0 {
@@ -99,7 +126,16 @@ func (e *Emitter) emitFunctionSignature(f *builder.Function) {
 
 	// Input parameters
 	if len(f.Params) == 0 {
-		e.write("()\n")
+		e.write("()" + end + "\n")
+		return
+	}
+
+	if e.opts.Compact {
+		parts := make([]string, len(f.Params))
+		for i, p := range f.Params {
+			parts[i] = emitParamDecl(p, f.IsKernel)
+		}
+		e.write("(" + strings.Join(parts, e.sep) + ")" + end + "\n")
 		return
 	}
 
@@ -114,7 +150,33 @@ func (e *Emitter) emitFunctionSignature(f *builder.Function) {
 		e.write("\n")
 	}
 	e.pop()
-	e.line(")")
+	e.line(")" + end)
+}
+
+// emitPrototype emits a .callprototype declaration.
+//
+// Output example:
+//
+//	proto_0: .callprototype (.reg .f32 _) _ (.reg .f32 _, .reg .u32 _);
+func (e *Emitter) emitPrototype(p *builder.CallPrototype) {
+	decls := func(params []*builder.Param) string {
+		parts := make([]string, len(params))
+		for i, param := range params {
+			anon := *param
+			anon.Name = "_"
+			parts[i] = emitParamDecl(&anon, false)
+		}
+		return "(" + strings.Join(parts, e.sep) + ")"
+	}
+	s := p.Label + ": .callprototype"
+	if len(p.ReturnParams) > 0 {
+		s += " " + decls(p.ReturnParams)
+	}
+	s += " _"
+	if len(p.Params) > 0 {
+		s += " " + decls(p.Params)
+	}
+	e.line(s + ";")
 }
 
 // emitParamDecl formats a single parameter declaration string.
@@ -167,7 +229,8 @@ func emitParamDecl(p *builder.Param, isKernel bool) string {
 	return strings.Join(parts, " ")
 }
 
-// emitRegisterDecls groups registers by type and emits .reg declarations.
+// emitRegisterDecls groups registers by type and emits .reg declarations,
+// in creation order or, with SortRegisters, in type and natural name order.
 //
 // Output example:
 //
@@ -186,13 +249,24 @@ func (e *Emitter) emitRegisterDecls(f *builder.Function) {
 		groups[r.Typ] = append(groups[r.Typ], r)
 	}
 
+	if e.opts.SortRegisters {
+		sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+	}
+
 	for _, typ := range order {
 		regs := groups[typ]
 		names := make([]string, len(regs))
 		for i, r := range regs {
 			names[i] = r.Name
 		}
-		e.linef(".reg %-6s %s;", typ.String(), strings.Join(names, ", "))
+		if e.opts.SortRegisters {
+			sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+		}
+		if e.opts.Compact {
+			e.linef(".reg %s %s;", typ.String(), strings.Join(names, e.sep))
+		} else {
+			e.linef(".reg %-6s %s;", typ.String(), strings.Join(names, e.sep))
+		}
 	}
 }
 
@@ -202,9 +276,9 @@ func (e *Emitter) emitDirective(d *builder.Directive) {
 	case builder.DirMaxNReg:
 		e.linef(".maxnreg %d", d.Values[0])
 	case builder.DirMaxNTid:
-		e.linef(".maxntid %s", joinInts(d.Values))
+		e.linef(".maxntid %s", e.joinInts(d.Values))
 	case builder.DirReqNTid:
-		e.linef(".reqntid %s", joinInts(d.Values))
+		e.linef(".reqntid %s", e.joinInts(d.Values))
 	case builder.DirMinNCTAPerSM:
 		e.linef(".minnctapersm %d", d.Values[0])
 	case builder.DirMaxNCTAPerSM:
@@ -212,7 +286,7 @@ func (e *Emitter) emitDirective(d *builder.Directive) {
 	case builder.DirPragma:
 		e.linef(".pragma \"%s\";", d.Text)
 	case builder.DirReqNCluster:
-		e.linef(".reqnctapercluster %s", joinInts(d.Values))
+		e.linef(".reqnctapercluster %s", e.joinInts(d.Values))
 	case builder.DirNoReturn:
 		e.line(".noreturn")
 	case builder.DirAbiPreserve:
@@ -230,12 +304,22 @@ func (e *Emitter) emitDirective(d *builder.Directive) {
 	}
 }
 
-// emitBlock emits a labeled basic block.
+// emitBlock emits a labeled basic block. The block comment trails the
+// label, or stands above the first instruction of an unlabeled block.
 func (e *Emitter) emitBlock(bb *builder.BasicBlock) {
 	if bb.Label != "" {
 		e.pop()
-		e.linef("%s:", bb.Label)
+		if strings.Contains(bb.Annotation, "\n") {
+			e.emitComment(bb.Annotation)
+		}
+		b := append(e.appendIndent(e.buf[:0]), bb.Label...)
+		b = append(b, ':')
+		b = e.appendTrailingComment(b, bb.Annotation)
+		e.buf = append(b, '\n')
+		e.writeBytes(e.buf)
 		e.push()
+	} else {
+		e.emitComment(bb.Annotation)
 	}
 
 	for _, inst := range bb.Instructions {
@@ -244,10 +328,10 @@ func (e *Emitter) emitBlock(bb *builder.BasicBlock) {
 }
 
 // joinInts formats a slice of ints as a comma-separated string.
-func joinInts(vals []int) string {
+func (e *Emitter) joinInts(vals []int) string {
 	parts := make([]string, len(vals))
 	for i, v := range vals {
 		parts[i] = fmt.Sprintf("%d", v)
 	}
-	return strings.Join(parts, ", ")
+	return strings.Join(parts, e.sep)
 }
\ No newline at end of file
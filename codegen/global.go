@@ -16,6 +16,7 @@ import (
 //	.global .align 16 .b8 buffer[4096];
 //	.shared .f32 smem[256];
 //	.const .b32 lookup[16] = {0, 1, 2, 3};
+//	.const .f32 scale = 0.5;
 //	.global .attribute(.managed) .s32 g;
 func (e *Emitter) emitGlobal(g *builder.Global) {
 	var parts []string
@@ -39,12 +40,12 @@ func (e *Emitter) emitGlobal(g *builder.Global) {
 				for _, p := range attr.Params {
 					pStrs = append(pStrs, fmt.Sprintf("%v", p))
 				}
-				attrs = append(attrs, fmt.Sprintf(".%s(%s)", attr.Name, strings.Join(pStrs, ", ")))
+				attrs = append(attrs, fmt.Sprintf(".%s(%s)", attr.Name, strings.Join(pStrs, e.sep)))
 			} else {
 				attrs = append(attrs, fmt.Sprintf(".%s", attr.Name))
 			}
 		}
-		parts = append(parts, fmt.Sprintf(".attribute(%s)", strings.Join(attrs, ", ")))
+		parts = append(parts, fmt.Sprintf(".attribute(%s)", strings.Join(attrs, e.sep)))
 	}
 
 	// 4. Alignment
@@ -73,7 +74,11 @@ func (e *Emitter) emitGlobal(g *builder.Global) {
 		for i, v := range g.Initializer {
 			vals[i] = fmt.Sprintf("%v", v)
 		}
-		e.linef("%s = {%s};", strings.Join(parts, " "), strings.Join(vals, ", "))
+		if g.Count == 0 && len(vals) == 1 {
+			e.linef("%s = %s;", strings.Join(parts, " "), vals[0]) // scalars take no braces
+		} else {
+			e.linef("%s = {%s};", strings.Join(parts, " "), strings.Join(vals, e.sep))
+		}
 	} else {
 		e.linef("%s;", strings.Join(parts, " "))
 	}
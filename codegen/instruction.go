@@ -18,55 +18,80 @@ import (
 //   st.global.f32  [%rd0], %f0;
 //   bar.sync       0;
 //   ret;
+//   add.u32        %r0, %r1, %r2;  // comment
 func (e *Emitter) emitInstruction(inst *builder.Instruction) {
-    var line strings.Builder
+    switch inst.Op {
+    case ptx.OpComment:
+        e.emitComment(inst.Annotation)
+        return
+    case ptx.OpScopeBegin:
+        e.emitComment(inst.Annotation)
+        e.line("{")
+        e.push()
+        return
+    case ptx.OpScopeEnd:
+        e.pop()
+        e.line("}")
+        return
+    case ptx.OpDecl:
+        e.emitComment(inst.Annotation)
+        if inst.Var != nil {
+            e.emitGlobal(inst.Var)
+        } else if r, ok := inst.Dst.(*builder.Register); ok {
+            e.linef(".reg %s %s;", r.Typ.String(), r.Name)
+        }
+        return
+    }
+    if strings.Contains(inst.Annotation, "\n") {
+        e.emitComment(inst.Annotation)
+    }
+    e.emitLoc(inst.Loc)
+
+    b := e.appendIndent(e.buf[:0])
+    start := len(b)
 
     // Guard predicate: @%p or @!%p
     if inst.Guard != nil {
         if inst.Guard.Negate {
-            line.WriteString("@!")
+            b = append(b, "@!"...)
         } else {
-            line.WriteString("@")
+            b = append(b, '@')
         }
-        line.WriteString(inst.Guard.Reg.Name)
-        line.WriteString(" ")
+        b = append(b, inst.Guard.Reg.Name...)
+        b = append(b, ' ')
     }
 
     // Mnemonic: opcode + modifiers + space + cache + scope + rounding + vec + type
-    line.WriteString(buildMnemonic(inst))
-
-    // Operands
-    ops := buildOperands(inst)
-    if ops != "" {
-        // Pad mnemonic to a column for alignment
-        mnem := line.String()
-        padded := padTo(mnem, 15)
-        line.Reset()
-        line.WriteString(padded)
-        line.WriteString(ops)
+    b = appendMnemonic(b, inst)
+
+    // Operands, with the mnemonic padded to a column for alignment
+    if hasOperands(inst) {
+        b = padTo(b, start, e.opts.MnemonicWidth)
+        b = appendOperands(b, inst, e.sep)
     }
 
-    line.WriteString(";")
-    e.line(line.String())
+    b = append(b, ';')
+    b = e.appendTrailingComment(b, inst.Annotation)
+    b = append(b, '\n')
+    e.buf = b
+    e.writeBytes(b)
 }
 
-// buildMnemonic constructs the full instruction mnemonic string.
+// appendMnemonic appends the full instruction mnemonic to sb.
 // Order: Opcode .Cmp .BoolOp .Modifiers .Space .Cache .Scope .Rounding .Vec .Type .SrcType
 // Example: setp.lt.and.f32, ld.global.ca.v4.f32, cvt.rn.f16.f32, cp.async.ca.shared.global
-func buildMnemonic(inst *builder.Instruction) string {
-	var sb strings.Builder
-
+func appendMnemonic(sb []byte, inst *builder.Instruction) []byte {
 	// 1. Opcode
-	sb.WriteString(inst.Op.String())
+	sb = append(sb, inst.Op.String()...)
 
 	// 2. Comparison & Boolean Operators (set, setp)
 	if inst.Op == ptx.OpSet || inst.Op == ptx.OpSetp {
 		// Only append Cmp if specific logic requires it, usually strictly required for set/setp
-		sb.WriteString(inst.Cmp.String())
+		sb = append(sb, inst.Cmp.String()...)
 
 		// Append Boolean Operator (.and, .or, .xor) if present
 		if inst.BoolOp != ptx.BoolNone {
-			sb.WriteString(inst.BoolOp.String())
+			sb = append(sb, inst.BoolOp.String()...)
 		}
 	}
 
@@ -74,126 +99,151 @@ func buildMnemonic(inst *builder.Instruction) string {
 	// Handles .wide, .lo, .hi, .sat, .ftz, .approx, .sync, .multicast, etc.
 	// Also handles explicit state space modifiers for cp.async (e.g. .shared::cluster)
 	for _, mod := range inst.Modifiers {
-		sb.WriteString(mod.String())
+		sb = append(sb, mod.String()...)
 	}
 
 	// 4. State Space
 	// Standard ld/st/atom instructions use the Space field (.global, .shared, .const, etc.)
 	// Note: We avoid printing .reg as it is the default implicit state.
 	if inst.Space != ptx.Reg && inst.Space != ptx.StateSpace(0) {
-		sb.WriteString(inst.Space.String())
+		sb = append(sb, inst.Space.String()...)
 	}
 
 	// 5. Cache Operators
 	// (.ca, .cg, .cs, .lu, .cv, .wb, .wt)
-	sb.WriteString(inst.Cache.String())
+	sb = append(sb, inst.Cache.String()...)
 
 	// 6. Scope
 	// (.cta, .gpu, .sys, .cluster)
-	sb.WriteString(inst.Scope.String())
+	sb = append(sb, inst.Scope.String()...)
 
 	// 7. Rounding Mode
 	// (.rn, .rz, .rm, .rp, .rni, .rzi, etc.)
-	sb.WriteString(inst.Rounding.String())
+	sb = append(sb, inst.Rounding.String()...)
 
 	// 8. Vector Size
 	// (.v2, .v4, .v8)
-	sb.WriteString(inst.Vec.String())
+	sb = append(sb, inst.Vec.String()...)
 
 	// 9. Types
 	// Special handling for Conversion instructions (cvt, cvt.pack) and Mixed Precision
 	if inst.Op == ptx.OpCvt || inst.Op == ptx.OpCvtPack {
 		// Destination Type (convertType)
 		if inst.Typ != 0 {
-			sb.WriteString(inst.Typ.String())
+			sb = append(sb, inst.Typ.String()...)
 		}
 		// Source Type (abType)
 		if inst.SrcType != 0 {
-			sb.WriteString(inst.SrcType.String())
+			sb = append(sb, inst.SrcType.String()...)
 		}
 		// cvt.pack 3rd type (cType) logic:
 		// Syntax: cvt.pack.sat.convertType.abType.cType d, a, b, c
 		// If 3 source operands are present (a, b, c), we append the cType.
 		// In most contexts this is .b32.
 		if inst.Op == ptx.OpCvtPack && len(inst.Src) > 2 {
-			sb.WriteString(".b32")
+			sb = append(sb, ".b32"...)
 		}
 	} else {
 		// Standard instructions (add.u32, ld.global.f32)
 		if inst.Typ != 0 {
-			sb.WriteString(inst.Typ.String())
+			sb = append(sb, inst.Typ.String()...)
 		}
 
 		// Mixed Precision / Explicit Source Type variants
 		// Examples: add.f32.f16, sub.f32.bf16
 		// Only append SrcType if it differs or is explicitly set and not handled above
 		if inst.SrcType != 0 {
-			sb.WriteString(inst.SrcType.String())
+			sb = append(sb, inst.SrcType.String()...)
 		}
 	}
 
-	return sb.String()
+	return sb
 }
 
-// buildOperands constructs the comma-separated operand string.
-func buildOperands(inst *builder.Instruction) string {
-    var ops []string
+// hasOperands reports whether inst prints any operands.
+func hasOperands(inst *builder.Instruction) bool {
+    switch inst.Op {
+    case ptx.OpCall:
+        return inst.Dst != nil || inst.CallTarget != "" || len(inst.Src) > 0
+    case ptx.OpSt, ptx.OpStAsync:
+        return len(inst.Src) > 0
+    }
+    return inst.Dst != nil || len(inst.Src) > 0
+}
 
+// appendOperands appends the operands of inst to b, separated by sep.
+func appendOperands(b []byte, inst *builder.Instruction, sep string) []byte {
     // Special case: call has different formatting
     if inst.Op == ptx.OpCall {
-        return buildCallOperands(inst)
-    }
-
-    // Special case: st has no Dst, operands are [addr], src
-    if inst.Op == ptx.OpSt || inst.Op == ptx.OpStAsync {
-        for _, s := range inst.Src {
-            ops = append(ops, emitOperand(s))
-        }
-        return strings.Join(ops, ", ")
+        return appendCallOperands(b, inst, sep)
     }
 
-    // Destination
-    if inst.Dst != nil {
-        ops = append(ops, emitOperand(inst.Dst))
+    // Destination; st has no Dst, its operands are [addr], src
+    n := 0
+    if inst.Dst != nil && inst.Op != ptx.OpSt && inst.Op != ptx.OpStAsync {
+        b = appendOperand(b, inst.Dst, sep)
+        n++
     }
 
     // Sources
     for _, s := range inst.Src {
-        ops = append(ops, emitOperand(s))
+        if n > 0 {
+            b = append(b, sep...)
+        }
+        b = appendOperand(b, s, sep)
+        n++
     }
-
-    return strings.Join(ops, ", ")
+    return b
 }
 
-// buildCallOperands handles the special call syntax:
+// appendCallOperands handles the special call syntax:
 //   call (retval), funcname, (arg0, arg1, ...);
 //   call funcname, (arg0, arg1, ...);
-func buildCallOperands(inst *builder.Instruction) string {
-    var result strings.Builder
-
+//   call (retval), %fptr, (arg0, arg1, ...), proto;
+func appendCallOperands(b []byte, inst *builder.Instruction, sep string) []byte {
     // Return values
     if inst.Dst != nil {
-        result.WriteString("(")
-        result.WriteString(emitOperand(inst.Dst))
-        result.WriteString("), ")
+        b = append(b, '(')
+        b = appendOperand(b, inst.Dst, sep)
+        b = append(b, ')')
+        b = append(b, sep...)
+    }
+
+    // Indirect call: Src is the function pointer, the arguments, then the
+    // prototype or call target list
+    args := inst.Src
+    if inst.CallTarget == "" && len(args) >= 2 {
+        b = appendOperand(b, args[0], sep)
+        args = args[1 : len(args)-1]
+        if len(args) > 0 {
+            b = append(b, sep...)
+            b = appendArgs(b, args, sep)
+        }
+        b = append(b, sep...)
+        return appendOperand(b, inst.Src[len(inst.Src)-1], sep)
     }
 
     // Function name
-    result.WriteString(inst.CallTarget)
+    b = append(b, inst.CallTarget...)
 
     // Arguments
     if len(inst.Src) > 0 {
-        result.WriteString(", (")
-        for i, arg := range inst.Src {
-            if i > 0 {
-                result.WriteString(", ")
-            }
-            result.WriteString(emitOperand(arg))
-        }
-        result.WriteString(")")
+        b = append(b, sep...)
+        b = appendArgs(b, inst.Src, sep)
     }
+    return b
+}
 
-    return result.String()
+// appendArgs appends a parenthesized call argument list.
+func appendArgs(b []byte, args []builder.Operand, sep string) []byte {
+    b = append(b, '(')
+    for i, arg := range args {
+        if i > 0 {
+            b = append(b, sep...)
+        }
+        b = appendOperand(b, arg, sep)
+    }
+    return append(b, ')')
 }
 
 // stripDot removes the leading dot from a type string for cvt dual-type syntax.
@@ -205,10 +255,15 @@ func stripDot(s string) string {
     return s
 }
 
-// padTo pads a string with spaces to reach the target length.
-func padTo(s string, target int) string {
-    if len(s) >= target {
-        return s + " "
+// padTo pads b with spaces so the text after start reaches the target
+// length, always leaving at least one space. A target of 0 gives exactly
+// one space.
+func padTo(b []byte, start, target int) []byte {
+    if len(b)-start >= target {
+        return append(b, ' ')
+    }
+    for len(b)-start < target {
+        b = append(b, ' ')
     }
-    return s + strings.Repeat(" ", target-len(s))
-}
\ No newline at end of file
+    return b
+}
@@ -12,6 +12,11 @@ func (e *Emitter) emitModule(mod *builder.Module) {
     e.linef(".address_size %d", mod.AddressSize)
     e.blank()
 
+    // Source files for .loc
+    if !e.opts.StripLineInfo {
+        e.emitFiles(mod)
+    }
+
     // Module-scope globals
     for _, g := range mod.Globals {
         e.emitGlobal(g)
@@ -22,9 +27,19 @@ func (e *Emitter) emitModule(mod *builder.Module) {
 
     // Functions and kernels
     for i, f := range mod.Functions {
+        if e.failed() {
+            return
+        }
         if i > 0 {
             e.blank()
         }
         e.emitFunction(f)
     }
+
+    if e.opts.DebugInfoSection {
+        e.blank()
+        e.line(".section .debug_info")
+        e.line("{")
+        e.line("}")
+    }
 }
\ No newline at end of file
@@ -1,91 +1,103 @@
 package codegen
 
 import (
-    "fmt"
-    "math"
-    "strings"
+	"fmt"
+	"math"
+	"strconv"
 
-    "github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/builder"
 )
 
-// emitOperand formats a single operand to its PTX text representation.
-func emitOperand(op builder.Operand) string {
-    switch o := op.(type) {
-    case *builder.Register:
-        return o.Name
+// appendOperand appends the PTX text of a single operand to b, separating
+// vector elements with sep.
+func appendOperand(b []byte, op builder.Operand, sep string) []byte {
+	switch o := op.(type) {
+	case *builder.Register:
+		return append(b, o.Name...)
 
-    case *builder.Immediate:
-        return emitImmediate(o)
+	case *builder.Immediate:
+		return appendImmediate(b, o)
 
-    case *builder.Symbol:
-        return o.Name
+	case *builder.Symbol:
+		return append(b, o.Name...)
 
-    case *builder.Address:
-        return emitAddress(o)
+	case *builder.Address:
+		return appendAddress(b, o, sep)
 
-    case *builder.VectorOp:
-        return emitVector(o)
+	case *builder.VectorOp:
+		return appendVector(b, o, sep)
 
-    case *builder.SpecialRegOp:
-        return o.Reg.String()
+	case *builder.SpecialRegOp:
+		return append(b, o.Reg.String()...)
 
-    default:
-        return "???"
-    }
+	default:
+		return append(b, "???"...)
+	}
 }
 
-// emitImmediate formats an immediate value.
+// appendImmediate appends an immediate value.
 //
 // Integers:  42, -1, 0xFF
 // Float32:   0f3F800000  (PTX hex float format)
 // Float64:   0d3FF0000000000000
-func emitImmediate(imm *builder.Immediate) string {
-    switch v := imm.Value.(type) {
-    case int:
-        return fmt.Sprintf("%d", v)
-    case int32:
-        return fmt.Sprintf("%d", v)
-    case int64:
-        return fmt.Sprintf("%d", v)
-    case uint32:
-        return fmt.Sprintf("%d", v)
-    case uint64:
-        return fmt.Sprintf("%d", v)
-    case float32:
-        // PTX hex float: 0fXXXXXXXX
-        bits := math.Float32bits(v)
-        return fmt.Sprintf("0f%08X", bits)
-    case float64:
-        // PTX hex float: 0dXXXXXXXXXXXXXXXX
-        bits := math.Float64bits(v)
-        return fmt.Sprintf("0d%016X", bits)
-    default:
-        return fmt.Sprintf("%v", v)
-    }
+func appendImmediate(b []byte, imm *builder.Immediate) []byte {
+	switch v := imm.Value.(type) {
+	case int:
+		return strconv.AppendInt(b, int64(v), 10)
+	case int32:
+		return strconv.AppendInt(b, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(b, v, 10)
+	case uint32:
+		return strconv.AppendUint(b, uint64(v), 10)
+	case uint64:
+		return strconv.AppendUint(b, v, 10)
+	case float32:
+		// PTX hex float: 0fXXXXXXXX
+		return appendHex(append(b, "0f"...), uint64(math.Float32bits(v)), 8)
+	case float64:
+		// PTX hex float: 0dXXXXXXXXXXXXXXXX
+		return appendHex(append(b, "0d"...), math.Float64bits(v), 16)
+	default:
+		return fmt.Append(b, v)
+	}
 }
 
-// emitAddress formats a memory address operand.
+// appendHex appends v as exactly digits upper-case hex digits.
+func appendHex(b []byte, v uint64, digits int) []byte {
+	const hex = "0123456789ABCDEF"
+	for i := digits - 1; i >= 0; i-- {
+		b = append(b, hex[(v>>(4*uint(i)))&0xF])
+	}
+	return b
+}
+
+// appendAddress appends a memory address operand.
 //
 // [%rd0]         — register base, zero offset
 // [%rd0+8]       — register base with offset
 // [paramName]    — symbol (parameter) base
 // [paramName+16] — symbol with offset
-func emitAddress(addr *builder.Address) string {
-    base := emitOperand(addr.Base)
-    if addr.Offset == 0 {
-        return fmt.Sprintf("[%s]", base)
-    }
-    if addr.Offset > 0 {
-        return fmt.Sprintf("[%s+%d]", base, addr.Offset)
-    }
-    return fmt.Sprintf("[%s%d]", base, addr.Offset) // negative offset
+func appendAddress(b []byte, addr *builder.Address, sep string) []byte {
+	b = append(b, '[')
+	b = appendOperand(b, addr.Base, sep)
+	if addr.Offset > 0 {
+		b = append(b, '+')
+	}
+	if addr.Offset != 0 {
+		b = strconv.AppendInt(b, addr.Offset, 10) // negative offsets carry their sign
+	}
+	return append(b, ']')
 }
 
-// emitVector formats a vector operand {r0, r1, r2, r3}.
-func emitVector(vec *builder.VectorOp) string {
-    elems := make([]string, len(vec.Elements))
-    for i, el := range vec.Elements {
-        elems[i] = emitOperand(el)
-    }
-    return "{" + strings.Join(elems, ", ") + "}"
-}
\ No newline at end of file
+// appendVector appends a vector operand {r0, r1, r2, r3}.
+func appendVector(b []byte, vec *builder.VectorOp, sep string) []byte {
+	b = append(b, '{')
+	for i, el := range vec.Elements {
+		if i > 0 {
+			b = append(b, sep...)
+		}
+		b = appendOperand(b, el, sep)
+	}
+	return append(b, '}')
+}
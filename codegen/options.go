@@ -0,0 +1,195 @@
+package codegen
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+
+	"github.com/arc-language/ptx-gen/builder"
+)
+
+// EmitOptions controls the layout of emitted PTX. Start from
+// DefaultEmitOptions, which reproduces the output of Emit.
+type EmitOptions struct {
+	// Indent is written once per nesting level.
+	Indent string
+
+	// MnemonicWidth is the column operands start at, counted from the
+	// start of the guard or mnemonic; longer mnemonics are followed by a
+	// single space. 0 always uses a single space.
+	MnemonicWidth int
+
+	// Compact minifies the output: no indentation, padding or blank lines,
+	// one-line parameter lists, and no space after commas. It overrides
+	// Indent, MnemonicWidth and BlankBetweenBlocks.
+	Compact bool
+
+	// BlankBetweenBlocks puts an empty line before every basic block but
+	// the first.
+	BlankBetweenBlocks bool
+
+	// SortRegisters declares registers grouped in type order, with the
+	// names of each type in natural order (%r2 before %r10), instead of in
+	// the order they were created.
+	SortRegisters bool
+
+	// Banner, if not empty, is written as a comment at the top of the file,
+	// e.g. "Generated by ptx-gen".
+	Banner string
+
+	// StripComments drops every comment attached to functions, blocks and
+	// instructions, and every standalone comment, e.g. for release builds.
+	// The banner and hash header are not affected.
+	StripComments bool
+
+	// StripLineInfo drops .file and .loc directives.
+	StripLineInfo bool
+
+	// DebugInfoSection appends an empty .section .debug_info, which some
+	// tools expect alongside .loc line info.
+	DebugInfoSection bool
+
+	// Hash adds a comment at the top of the file with the SHA-256 of the
+	// rest of the output, so regenerated files can be compared cheaply.
+	Hash bool
+}
+
+// DefaultEmitOptions returns the options Emit and EmitTo use: tab
+// indentation, a 15-column mnemonic, blank lines between blocks, and
+// registers declared in creation order.
+func DefaultEmitOptions() EmitOptions {
+	return EmitOptions{
+		Indent:             "\t",
+		MnemonicWidth:      15,
+		BlankBetweenBlocks: true,
+	}
+}
+
+// EmitWithOptions is like Emit with the layout given by opts.
+func EmitWithOptions(mod *builder.Module, opts EmitOptions) string {
+	var sb strings.Builder
+	EmitToWithOptions(&sb, mod, opts) // a strings.Builder never fails
+	return sb.String()
+}
+
+// EmitToWithOptions is like EmitTo with the layout given by opts. When
+// opts.Hash is set the module is emitted twice, first to hash it.
+func EmitToWithOptions(w io.Writer, mod *builder.Module, opts EmitOptions) error {
+	if opts.Compact {
+		opts.Indent, opts.MnemonicWidth, opts.BlankBetweenBlocks = "", 0, false
+	}
+	var sum []byte
+	if opts.Hash {
+		h := sha256.New()
+		body := opts
+		body.Banner, body.Hash = "", false
+		if err := EmitToWithOptions(h, mod, body); err != nil {
+			return err
+		}
+		sum = h.Sum(nil)
+	}
+
+	e := newEmitter(w, opts)
+	e.emitHeader(sum)
+	e.emitModule(mod)
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	return e.err
+}
+
+func newEmitter(w io.Writer, opts EmitOptions) *Emitter {
+	e := &Emitter{w: bufio.NewWriterSize(w, bufferSize), opts: opts, sep: ", "}
+	if opts.Compact {
+		e.sep = ","
+	}
+	return e
+}
+
+// emitHeader writes the banner and content hash comment, if requested.
+//
+// Output example:
+//
+//	//
+//	// Generated by ptx-gen
+//	// sha256: 3a7bd3e2360a3d29eea436fcfb7e44c735d117c42d1c1835420b6b9942dd4f1b
+//	//
+func (e *Emitter) emitHeader(sum []byte) {
+	if e.opts.Banner == "" && sum == nil {
+		return
+	}
+	e.line("//")
+	for _, l := range strings.Split(e.opts.Banner, "\n") {
+		if l != "" {
+			e.line("// " + l)
+		}
+	}
+	if sum != nil {
+		e.line("// sha256: " + hex.EncodeToString(sum))
+	}
+	e.line("//")
+	e.blank()
+}
+
+// emitComment writes text as one indented "//" line per line of text.
+func (e *Emitter) emitComment(text string) {
+	if text == "" || e.opts.StripComments {
+		return
+	}
+	for _, l := range strings.Split(text, "\n") {
+		e.line(strings.TrimRight("// "+l, " "))
+	}
+}
+
+// appendTrailingComment appends " // text" to a line held in b. Comments
+// spanning several lines cannot trail and are written by the caller with
+// emitComment instead.
+func (e *Emitter) appendTrailingComment(b []byte, text string) []byte {
+	if text == "" || e.opts.StripComments || strings.Contains(text, "\n") {
+		return b
+	}
+	if !e.opts.Compact {
+		b = append(b, ' ')
+	}
+	b = append(b, " // "...)
+	return append(b, text...)
+}
+
+// naturalLess orders register names so that numeric suffixes compare by
+// value: %r2 sorts before %r10.
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			i, j := digitRun(a), digitRun(b)
+			na, nb := strings.TrimLeft(a[:i], "0"), strings.TrimLeft(b[:j], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			if i != j {
+				return i < j
+			}
+			a, b = a[i:], b[j:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func digitRun(s string) int {
+	n := 0
+	for n < len(s) && isDigit(s[n]) {
+		n++
+	}
+	return n
+}
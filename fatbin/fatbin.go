@@ -0,0 +1,299 @@
+// Package fatbin writes and reads CUDA fatbinary containers, the format nvcc
+// embeds in host objects and cuModuleLoadFatBinary accepts. A container
+// holds images for several architectures, and the driver loads the best
+// match for the device, JIT-compiling PTX when there is no cubin for it.
+//
+// The layout follows the publicly documented structure: a 16-byte
+// container header (magic 0xBA55ED50, version 1, header size, size of the
+// entries) followed by entries, each a 64-byte header (kind, header size,
+// payload size, PTX version, SM architecture, flags, sizes for compressed
+// payloads) and its payload, padded to 8 bytes. Compressed payloads are LZ4
+// blocks.
+package fatbin
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/codegen"
+)
+
+// Magic starts every fatbinary container.
+const Magic = 0xBA55ED50
+
+// Kind is the kind of image an entry holds.
+type Kind uint16
+
+const (
+	KindPTX Kind = 1 // PTX text
+	KindELF Kind = 2 // cubin
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindPTX:
+		return "ptx"
+	case KindELF:
+		return "elf"
+	}
+	return "kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Entry flags.
+const (
+	Flag64Bit      = 0x0001 // 64-bit addressing
+	FlagDebug      = 0x0002 // built with debug information
+	FlagLinux      = 0x0010 // host is Linux
+	FlagCompressed = 0x2000 // payload is compressed; set by Marshal
+)
+
+const (
+	containerVersion  = 1
+	containerHeader   = 16
+	entryVersion      = 0x0101
+	entryHeader       = 64
+	payloadAlignment  = 8
+	maxEntryNameBytes = 1 << 16
+)
+
+// Entry is one image in a container.
+//
+// The entry header records only the SM number, so sm_90 and sm_90a share
+// Arch 90. ArchSpecific tells them apart: FromModule sets it from the
+// target, and Parse from the .target directive of PTX text. It is not
+// stored in the header, so it is false for parsed cubins.
+type Entry struct {
+	Kind         Kind
+	Arch         int    // SM number: 90 for sm_90 and sm_90a
+	ArchSpecific bool   // built for the arch-specific target, such as sm_90a
+	Major        int    // PTX ISA version of a PTX entry, major part
+	Minor        int    // and minor part
+	Flags        uint64 // Flag64Bit, FlagDebug, ...
+	Name         string // optional object name
+	Data         []byte // the image, uncompressed; PTX text has no terminating NUL
+}
+
+// Compressed reports whether the entry was stored compressed.
+func (e Entry) Compressed() bool {
+	return e.Flags&FlagCompressed != 0
+}
+
+func (e Entry) String() string {
+	s := fmt.Sprintf("%s sm_%d", e.Kind, e.Arch)
+	if e.ArchSpecific {
+		s += "a"
+	}
+	if e.Kind == KindPTX {
+		s += fmt.Sprintf(" isa %d.%d", e.Major, e.Minor)
+	}
+	s += fmt.Sprintf(" %d bytes", len(e.Data))
+	if e.Compressed() {
+		s += " (compressed)"
+	}
+	if e.Name != "" {
+		s += " " + e.Name
+	}
+	return s
+}
+
+// FromModule emits mod and returns it as a PTX entry for its target.
+func FromModule(mod *builder.Module) Entry {
+	arch, specific := parseArch(mod.Target.String())
+	e := Entry{
+		Kind:         KindPTX,
+		Arch:         arch,
+		ArchSpecific: specific,
+		Major:        mod.Version.Major,
+		Minor:        mod.Version.Minor,
+		Data:         []byte(codegen.Emit(mod)),
+	}
+	if mod.AddressSize == 64 {
+		e.Flags |= Flag64Bit
+	}
+	return e
+}
+
+// parseArch returns the SM number of a target name such as sm_90a, and
+// whether it is arch-specific.
+func parseArch(target string) (int, bool) {
+	name := strings.TrimPrefix(target, "sm_")
+	digits := strings.TrimRightFunc(name, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	n, _ := strconv.Atoi(digits)
+	return n, strings.HasSuffix(name, "a")
+}
+
+// ptxTarget returns the target named by the .target directive of PTX text.
+func ptxTarget(text []byte) string {
+	for _, line := range strings.Split(string(text), "\n") {
+		if t, ok := strings.CutPrefix(strings.TrimSpace(line), ".target "); ok {
+			return strings.TrimSpace(strings.Split(t, ",")[0])
+		}
+	}
+	return ""
+}
+
+// Bundle emits each module and packs them into one container as PTX
+// entries, in order.
+func Bundle(compress bool, mods ...*builder.Module) ([]byte, error) {
+	entries := make([]Entry, len(mods))
+	for i, mod := range mods {
+		if mod == nil {
+			return nil, fmt.Errorf("fatbin: module %d is nil", i)
+		}
+		entries[i] = FromModule(mod)
+	}
+	return Marshal(entries, compress)
+}
+
+// Marshal packs entries into a container. With compress set every payload
+// is stored compressed and FlagCompressed is set; otherwise it is cleared.
+// PTX text is stored with a terminating NUL, as the driver expects.
+func Marshal(entries []Entry, compress bool) ([]byte, error) {
+	out := make([]byte, containerHeader)
+	for i, e := range entries {
+		if len(e.Name) >= maxEntryNameBytes {
+			return nil, fmt.Errorf("fatbin: entry %d: name too long", i)
+		}
+		if e.Arch < 0 || e.Major < 0 || e.Major > 0xFFFF || e.Minor < 0 || e.Minor > 0xFFFF {
+			return nil, fmt.Errorf("fatbin: entry %d: bad architecture or version", i)
+		}
+		out = appendEntry(out, e, compress)
+	}
+	binary.LittleEndian.PutUint32(out[0:], Magic)
+	binary.LittleEndian.PutUint16(out[4:], containerVersion)
+	binary.LittleEndian.PutUint16(out[6:], containerHeader)
+	binary.LittleEndian.PutUint64(out[8:], uint64(len(out)-containerHeader))
+	return out, nil
+}
+
+func appendEntry(out []byte, e Entry, compress bool) []byte {
+	data := e.Data
+	if e.Kind == KindPTX {
+		data = append(data[:len(data):len(data)], 0)
+	}
+	flags := e.Flags &^ FlagCompressed
+	var compressedSize, uncompressedSize int
+	payload := data
+	if compress {
+		flags |= FlagCompressed
+		payload = lz4Compress(data)
+		compressedSize, uncompressedSize = len(payload), len(data)
+	}
+
+	headerSize := entryHeader
+	if e.Name != "" {
+		headerSize = alignUp(entryHeader+len(e.Name)+1, payloadAlignment)
+	}
+	h := make([]byte, headerSize)
+	binary.LittleEndian.PutUint16(h[0:], uint16(e.Kind))
+	binary.LittleEndian.PutUint16(h[2:], entryVersion)
+	binary.LittleEndian.PutUint32(h[4:], uint32(headerSize))
+	binary.LittleEndian.PutUint64(h[8:], uint64(alignUp(len(payload), payloadAlignment)))
+	binary.LittleEndian.PutUint32(h[16:], uint32(compressedSize))
+	binary.LittleEndian.PutUint16(h[24:], uint16(e.Minor))
+	binary.LittleEndian.PutUint16(h[26:], uint16(e.Major))
+	binary.LittleEndian.PutUint32(h[28:], uint32(e.Arch))
+	if e.Name != "" {
+		binary.LittleEndian.PutUint32(h[32:], entryHeader)
+		binary.LittleEndian.PutUint32(h[36:], uint32(len(e.Name)))
+		copy(h[entryHeader:], e.Name)
+	}
+	binary.LittleEndian.PutUint64(h[40:], flags)
+	binary.LittleEndian.PutUint64(h[56:], uint64(uncompressedSize))
+
+	out = append(out, h...)
+	out = append(out, payload...)
+	return append(out, make([]byte, alignUp(len(payload), payloadAlignment)-len(payload))...)
+}
+
+// Parse returns the entries of a container, with their payloads
+// decompressed and PTX text without its terminating NUL. An uncompressed
+// cubin keeps the padding after it, since the header does not record the
+// unpadded size.
+func Parse(data []byte) ([]Entry, error) {
+	if len(data) < containerHeader {
+		return nil, errors.New("fatbin: too short for a container header")
+	}
+	if m := binary.LittleEndian.Uint32(data[0:]); m != Magic {
+		return nil, fmt.Errorf("fatbin: bad magic %#08x", m)
+	}
+	if v := binary.LittleEndian.Uint16(data[4:]); v != containerVersion {
+		return nil, fmt.Errorf("fatbin: unsupported container version %d", v)
+	}
+	hsize := int(binary.LittleEndian.Uint16(data[6:]))
+	size := binary.LittleEndian.Uint64(data[8:])
+	if hsize < containerHeader || hsize > len(data) || size > uint64(len(data)-hsize) {
+		return nil, errors.New("fatbin: container header does not match its size")
+	}
+
+	body := data[hsize : hsize+int(size)]
+	var entries []Entry
+	for off := 0; off < len(body); {
+		e, n, err := parseEntry(body[off:])
+		if err != nil {
+			return nil, fmt.Errorf("fatbin: entry %d at offset %d: %v", len(entries), hsize+off, err)
+		}
+		entries = append(entries, e)
+		off += n
+	}
+	return entries, nil
+}
+
+// parseEntry decodes the entry at the start of b and returns its length.
+func parseEntry(b []byte) (Entry, int, error) {
+	if len(b) < entryHeader {
+		return Entry{}, 0, errors.New("truncated header")
+	}
+	hsize := int(binary.LittleEndian.Uint32(b[4:]))
+	psize := binary.LittleEndian.Uint64(b[8:])
+	if hsize < entryHeader || hsize > len(b) || psize > uint64(len(b)-hsize) {
+		return Entry{}, 0, errors.New("header does not match its size")
+	}
+	e := Entry{
+		Kind:  Kind(binary.LittleEndian.Uint16(b[0:])),
+		Minor: int(binary.LittleEndian.Uint16(b[24:])),
+		Major: int(binary.LittleEndian.Uint16(b[26:])),
+		Arch:  int(binary.LittleEndian.Uint32(b[28:])),
+		Flags: binary.LittleEndian.Uint64(b[40:]),
+	}
+	if nameOff, nameLen := int(binary.LittleEndian.Uint32(b[32:])), int(binary.LittleEndian.Uint32(b[36:])); nameLen > 0 {
+		if nameOff < 0 || nameLen > hsize || nameOff > hsize-nameLen {
+			return Entry{}, 0, errors.New("name outside the header")
+		}
+		e.Name = strings.TrimRight(string(b[nameOff:nameOff+nameLen]), "\x00")
+	}
+
+	payload := b[hsize : hsize+int(psize)]
+	if e.Compressed() {
+		csize := int(binary.LittleEndian.Uint32(b[16:]))
+		usize := binary.LittleEndian.Uint64(b[56:])
+		if csize > len(payload) || usize > uint64(csize)*maxExpansion {
+			return Entry{}, 0, errors.New("bad compressed size")
+		}
+		data, err := lz4Decompress(payload[:csize], int(usize))
+		if err != nil {
+			return Entry{}, 0, err
+		}
+		payload = data
+	}
+	if e.Kind == KindPTX {
+		payload = []byte(strings.TrimRight(string(payload), "\x00"))
+		if arch, specific := parseArch(ptxTarget(payload)); arch == e.Arch {
+			e.ArchSpecific = specific
+		}
+	} else {
+		payload = append([]byte(nil), payload...)
+	}
+	e.Data = payload
+	return e, hsize + int(psize), nil
+}
+
+func alignUp(n, align int) int {
+	return (n + align - 1) / align * align
+}
@@ -0,0 +1,208 @@
+package fatbin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testModule builds a small kernel for target.
+func testModule(version ptx.ISAVersion, target ptx.Target) *builder.Module {
+	mod := builder.NewModule(version, target)
+	k := mod.NewKernel("scale")
+	k.AddParam(builder.NewPtrParam("x", ptx.Global))
+	k.AddParam(builder.NewParam("alpha", ptx.F32))
+	p := k.NewReg("p", ptx.U64)
+	v := k.NewReg("v", ptx.F32)
+	a := k.NewReg("a", ptx.F32)
+	bb := k.NewBlock("")
+	bb.Add(builder.Ld(p, k.Param("x")).Typed(ptx.U64).InSpace(ptx.Param))
+	bb.Add(builder.Ld(a, k.Param("alpha")).Typed(ptx.F32).InSpace(ptx.Param))
+	bb.Add(builder.Ld(v, builder.Addr(p, 0)).Typed(ptx.F32).InSpace(ptx.Global))
+	bb.Add(builder.Mul(v, v, a).Typed(ptx.F32))
+	bb.Add(builder.St(builder.Addr(p, 0), v).Typed(ptx.F32).InSpace(ptx.Global))
+	bb.Add(builder.Ret())
+	return mod
+}
+
+// testEntries returns PTX entries for sm_80 and sm_90a and a named cubin.
+func testEntries() []Entry {
+	cubin := Entry{Kind: KindELF, Arch: 80, Flags: Flag64Bit | FlagLinux, Name: "scale.cubin",
+		Data: append([]byte("\x7fELF"), bytes.Repeat([]byte{0, 1, 2, 3}, 30)...)}
+	return []Entry{
+		FromModule(testModule(ptx.ISA70, ptx.SM80)),
+		FromModule(testModule(ptx.ISA80, ptx.SM90a)),
+		cubin,
+	}
+}
+
+func TestGolden(t *testing.T) {
+	for _, tt := range []struct {
+		file     string
+		compress bool
+	}{
+		{"bundle.fatbin", false},
+		{"bundle_lz4.fatbin", true},
+	} {
+		got, err := Marshal(testEntries(), tt.compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join("testdata", tt.file)
+		if *update {
+			if err := os.WriteFile(path, got, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: Marshal output differs from the golden file; rerun with -update if the change is intended", tt.file)
+		}
+	}
+}
+
+func TestParseGolden(t *testing.T) {
+	want := testEntries()
+	for _, file := range []string{"bundle.fatbin", "bundle_lz4.fatbin"} {
+		data, err := os.ReadFile(filepath.Join("testdata", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := Parse(data)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if len(entries) != len(want) {
+			t.Fatalf("%s: %d entries, want %d", file, len(entries), len(want))
+		}
+		compressed := strings.Contains(file, "lz4")
+		for i, e := range entries {
+			w := want[i]
+			if e.Kind != w.Kind || e.Arch != w.Arch || e.ArchSpecific != w.ArchSpecific ||
+				e.Major != w.Major || e.Minor != w.Minor || e.Name != w.Name || e.Compressed() != compressed {
+				t.Errorf("%s: entry %d is %v, want %v", file, i, e, w)
+			}
+			if e.Kind == KindPTX && !bytes.Equal(e.Data, w.Data) {
+				t.Errorf("%s: entry %d: PTX text differs", file, i)
+			}
+			if e.Kind == KindELF && !bytes.HasPrefix(e.Data, w.Data) {
+				t.Errorf("%s: entry %d: cubin differs", file, i)
+			}
+		}
+		if got := entries[1].String(); !strings.HasPrefix(got, "ptx sm_90a isa 8.0 ") {
+			t.Errorf("%s: entry 1 is %q, want an sm_90a PTX entry", file, got)
+		}
+
+		again, err := Marshal(entries, compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !compressed {
+			// Parsed cubins keep their padding, which Marshal pads no further.
+			if !bytes.Equal(again, data) {
+				t.Errorf("%s: Marshal(Parse(data)) differs from data", file)
+			}
+		}
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		data, err := Marshal(testEntries(), compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < len(data); n++ {
+			if _, err := Parse(data[:n]); err == nil {
+				t.Fatalf("compress=%v: Parse accepted a container truncated to %d of %d bytes", compress, n, len(data))
+			}
+		}
+	}
+}
+
+func TestParseCorrupt(t *testing.T) {
+	data, err := Marshal(testEntries()[:1], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := data[containerHeader:]
+
+	bad := bytes.Clone(data)
+	bad[0] ^= 0xFF
+	if _, err := Parse(bad); err == nil {
+		t.Error("Parse accepted a bad magic number")
+	}
+
+	// An uncompressed size beyond what the payload can expand to is
+	// rejected before anything is allocated.
+	bad = bytes.Clone(data)
+	csize := binary.LittleEndian.Uint32(entry[16:])
+	binary.LittleEndian.PutUint64(bad[containerHeader+56:], uint64(csize)*maxExpansion+1)
+	if _, err := Parse(bad); err == nil {
+		t.Error("Parse accepted an uncompressed size beyond the LZ4 limit")
+	}
+
+	bad = bytes.Clone(data)
+	binary.LittleEndian.PutUint64(bad[containerHeader+8:], 1<<40)
+	if _, err := Parse(bad); err == nil {
+		t.Error("Parse accepted a payload size beyond the container")
+	}
+
+	// Random damage to the payload must be reported or survived, never
+	// panic.
+	rng := rand.New(rand.NewSource(1))
+	hsize := int(binary.LittleEndian.Uint32(entry[4:]))
+	for i := 0; i < 2000; i++ {
+		bad = bytes.Clone(data)
+		for j := 0; j < 1+rng.Intn(4); j++ {
+			bad[containerHeader+hsize+rng.Intn(int(csize))] = byte(rng.Intn(256))
+		}
+		Parse(bad)
+	}
+}
+
+func TestLZ4RoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	inputs := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("short"),
+		bytes.Repeat([]byte{0}, 100000),
+		bytes.Repeat([]byte("ld.global.f32 %f1, [%rd1];\n"), 500),
+	}
+	for i := 0; i < 50; i++ {
+		b := make([]byte, rng.Intn(5000))
+		for j := range b {
+			b[j] = byte('a' + rng.Intn(1+i%8))
+		}
+		inputs = append(inputs, b)
+	}
+	for i, in := range inputs {
+		c := lz4Compress(in)
+		if len(in) > len(c)*maxExpansion {
+			t.Errorf("input %d: %d bytes compressed to %d, beyond the expansion limit", i, len(in), len(c))
+		}
+		out, err := lz4Decompress(c, len(in))
+		if err != nil {
+			t.Errorf("input %d: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(out, in) {
+			t.Errorf("input %d: round trip differs", i)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package fatbin
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Compressed entries hold an LZ4 block: a series of sequences, each a
+// token (literal length << 4 | match length - 4), extended lengths in
+// bytes of 255, the literals, and a 2-byte little-endian match offset. The
+// last sequence has literals only.
+
+const (
+	minMatch     = 4
+	lastLiterals = 5  // the block ends with at least this many literals
+	matchLimit   = 12 // no match may start within this many bytes of the end
+	maxOffset    = 65535
+	hashLog      = 16
+	maxExpansion = 255 // an LZ4 block decodes to at most this many bytes per input byte
+)
+
+// lz4Compress returns src as an LZ4 block.
+func lz4Compress(src []byte) []byte {
+	dst := make([]byte, 0, len(src)/2+16)
+	anchor := 0
+	if len(src) > matchLimit {
+		var table [1 << hashLog]int32 // position+1 of the last 4 bytes with each hash
+		limit := len(src) - matchLimit
+		end := len(src) - lastLiterals
+		for i := 0; i < limit; {
+			seq := binary.LittleEndian.Uint32(src[i:])
+			h := (seq * 2654435761) >> (32 - hashLog)
+			cand := int(table[h]) - 1
+			table[h] = int32(i + 1)
+			if cand < 0 || i-cand > maxOffset || binary.LittleEndian.Uint32(src[cand:]) != seq {
+				i++
+				continue
+			}
+			n := minMatch
+			for i+n < end && src[cand+n] == src[i+n] {
+				n++
+			}
+			dst = appendSequence(dst, src[anchor:i], i-cand, n)
+			i += n
+			anchor = i
+		}
+	}
+	return appendSequence(dst, src[anchor:], 0, 0)
+}
+
+// appendSequence appends one sequence; a zero offset makes it the last,
+// literal-only sequence.
+func appendSequence(dst, literals []byte, offset, match int) []byte {
+	lit := len(literals)
+	token := byte(min(lit, 15)) << 4
+	if offset > 0 {
+		token |= byte(min(match-minMatch, 15))
+	}
+	dst = append(dst, token)
+	if lit >= 15 {
+		dst = appendLength(dst, lit-15)
+	}
+	dst = append(dst, literals...)
+	if offset == 0 {
+		return dst
+	}
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(offset))
+	if match-minMatch >= 15 {
+		dst = appendLength(dst, match-minMatch-15)
+	}
+	return dst
+}
+
+func appendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+var errCorrupt = errors.New("fatbin: corrupt compressed entry")
+
+// lz4Decompress expands an LZ4 block that decodes to exactly size bytes.
+func lz4Decompress(src []byte, size int) ([]byte, error) {
+	dst := make([]byte, 0, size)
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+		lit := int(token >> 4)
+		if lit == 15 {
+			n, next, err := readLength(src, i)
+			if err != nil {
+				return nil, err
+			}
+			lit += n
+			i = next
+		}
+		if lit > len(src)-i || len(dst)+lit > size {
+			return nil, errCorrupt
+		}
+		dst = append(dst, src[i:i+lit]...)
+		i += lit
+		if i == len(src) {
+			break // last sequence
+		}
+
+		if i+2 > len(src) {
+			return nil, errCorrupt
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+		match := int(token&15) + minMatch
+		if token&15 == 15 {
+			n, next, err := readLength(src, i)
+			if err != nil {
+				return nil, err
+			}
+			match += n
+			i = next
+		}
+		if offset == 0 || offset > len(dst) || len(dst)+match > size {
+			return nil, errCorrupt
+		}
+		// Byte by byte: the match may overlap the bytes it produces.
+		from := len(dst) - offset
+		for j := 0; j < match; j++ {
+			dst = append(dst, dst[from+j])
+		}
+	}
+	if len(dst) != size {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
+
+func readLength(src []byte, i int) (n, next int, err error) {
+	for {
+		if i >= len(src) {
+			return 0, 0, errCorrupt
+		}
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, nil
+		}
+	}
+}
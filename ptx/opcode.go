@@ -232,6 +232,12 @@ const (
 	OpTcgen05Commit
 	OpTcgen05Wait
 	OpTcgen05Fence
+
+	// Pseudo-instructions
+	OpComment    // a "//" comment line; not a PTX instruction
+	OpScopeBegin // "{" opening a nested scope
+	OpScopeEnd   // "}" closing a nested scope
+	OpDecl       // a variable declaration in the enclosing scope
 )
 
 func (o Opcode) String() string {
@@ -656,6 +662,15 @@ func (o Opcode) String() string {
 	case OpTcgen05Fence:
 		return "tcgen05.fence"
 
+	// Pseudo-instructions
+	case OpComment:
+		return "//"
+	case OpScopeBegin:
+		return "{"
+	case OpScopeEnd:
+		return "}"
+	case OpDecl:
+		return "decl"
 	default:
 		return "unknown"
 	}
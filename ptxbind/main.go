@@ -0,0 +1,111 @@
+// Command ptxbind writes Go host bindings for the kernels of a generated
+// PTX module: the PTX text, one value per kernel with a typed PackArgs that
+// lays out its argument buffer, and a CheckBlock against .reqntid/.maxntid.
+// The output imports only the standard library and builds without cgo.
+//
+// The module comes from a generator package that exports a function
+// returning it:
+//
+//	package gen
+//
+//	func Module() *builder.Module { ... }
+//
+// and the bindings are made with a go:generate line in the host package:
+//
+//	//go:generate go run github.com/arc-language/ptx-gen/ptxbind -gen example.com/app/gen -o kernels_ptx.go
+//
+// ptxbind builds and runs a small program in the current module that calls
+// the generator, so the generator's dependencies are resolved as usual.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const program = `package main
+
+import (
+	"fmt"
+	"os"
+
+	gen %q
+	"github.com/arc-language/ptx-gen/ptxgen"
+)
+
+func main() {
+	src, err := ptxgen.GoBindings(gen.%s(), %q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(src)
+}
+`
+
+func main() {
+	genPkg := flag.String("gen", "", "import path of the generator package (required)")
+	genFunc := flag.String("func", "Module", "generator function returning the *builder.Module")
+	pkg := flag.String("pkg", os.Getenv("GOPACKAGE"), "package name of the output file (default $GOPACKAGE)")
+	out := flag.String("o", "kernels_ptx.go", "output file")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ptxbind -gen importpath [-func Module] [-pkg name] [-o file]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	switch {
+	case *genPkg == "":
+		flag.Usage()
+		os.Exit(2)
+	case !token.IsIdentifier(*genFunc):
+		fatalf("invalid generator function %q", *genFunc)
+	case *pkg == "":
+		fatalf("no package name: set -pkg or run from go:generate")
+	}
+
+	src, err := run(*genPkg, *genFunc, *pkg)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// run writes the helper program into a temporary directory under the
+// current one, so that it belongs to the current module, and returns what
+// it prints.
+func run(genPkg, genFunc, pkg string) ([]byte, error) {
+	dir, err := os.MkdirTemp(".", "_ptxbind")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(file, []byte(fmt.Sprintf(program, genPkg, genFunc, pkg)), 0o644); err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("go", "run", file)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0")
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running generator %s.%s: %v\n%s", genPkg, genFunc, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ptxbind: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testGenerator = `package gen
+
+import (
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+func Module() *builder.Module {
+	mod := builder.NewModule(ptx.ISA80, ptx.SM80)
+	k := mod.NewKernel("scale")
+	k.AddParam(builder.NewPtrParam("x", ptx.Global))
+	k.AddParam(builder.NewParam("n", ptx.U32))
+	k.AddParam(builder.NewParam("alpha", ptx.F32))
+	k.AddDirective(builder.ReqNTid(128))
+	k.NewBlock("").Add(builder.Ret())
+	return mod
+}
+`
+
+// testHost uses the generated bindings, so building it checks them.
+const testHost = `package host
+
+//go:generate ptxbind -gen example.com/host/gen
+
+func Launch(x DevicePtr) ([]byte, error) {
+	if err := Scale.CheckBlock(128, 1, 1); err != nil {
+		return nil, err
+	}
+	_ = PTX
+	return Scale.PackArgs(x, 1024, 2.5), nil
+}
+`
+
+// TestGenerate runs ptxbind the way go:generate does, against a generator
+// package in a scratch module that uses this checkout of ptx-gen, and
+// builds the host package with the result.
+func TestGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs Go programs")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/host\n\ngo 1.23.0\n\n"+
+		"require github.com/arc-language/ptx-gen v0.0.0\n\n"+
+		"replace github.com/arc-language/ptx-gen => "+root+"\n")
+	write("gen/gen.go", testGenerator)
+	write("host.go", testHost)
+
+	env := append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOPACKAGE=host")
+	command := func(dir, name string, args ...string) {
+		t.Helper()
+		cmd := exec.Command(name, args...)
+		cmd.Dir = dir
+		cmd.Env = env
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s %s: %v\n%s", filepath.Base(name), strings.Join(args, " "), err, out)
+		}
+	}
+
+	bin := filepath.Join(t.TempDir(), "ptxbind")
+	command(".", goTool, "build", "-o", bin, ".")
+	command(dir, bin, "-gen", "example.com/host/gen")
+
+	src, err := os.ReadFile(filepath.Join(dir, "kernels_ptx.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"package host", "const PTX", ".entry scale", "type ScaleKernel struct"} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("kernels_ptx.go does not contain %q", want)
+		}
+	}
+	command(dir, goTool, "vet", ".")
+	if entries, _ := filepath.Glob(filepath.Join(dir, "_ptxbind*")); len(entries) > 0 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
@@ -0,0 +1,241 @@
+package ptxgen
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// CHeader returns a C/C++ header describing the kernels and .visible
+// globals in mod, for hosts that use the CUDA driver API. name, with or
+// without its .h, is used for the include guard. For a kernel vec_add the header declares
+//
+//	static const char VEC_ADD_NAME[] = "vec_add";
+//	typedef struct vec_add_args { ... } vec_add_args;
+//	enum { VEC_ADD_ARGS_SIZE = 32, VEC_ADD_SHARED_BYTES = 0, ... };
+//
+// The argument struct has one member per parameter, placed with alignas as
+// LaunchInfo lays it out, and static_asserts check every offset and the
+// size. Launch with a pointer to it and VEC_ADD_ARGS_SIZE. .reqntid and
+// .maxntid become VEC_ADD_REQNTID_X etc. A .visible global g gets G_SYMBOL
+// and G_SIZE. Names that would clash get a number appended to their stem.
+// The header compiles as C11 and C++11.
+func CHeader(mod *builder.Module, name string) ([]byte, error) {
+	h := &cHeader{names: make(map[string]bool)}
+	guard := cMacro(name)
+	if !strings.HasSuffix(guard, "_H") && !strings.HasSuffix(guard, "_HPP") {
+		guard += "_H" // "kernels" and "kernels.h" both give KERNELS_H
+	}
+	h.names[guard] = true
+	w := &h.buf
+	fmt.Fprintf(w, "/* Code generated by ptx-gen. DO NOT EDIT. */\n\n#ifndef %s\n#define %s\n\n", guard, guard)
+	w.WriteString("#include <stddef.h>\n#include <stdint.h>\n#ifndef __cplusplus\n#include <assert.h>\n#include <stdalign.h>\n#endif\n")
+	fmt.Fprintf(w, "\n/* PTX %s, %s */\n", mod.Version, mod.Target)
+
+	infos := LaunchInfo(mod)
+	i := 0
+	for _, f := range mod.Functions {
+		if !isKernel(f) {
+			continue
+		}
+		if err := h.kernel(f, infos[i]); err != nil {
+			return nil, err
+		}
+		i++
+	}
+	for _, g := range mod.Globals {
+		if g != nil && g.Linkage == ptx.LinkVisible {
+			h.global(g)
+		}
+	}
+
+	fmt.Fprintf(w, "\n#endif /* %s */\n", guard)
+	return []byte(h.buf.String()), nil
+}
+
+type cHeader struct {
+	buf   strings.Builder
+	names map[string]bool // identifier stems in use
+}
+
+func (h *cHeader) kernel(f *builder.Function, info KernelInfo) error {
+	type constant struct {
+		suffix string
+		value  int
+	}
+	consts := []constant{{"_ARGS_SIZE", info.ParamBytes}, {"_SHARED_BYTES", info.SharedBytes}}
+	for _, d := range []struct {
+		name string
+		dims []int
+	}{{"_REQNTID", info.ReqNTid}, {"_MAXNTID", info.MaxNTid}} {
+		if len(d.dims) > 0 {
+			dims := ntid(d.dims)
+			for j, axis := range []string{"_X", "_Y", "_Z"} {
+				consts = append(consts, constant{d.name + axis, dims[j]})
+			}
+		}
+	}
+	suffixes := []string{"_NAME", "_args"}
+	for _, c := range consts {
+		suffixes = append(suffixes, c.suffix)
+	}
+	id := h.unique(cIdent(f.Name), suffixes...)
+	macro := strings.ToUpper(id)
+	w := &h.buf
+
+	fmt.Fprintf(w, "\n/* .entry %s */\n", f.Name)
+	fmt.Fprintf(w, "static const char %s_NAME[] = %q;\n", macro, f.Name)
+	enums := make([]string, len(consts))
+	for j, c := range consts {
+		enums[j] = fmt.Sprintf("%s%s = %d", macro, c.suffix, c.value)
+	}
+	fmt.Fprintf(w, "enum {\n\t%s\n};\n", strings.Join(enums, ",\n\t"))
+
+	if len(info.Params) == 0 {
+		return nil
+	}
+	var members, checks []string
+	fields := make(map[string]bool)
+	i := 0
+	for _, p := range f.Params {
+		if p == nil {
+			continue
+		}
+		pi := info.Params[i]
+		i++
+		field := cIdent(p.Name)
+		for base, n := field, 2; fields[field]; n++ {
+			field = fmt.Sprintf("%s_%d", base, n)
+		}
+		fields[field] = true
+		member, err := cMember(p, pi, field)
+		if err != nil {
+			return fmt.Errorf("ptxgen: kernel %s: %v", f.Name, err)
+		}
+		members = append(members, member)
+		checks = append(checks, fmt.Sprintf("static_assert(offsetof(%s_args, %s) == %d, \"%s: %s offset\");", id, field, pi.Offset, f.Name, p.Name))
+	}
+	fmt.Fprintf(w, "typedef struct %s_args {\n\t%s\n} %s_args;\n", id, strings.Join(members, "\n\t"), id)
+	checks = append(checks, fmt.Sprintf("static_assert(sizeof(%s_args) == %s_ARGS_SIZE, \"%s: argument size\");", id, macro, f.Name))
+	fmt.Fprintf(w, "%s\n", strings.Join(checks, "\n"))
+	return nil
+}
+
+func (h *cHeader) global(g *builder.Global) {
+	id := h.unique(cIdent(g.Name), "_SYMBOL", "_SIZE")
+	macro := strings.ToUpper(id)
+	decl := g.Space.String() + " " + g.Typ.String()
+	if g.Vec != ptx.Scalar {
+		decl = g.Space.String() + " " + g.Vec.String() + " " + g.Typ.String()
+	}
+	if g.Count > 0 {
+		decl += fmt.Sprintf(" %s[%d]", g.Name, g.Count)
+	} else {
+		decl += " " + g.Name
+	}
+	fmt.Fprintf(&h.buf, "\n/* .visible %s */\n", decl)
+	fmt.Fprintf(&h.buf, "static const char %s_SYMBOL[] = %q;\n", macro, g.Name)
+	fmt.Fprintf(&h.buf, "enum { %s_SIZE = %d };\n", macro, varSize(g))
+}
+
+// unique returns id, or id with a number appended, such that it and
+// id+suffix for each suffix are free, and reserves them all. Names are
+// compared in upper case, since most of them become macros.
+func (h *cHeader) unique(id string, suffixes ...string) string {
+	base := id
+	taken := func(id string) bool {
+		if h.names[strings.ToUpper(id)] {
+			return true
+		}
+		for _, s := range suffixes {
+			if h.names[strings.ToUpper(id+s)] {
+				return true
+			}
+		}
+		return false
+	}
+	for n := 2; taken(id); n++ {
+		id = fmt.Sprintf("%s_%d", base, n)
+	}
+	h.names[strings.ToUpper(id)] = true
+	for _, s := range suffixes {
+		h.names[strings.ToUpper(id+s)] = true
+	}
+	return id
+}
+
+// cMember returns the struct member declaration for parameter p.
+func cMember(p *builder.Param, pi ParamInfo, field string) (string, error) {
+	var typ string
+	switch {
+	case p.Size > 0 || pi.Size > 8:
+	case p.IsPointer || p.Typ == ptx.U8 || p.Typ == ptx.U16 || p.Typ == ptx.U32 || p.Typ == ptx.U64 ||
+		p.Typ == ptx.B8 || p.Typ == ptx.B16 || p.Typ == ptx.B32 || p.Typ == ptx.B64:
+		typ = fmt.Sprintf("uint%d_t", pi.Size*8)
+	case p.Typ == ptx.S8 || p.Typ == ptx.S16 || p.Typ == ptx.S32 || p.Typ == ptx.S64:
+		typ = fmt.Sprintf("int%d_t", pi.Size*8)
+	case p.Typ == ptx.F32:
+		typ = "float"
+	case p.Typ == ptx.F64:
+		typ = "double"
+	case pi.Size == 0 || p.Typ == ptx.Pred:
+		return "", fmt.Errorf("parameter %s of type %s has no host representation", p.Name, p.Typ)
+	case pi.Size == 1 || pi.Size == 2 || pi.Size == 4 || pi.Size == 8:
+		typ = fmt.Sprintf("uint%d_t", pi.Size*8) // half floats and opaque handles as raw bits
+	}
+
+	// alignas may only raise a type's alignment, so a scalar aligned below
+	// its size is stored as bytes.
+	if typ != "" && pi.Align < pi.Size {
+		typ = ""
+	}
+	comment := fmt.Sprintf(" /* .param %s %s */", p.Typ, p.Name)
+	if typ == "" {
+		if pi.Align > 1 {
+			return fmt.Sprintf("alignas(%d) uint8_t %s[%d];%s", pi.Align, field, pi.Size, comment), nil
+		}
+		return fmt.Sprintf("uint8_t %s[%d];%s", field, pi.Size, comment), nil
+	}
+	if pi.Align > pi.Size {
+		return fmt.Sprintf("alignas(%d) %s %s;%s", pi.Align, typ, field, comment), nil
+	}
+	return fmt.Sprintf("%s %s;%s", typ, field, comment), nil
+}
+
+// cIdent turns a PTX name into a C identifier that is not a keyword.
+func cIdent(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	id := b.String()
+	if id == "" || unicode.IsDigit(rune(id[0])) || cKeywords[id] {
+		id = "k_" + id
+	}
+	return id
+}
+
+// cMacro turns name into an upper-case C identifier.
+func cMacro(name string) string {
+	return strings.ToUpper(cIdent(name))
+}
+
+// cKeywords are the C and C++ keywords a parameter name might collide with.
+var cKeywords = map[string]bool{
+	"auto": true, "bool": true, "break": true, "case": true, "char": true, "class": true,
+	"const": true, "continue": true, "default": true, "delete": true, "do": true,
+	"double": true, "else": true, "enum": true, "extern": true, "float": true, "for": true,
+	"goto": true, "if": true, "inline": true, "int": true, "long": true, "new": true,
+	"operator": true, "private": true, "protected": true, "public": true, "register": true,
+	"restrict": true, "return": true, "short": true, "signed": true, "sizeof": true,
+	"static": true, "struct": true, "switch": true, "template": true, "this": true,
+	"typedef": true, "union": true, "unsigned": true, "virtual": true, "void": true,
+	"volatile": true, "while": true,
+}
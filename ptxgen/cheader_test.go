@@ -0,0 +1,94 @@
+package ptxgen
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// headerModule has kernels with every kind of parameter and names that
+// clash once they become C identifiers and macros.
+func headerModule() *builder.Module {
+	mod := NewModule(ptx.ISA80, ptx.SM80)
+	k := mod.NewKernel("vec_add")
+	k.AddParam(builder.NewPtrParam("x", ptx.Global))
+	k.AddParam(builder.NewParam("n", ptx.U32))
+	k.AddParam(builder.NewParam("alpha", ptx.F32))
+	k.AddParam(builder.NewParam("h", ptx.F16))
+	k.AddParam(builder.NewParam("off", ptx.S16))
+	k.AddParam(builder.NewByteArrayParam("cfg", 12, 8))
+	k.AddParam(builder.NewByteArrayParam("tag", 3, 1))
+	k.AddParam(builder.NewParam("int", ptx.S64))
+	k.AddDirective(builder.ReqNTid(128, 2))
+	k.NewBlock("").Add(builder.Ret())
+
+	k = mod.NewKernel("k")
+	k.AddParam(builder.NewParam("v", ptx.F64))
+	k.AddDirective(builder.MaxNTid(256))
+	k.NewBlock("").Add(builder.Ret())
+	mod.NewKernel("K").NewBlock("").Add(builder.Ret())
+
+	mod.AddGlobal(builder.NewGlobal("k_args", ptx.Global, ptx.U32).WithLinkage(ptx.LinkVisible))
+	mod.AddGlobal(builder.NewGlobalArray("vec_add_name", ptx.Global, ptx.F32, 16).WithLinkage(ptx.LinkVisible))
+	mod.AddGlobal(builder.NewGlobalArray("kernels_h", ptx.Const, ptx.U8, 4).WithLinkage(ptx.LinkVisible))
+	return mod
+}
+
+func TestCHeaderGolden(t *testing.T) {
+	got, err := CHeader(headerModule(), "kernels.h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join("testdata", "kernels.h")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("CHeader output differs from %s; rerun with -update if the change is intended\n%s", path, got)
+	}
+}
+
+// TestCHeaderCompiles builds the header as C11 and C++11, which checks
+// that its names are distinct and that its static_asserts hold.
+func TestCHeaderCompiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a C compiler")
+	}
+	src, err := CHeader(headerModule(), "kernels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	header := filepath.Join(dir, "kernels.h")
+	if err := os.WriteFile(header, src, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ tool, lang, std string }{
+		{"cc", "c", "-std=c11"},
+		{"c++", "c++", "-std=c++11"},
+	} {
+		tool, err := exec.LookPath(c.tool)
+		if err != nil {
+			t.Logf("%s not found", c.tool)
+			continue
+		}
+		cmd := exec.Command(tool, "-x", c.lang, c.std, "-Wall", "-Werror", "-fsyntax-only", header)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%s %s: %v\n%s\n%s", c.tool, c.std, err, out, src)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package ptxgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// GoBindings returns the source of a Go file in package pkg for launching
+// the kernels in mod from host code. The file embeds the PTX text as the
+// constant PTX and, for each kernel such as vec_add, declares a value
+// VecAdd of type VecAddKernel with methods
+//
+//	Name() string                            // "vec_add"
+//	PackArgs(a, b, c DevicePtr, n uint32) []byte
+//	CheckBlock(x, y, z int) error            // against .reqntid / .maxntid
+//	SharedBytes() int                        // static .shared usage
+//
+// PackArgs lays out the arguments as LaunchInfo describes them, little
+// endian. Pointer parameters take a DevicePtr, integers and floats the Go
+// type of the same width, and byte-array parameters a [N]byte. The file
+// imports only the standard library and needs no cgo.
+func GoBindings(mod *builder.Module, pkg string) ([]byte, error) {
+	if !token.IsIdentifier(pkg) {
+		return nil, fmt.Errorf("ptxgen: invalid package name %q", pkg)
+	}
+	g := &goBinder{names: make(map[string]bool)}
+	for _, name := range []string{"PTX", "DevicePtr"} {
+		g.names[name] = true
+	}
+
+	infos := LaunchInfo(mod)
+	i := 0
+	for _, f := range mod.Functions {
+		if !isKernel(f) {
+			continue
+		}
+		if err := g.kernel(f, infos[i]); err != nil {
+			return nil, err
+		}
+		i++
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by ptx-gen. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	var imports []string
+	for _, imp := range []string{"encoding/binary", "fmt", "math"} {
+		if g.imports[imp] {
+			imports = append(imports, strconv.Quote(imp))
+		}
+	}
+	if len(imports) > 0 {
+		fmt.Fprintf(&out, "import (\n%s\n)\n\n", strings.Join(imports, "\n"))
+	}
+	fmt.Fprintf(&out, "// PTX is the PTX source of the module.\nconst PTX = %s\n\n", goString(Build(mod)))
+	out.WriteString("// DevicePtr is a device memory address.\ntype DevicePtr uint64\n")
+	out.Write(g.buf.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("ptxgen: generated invalid Go: %v", err)
+	}
+	return src, nil
+}
+
+// isKernel reports whether f is a kernel definition.
+func isKernel(f *builder.Function) bool {
+	return f != nil && f.IsKernel && !f.IsDecl
+}
+
+type goBinder struct {
+	buf     bytes.Buffer
+	names   map[string]bool // package-level names in use
+	imports map[string]bool
+}
+
+func (g *goBinder) use(imp string) {
+	if g.imports == nil {
+		g.imports = make(map[string]bool)
+	}
+	g.imports[imp] = true
+}
+
+func (g *goBinder) kernel(f *builder.Function, info KernelInfo) error {
+	name := g.unique(exportedName(f.Name), "Kernel")
+	typ := name + "Kernel"
+
+	var params, body []string
+	locals := make(map[string]bool)
+	for _, n := range bodyNames {
+		locals[n] = true
+	}
+	i := 0
+	for _, p := range f.Params {
+		if p == nil {
+			continue
+		}
+		pi := info.Params[i]
+		i++
+		arg := localName(p.Name, locals)
+		goType, put, err := g.encode(p, pi, arg)
+		if err != nil {
+			return fmt.Errorf("ptxgen: kernel %s: %v", f.Name, err)
+		}
+		params = append(params, arg+" "+goType)
+		body = append(body, put)
+	}
+
+	w := &g.buf
+	fmt.Fprintf(w, "\n// %s is the %s kernel.\ntype %s struct{}\n\n", typ, f.Name, typ)
+	fmt.Fprintf(w, "// %s is the %s kernel.\nvar %s %s\n\n", name, f.Name, name, typ)
+	fmt.Fprintf(w, "// Name returns the kernel's entry name.\nfunc (%s) Name() string { return %q }\n\n", typ, f.Name)
+	fmt.Fprintf(w, "// SharedBytes returns the kernel's static .shared memory in bytes.\nfunc (%s) SharedBytes() int { return %d }\n\n", typ, info.SharedBytes)
+	fmt.Fprintf(w, "// PackArgs returns the kernel's %d-byte argument buffer.\n", info.ParamBytes)
+	fmt.Fprintf(w, "func (%s) PackArgs(%s) []byte {\n\targs := make([]byte, %d)\n", typ, strings.Join(params, ", "), info.ParamBytes)
+	for _, line := range body {
+		fmt.Fprintf(w, "\t%s\n", line)
+	}
+	w.WriteString("\treturn args\n}\n\n")
+
+	w.WriteString("// CheckBlock reports whether a block of x*y*z threads may launch the kernel.\n")
+	switch {
+	case len(info.ReqNTid) > 0:
+		g.use("fmt")
+		want := ntid(info.ReqNTid)
+		fmt.Fprintf(w, "func (%s) CheckBlock(x, y, z int) error {\n", typ)
+		fmt.Fprintf(w, "\tif x != %d || y != %d || z != %d {\n", want[0], want[1], want[2])
+		fmt.Fprintf(w, "\t\treturn fmt.Errorf(\"%s: block %%dx%%dx%%d, .reqntid is %dx%dx%d\", x, y, z)\n\t}\n\treturn nil\n}\n", f.Name, want[0], want[1], want[2])
+	case len(info.MaxNTid) > 0:
+		g.use("fmt")
+		limit := ntid(info.MaxNTid)
+		n := limit[0] * limit[1] * limit[2]
+		fmt.Fprintf(w, "func (%s) CheckBlock(x, y, z int) error {\n", typ)
+		fmt.Fprintf(w, "\tif x < 1 || y < 1 || z < 1 || x*y*z > %d {\n", n)
+		fmt.Fprintf(w, "\t\treturn fmt.Errorf(\"%s: block %%dx%%dx%%d, .maxntid allows %d threads\", x, y, z)\n\t}\n\treturn nil\n}\n", f.Name, n)
+	default:
+		fmt.Fprintf(w, "func (%s) CheckBlock(x, y, z int) error { return nil }\n", typ)
+	}
+	return nil
+}
+
+// encode returns the Go type of parameter p and the statement that stores
+// arg into the argument buffer.
+func (g *goBinder) encode(p *builder.Param, pi ParamInfo, arg string) (string, string, error) {
+	at := fmt.Sprintf("args[%d:]", pi.Offset)
+	if p.Size > 0 || pi.Size > 8 {
+		return fmt.Sprintf("[%d]byte", pi.Size), fmt.Sprintf("copy(%s, %s[:])", at, arg), nil
+	}
+	if p.IsPointer {
+		g.use("encoding/binary")
+		return "DevicePtr", fmt.Sprintf("binary.LittleEndian.PutUint64(%s, uint64(%s))", at, arg), nil
+	}
+
+	var goType string
+	switch p.Typ {
+	case ptx.S8, ptx.S16, ptx.S32, ptx.S64:
+		goType = fmt.Sprintf("int%d", pi.Size*8)
+	case ptx.F32:
+		g.use("encoding/binary")
+		g.use("math")
+		return "float32", fmt.Sprintf("binary.LittleEndian.PutUint32(%s, math.Float32bits(%s))", at, arg), nil
+	case ptx.F64:
+		g.use("encoding/binary")
+		g.use("math")
+		return "float64", fmt.Sprintf("binary.LittleEndian.PutUint64(%s, math.Float64bits(%s))", at, arg), nil
+	default:
+		if pi.Size == 0 || p.Typ == ptx.Pred {
+			return "", "", fmt.Errorf("parameter %s of type %s has no host representation", p.Name, p.Typ)
+		}
+		// unsigned, untyped bits, half floats and opaque handles travel as raw bits
+		goType = fmt.Sprintf("uint%d", pi.Size*8)
+	}
+	switch pi.Size {
+	case 1:
+		return goType, fmt.Sprintf("args[%d] = byte(%s)", pi.Offset, arg), nil
+	case 2, 4, 8:
+		g.use("encoding/binary")
+		bits := pi.Size * 8
+		val := arg
+		if goType != fmt.Sprintf("uint%d", bits) {
+			val = fmt.Sprintf("uint%d(%s)", bits, arg)
+		}
+		return goType, fmt.Sprintf("binary.LittleEndian.PutUint%d(%s, %s)", bits, at, val), nil
+	}
+	return fmt.Sprintf("[%d]byte", pi.Size), fmt.Sprintf("copy(%s, %s[:])", at, arg), nil
+}
+
+// bodyNames are the names PackArgs refers to, which its parameters must
+// not shadow: the buffer, the imported packages and the builtins used in
+// conversions.
+var bodyNames = []string{
+	"args", "binary", "fmt", "math", "copy", "make", "byte",
+	"int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64", "float32", "float64",
+}
+
+// unique returns name, or name with a number appended, such that it and
+// name+suffix for each suffix are free, and reserves them all.
+func (g *goBinder) unique(name string, suffixes ...string) string {
+	base := name
+	taken := func(name string) bool {
+		if g.names[name] {
+			return true
+		}
+		for _, s := range suffixes {
+			if g.names[name+s] {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 2; taken(name); i++ {
+		name = base + strconv.Itoa(i)
+	}
+	g.names[name] = true
+	for _, s := range suffixes {
+		g.names[name+s] = true
+	}
+	return name
+}
+
+// ntid pads launch dimensions to three, with 1 for missing ones.
+func ntid(dims []int) [3]int {
+	out := [3]int{1, 1, 1}
+	copy(out[:], dims)
+	return out
+}
+
+// exportedName turns a PTX name such as vec_add into VecAdd.
+func exportedName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	name := b.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "K" + name
+	}
+	return name
+}
+
+// localName turns a parameter name into an unexported Go identifier that
+// is not a keyword and not yet in taken, and records it.
+func localName(s string, taken map[string]bool) string {
+	name := exportedName(s)
+	r := []rune(name)
+	r[0] = unicode.ToLower(r[0])
+	name = string(r)
+	if token.IsKeyword(name) {
+		name += "_"
+	}
+	base := name
+	for i := 2; taken[name]; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	taken[name] = true
+	return name
+}
+
+// goString quotes s as a Go string literal, raw if possible.
+func goString(s string) string {
+	if strings.Contains(s, "`") || strings.Contains(s, "\r") {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
@@ -0,0 +1,115 @@
+package ptxgen
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// runBindings writes the bindings for mod into a scratch main package
+// with mainSrc and returns what it prints.
+func runBindings(t *testing.T, mod *builder.Module, mainSrc string) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds and runs a Go program")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	src, err := GoBindings(mod, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":         "module bindtest\n\ngo 1.23.0\n",
+		"kernels_ptx.go": string(src),
+		"main.go":        mainSrc,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(goTool, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "CGO_ENABLED=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run: %v\n%s\n--- kernels_ptx.go ---\n%s", err, out, src)
+	}
+	return string(out)
+}
+
+func TestGoBindingsPackArgs(t *testing.T) {
+	mod := NewModule(ptx.ISA80, ptx.SM80)
+	k := mod.NewKernel("scale")
+	k.AddParam(builder.NewPtrParam("x", ptx.Global))
+	k.AddParam(builder.NewParam("n", ptx.U32))
+	k.AddParam(builder.NewParam("alpha", ptx.F32))
+	k.AddParam(builder.NewParam("off", ptx.S16))
+	k.AddParam(builder.NewByteArrayParam("cfg", 3, 1))
+	k.AddParam(builder.NewParam("beta", ptx.F64))
+	k.AddDirective(builder.ReqNTid(128))
+	k.NewBlock("").Add(builder.Ret())
+
+	got := runBindings(t, mod, `package main
+
+import "fmt"
+
+func main() {
+	fmt.Printf("%x\n", Scale.PackArgs(0x1122334455667788, 7, 1.5, -2, [3]byte{9, 8, 7}, 2))
+	fmt.Println(Scale.Name(), Scale.CheckBlock(128, 1, 1) == nil, Scale.CheckBlock(64, 2, 1) != nil)
+}
+`)
+	// x at 0, n at 8, alpha at 12, off at 16, cfg at 18, beta at 24; 32 bytes.
+	want := "8877665544332211" + "07000000" + "0000c03f" + "feff" + "090807" + "000000" + "0000000000000040" + "\n" +
+		"scale true true\n"
+	if got != want {
+		t.Errorf("got:\n%swant:\n%s", got, want)
+	}
+}
+
+func TestGoBindingsNameCollisions(t *testing.T) {
+	mod := NewModule(ptx.ISA80, ptx.SM80)
+	a := mod.NewKernel("vec_add_kernel")
+	a.AddParam(builder.NewParam("math", ptx.F32))
+	a.AddParam(builder.NewParam("binary", ptx.U32))
+	a.AddParam(builder.NewParam("uint32", ptx.S32))
+	a.AddParam(builder.NewParam("copy", ptx.U8))
+	a.AddParam(builder.NewParam("args", ptx.U16))
+	a.NewBlock("").Add(builder.Ret())
+	b := mod.NewKernel("vec_add")
+	b.AddParam(builder.NewParam("fmt", ptx.F64))
+	b.AddDirective(builder.ReqNTid(32))
+	b.NewBlock("").Add(builder.Ret())
+
+	got := runBindings(t, mod, `package main
+
+import "fmt"
+
+func main() {
+	fmt.Printf("%x\n", VecAddKernel.PackArgs(1, 2, -1, 3, 4))
+	fmt.Printf("%x\n", VecAdd2.PackArgs(1))
+	fmt.Println(VecAddKernel.Name(), VecAdd2.Name())
+}
+`)
+	want := "0000803f" + "02000000" + "ffffffff" + "03" + "00" + "0400" + "\n" +
+		"000000000000f03f\n" +
+		"vec_add_kernel vec_add\n"
+	if got != want {
+		t.Errorf("got:\n%swant:\n%s", got, want)
+	}
+}
+
+func TestGoBindingsInvalidPackage(t *testing.T) {
+	if _, err := GoBindings(NewModule(ptx.ISA80, ptx.SM80), "not a name"); err == nil || !strings.Contains(err.Error(), "invalid package name") {
+		t.Errorf("error %v, want an invalid package name error", err)
+	}
+}
@@ -0,0 +1,258 @@
+package ptxgen
+
+import (
+	"encoding/json"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// KernelInfo is what a host launcher needs to know about one .entry.
+type KernelInfo struct {
+	Name       string      `json:"name"`
+	Params     []ParamInfo `json:"params"`
+	ParamBytes int         `json:"param_bytes"` // size of the packed argument buffer, padded like a C struct
+
+	ReqNTid           []int `json:"reqntid,omitempty"`
+	MaxNTid           []int `json:"maxntid,omitempty"`
+	ReqNCluster       []int `json:"reqnctapercluster,omitempty"`
+	MaxClusterRank    int   `json:"maxclusterrank,omitempty"`
+	ExplicitCluster   bool  `json:"explicitcluster,omitempty"`
+	BlocksAreClusters bool  `json:"blocksareclusters,omitempty"`
+
+	SharedBytes   int  `json:"shared_bytes"`             // static .shared memory
+	DynamicShared bool `json:"dynamic_shared,omitempty"` // uses an extern .shared array sized at launch
+}
+
+// ParamInfo is the place of one kernel parameter in the argument buffer.
+type ParamInfo struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"` // PTX element type, e.g. ".u64", or ".b8" for byte arrays
+	Offset  int    `json:"offset"`
+	Size    int    `json:"size"`
+	Align   int    `json:"align"`
+	Pointer bool   `json:"pointer,omitempty"`
+	Space   string `json:"space,omitempty"` // state space a pointer points to
+}
+
+// LaunchInfo returns the launch metadata of every kernel defined in mod,
+// in module order.
+//
+// Parameters are laid out in declaration order, each at the next multiple
+// of its alignment, which is its .align or else its element size. Byte
+// array parameters (NewByteArrayParam) are Size elements long. ParamBytes
+// is rounded up to the largest alignment, as the size of the matching C
+// struct is.
+//
+// SharedBytes adds up the .shared variables the kernel declares and the
+// module-scope .shared variables named in it or in any function it calls,
+// each aligned in turn. An extern .shared array has no static size and sets
+// DynamicShared instead.
+func LaunchInfo(mod *builder.Module) []KernelInfo {
+	funcs := make(map[string]*builder.Function)
+	for _, f := range mod.Functions {
+		if f != nil && !f.IsDecl {
+			funcs[f.Name] = f
+		}
+	}
+
+	var infos []KernelInfo
+	for _, f := range mod.Functions {
+		if !isKernel(f) {
+			continue
+		}
+		info := KernelInfo{Name: f.Name, Params: []ParamInfo{}}
+		align := 1
+		for _, p := range f.Params {
+			if p == nil {
+				continue
+			}
+			pi := paramInfo(p)
+			pi.Offset = alignUp(info.ParamBytes, pi.Align)
+			info.ParamBytes = pi.Offset + pi.Size
+			info.Params = append(info.Params, pi)
+			align = max(align, pi.Align)
+		}
+		info.ParamBytes = alignUp(info.ParamBytes, align)
+		for _, d := range f.Directives {
+			if d == nil {
+				continue
+			}
+			switch d.Kind {
+			case builder.DirReqNTid:
+				info.ReqNTid = d.Values
+			case builder.DirMaxNTid:
+				info.MaxNTid = d.Values
+			case builder.DirReqNCluster:
+				info.ReqNCluster = d.Values
+			case builder.DirMaxClusterRank:
+				if len(d.Values) > 0 {
+					info.MaxClusterRank = d.Values[0]
+				}
+			case builder.DirExplicitCluster:
+				info.ExplicitCluster = true
+			case builder.DirBlocksAreClusters:
+				info.BlocksAreClusters = true
+			}
+		}
+		for _, g := range sharedVars(mod, f, funcs) {
+			if g.Linkage == ptx.LinkExtern {
+				info.DynamicShared = true
+				continue
+			}
+			info.SharedBytes = alignUp(info.SharedBytes, varAlign(g)) + varSize(g)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// LaunchInfoJSON returns LaunchInfo(mod) as indented JSON.
+func LaunchInfoJSON(mod *builder.Module) ([]byte, error) {
+	return json.MarshalIndent(LaunchInfo(mod), "", "  ")
+}
+
+func paramInfo(p *builder.Param) ParamInfo {
+	elem := p.Typ.BitWidth() / 8
+	pi := ParamInfo{
+		Name:    p.Name,
+		Type:    p.Typ.String(),
+		Size:    elem,
+		Align:   elem,
+		Pointer: p.IsPointer,
+	}
+	if p.Size > 0 {
+		pi.Size = elem * p.Size
+	}
+	if p.Align > 0 {
+		pi.Align = p.Align
+	}
+	if p.IsPointer && p.PtrSpace != ptx.Reg {
+		pi.Space = p.PtrSpace.String()
+	}
+	return pi
+}
+
+// varAlign returns the alignment of a variable in bytes.
+func varAlign(g *builder.Global) int {
+	if g.Align > 0 {
+		return g.Align
+	}
+	n := g.Typ.BitWidth() / 8
+	switch g.Vec {
+	case ptx.V2:
+		n *= 2
+	case ptx.V4:
+		n *= 4
+	}
+	return n
+}
+
+func alignUp(n, align int) int {
+	if align <= 1 {
+		return n
+	}
+	return (n + align - 1) / align * align
+}
+
+// sharedVars returns the .shared variables kernel k uses: those declared in
+// k or a function it calls, and the module-scope ones any of them name. A
+// variable declared in a function hides a module-scope one of the same name
+// in that function only.
+func sharedVars(mod *builder.Module, k *builder.Function, funcs map[string]*builder.Function) []*builder.Global {
+	names := make(map[string]bool) // module-scope names some function uses
+	seen := make(map[*builder.Function]bool)
+	var vars []*builder.Global
+	addVar := func(g *builder.Global) {
+		if g != nil && g.Space == ptx.Shared {
+			vars = append(vars, g)
+		}
+	}
+	var visit func(f *builder.Function)
+	visit = func(f *builder.Function) {
+		if seen[f] {
+			return
+		}
+		seen[f] = true
+		local := make(map[string]bool) // names that refer to f's own variables
+		addLocal := func(g *builder.Global) {
+			if g != nil {
+				local[g.Name] = true
+				addVar(g)
+			}
+		}
+		for _, v := range f.Locals {
+			addLocal(v)
+		}
+		forEachInst(f, func(inst *builder.Instruction) {
+			if inst.Op == ptx.OpDecl {
+				addLocal(inst.Var)
+			}
+		})
+		use := func(name string) {
+			if !local[name] {
+				names[name] = true
+			}
+			if f, ok := funcs[name]; ok {
+				visit(f)
+			}
+		}
+		for _, l := range f.CallTargetLists {
+			if l != nil {
+				for _, t := range l.Targets {
+					use(t)
+				}
+			}
+		}
+		forEachInst(f, func(inst *builder.Instruction) {
+			if inst.CallTarget != "" {
+				use(inst.CallTarget)
+			}
+			for _, op := range append([]builder.Operand{inst.Dst, inst.Dst2}, inst.Src...) {
+				symbols(op, use)
+			}
+		})
+	}
+	visit(k)
+
+	for _, g := range mod.Globals {
+		if g != nil && names[g.Name] {
+			addVar(g)
+		}
+	}
+	return vars
+}
+
+// forEachInst calls fn with each instruction of f.
+func forEachInst(f *builder.Function, fn func(*builder.Instruction)) {
+	for _, bb := range f.Blocks {
+		if bb == nil {
+			continue
+		}
+		for _, inst := range bb.Instructions {
+			if inst != nil {
+				fn(inst)
+			}
+		}
+	}
+}
+
+// symbols calls fn with each symbol name in op.
+func symbols(op builder.Operand, fn func(string)) {
+	switch o := op.(type) {
+	case *builder.Symbol:
+		if o != nil {
+			fn(o.Name)
+		}
+	case *builder.Address:
+		if o != nil {
+			symbols(o.Base, fn)
+		}
+	case *builder.VectorOp:
+		if o != nil {
+			for _, el := range o.Elements {
+				symbols(el, fn)
+			}
+		}
+	}
+}
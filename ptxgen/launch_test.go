@@ -0,0 +1,108 @@
+package ptxgen
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+func TestLaunchInfoParams(t *testing.T) {
+	mod := NewModule(ptx.ISA80, ptx.SM80)
+	k := mod.NewKernel("k")
+	k.AddParam(builder.NewParam("c", ptx.U8))
+	k.AddParam(builder.NewPtrParam("p", ptx.Global))
+	k.AddParam(builder.NewByteArrayParam("s", 12, 16))
+	k.AddParam(builder.NewParam("h", ptx.U16))
+	k.NewBlock("").Add(builder.Ret())
+
+	infos := LaunchInfo(mod)
+	if len(infos) != 1 {
+		t.Fatalf("got %d kernels, want 1", len(infos))
+	}
+	want := []ParamInfo{
+		{Name: "c", Type: ".u8", Offset: 0, Size: 1, Align: 1},
+		{Name: "p", Type: ".u64", Offset: 8, Size: 8, Align: 8, Pointer: true, Space: ".global"},
+		{Name: "s", Type: ".b8", Offset: 16, Size: 12, Align: 16},
+		{Name: "h", Type: ".u16", Offset: 28, Size: 2, Align: 2},
+	}
+	if got := infos[0].Params; !reflect.DeepEqual(got, want) {
+		t.Errorf("params:\n got %+v\nwant %+v", got, want)
+	}
+	// The 30 bytes of parameters are padded to the 16-byte alignment of s.
+	if got := infos[0].ParamBytes; got != 32 {
+		t.Errorf("ParamBytes is %d, want 32", got)
+	}
+}
+
+func TestLaunchInfoShared(t *testing.T) {
+	mod := NewModule(ptx.ISA80, ptx.SM80)
+	mod.AddGlobal(builder.NewGlobalArray("x", ptx.Shared, ptx.F32, 16))
+	mod.AddGlobal(builder.NewGlobalArray("y", ptx.Shared, ptx.F64, 4))
+	mod.AddGlobal(builder.NewGlobal("unused", ptx.Shared, ptx.U32))
+	mod.AddGlobal(builder.NewGlobalArray("dyn", ptx.Shared, ptx.B8, 0).WithLinkage(ptx.LinkExtern))
+	mod.AddGlobal(builder.NewGlobal("g", ptx.Global, ptx.U32))
+
+	// helper declares its own x, which hides the module's x in helper only.
+	helper := mod.NewFunc("helper")
+	helper.NewLocalArray("x", ptx.Shared, ptx.U64, 2)
+	a := helper.NewReg("a", ptx.U32)
+	bb := helper.NewBlock("")
+	bb.Add(builder.Mov(a, builder.Sym("x")).Typed(ptx.U32))
+	bb.Add(builder.Ret())
+
+	// k uses the module's x as well as its own t.
+	k := mod.NewKernel("k")
+	k.NewLocalArray("t", ptx.Shared, ptx.U8, 3)
+	a = k.NewReg("a", ptx.U32)
+	bb = k.NewBlock("")
+	bb.Add(builder.Mov(a, builder.Sym("x")).Typed(ptx.U32))
+	bb.Add(builder.Mov(a, builder.Sym("t")).Typed(ptx.U32))
+	bb.Add(builder.Mov(a, builder.Sym("g")).Typed(ptx.U32))
+	bb.Add(builder.Call("helper", nil, nil))
+	bb.Add(builder.Ret())
+
+	// k2 declares its own y, but the function it calls names the module's
+	// y and the dynamic array.
+	user := mod.NewFunc("user")
+	a = user.NewReg("a", ptx.U32)
+	bb = user.NewBlock("")
+	bb.Add(builder.Mov(a, builder.Sym("y")).Typed(ptx.U32))
+	bb.Add(builder.Mov(a, builder.Sym("dyn")).Typed(ptx.U32))
+	bb.Add(builder.Ret())
+	k2 := mod.NewKernel("k2")
+	k2.NewLocal("y", ptx.Shared, ptx.U32)
+	a = k2.NewReg("a", ptx.U32)
+	bb = k2.NewBlock("")
+	bb.Add(builder.Mov(a, builder.Sym("y")).Typed(ptx.U32))
+	bb.Add(builder.Call("user", nil, nil))
+	bb.Add(builder.Ret())
+
+	// k3 uses no shared memory.
+	k3 := mod.NewKernel("k3")
+	a = k3.NewReg("a", ptx.U32)
+	bb = k3.NewBlock("")
+	bb.Add(builder.Mov(a, builder.Sym("g")).Typed(ptx.U32))
+	bb.Add(builder.Ret())
+
+	infos := LaunchInfo(mod)
+	if len(infos) != 3 {
+		t.Fatalf("got %d kernels, want 3", len(infos))
+	}
+	for i, want := range []struct {
+		name    string
+		bytes   int
+		dynamic bool
+	}{
+		{"k", 88, false}, // t at 0, helper's x at 8, the module's x at 24
+		{"k2", 40, true}, // k2's y at 0, the module's y at 8
+		{"k3", 0, false},
+	} {
+		got := infos[i]
+		if got.Name != want.name || got.SharedBytes != want.bytes || got.DynamicShared != want.dynamic {
+			t.Errorf("kernel %s: %d shared bytes, dynamic %v; want %s: %d, %v",
+				got.Name, got.SharedBytes, got.DynamicShared, want.name, want.bytes, want.dynamic)
+		}
+	}
+}
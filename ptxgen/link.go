@@ -0,0 +1,390 @@
+package ptxgen
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// Link merges mods into one module, in order.
+//
+// Symbols with .visible, .weak, .common or .extern linkage are shared
+// between the inputs and resolved by name:
+//   - a .visible definition wins; two of them are an error
+//   - otherwise the first .weak definition wins
+//   - otherwise the largest .common variable wins
+//   - .extern declarations are dropped once a definition is found, and
+//     kept (once) if none is
+//
+// Symbols without linkage are private to their module and are renamed
+// (name_1, name_2, ...) when they clash with any other symbol. A function
+// whose definition lands after a call to it is given a forward declaration.
+//
+// The result uses the highest Version and Target among the inputs. Inputs
+// with different address sizes, or an arch-specific target such as sm_90a
+// combined with a newer one, are reported as conflicts. Every problem
+// found is returned, joined into one error.
+//
+// The inputs are not modified, and the result shares nothing with them.
+func Link(mods ...*builder.Module) (*builder.Module, error) {
+	l := &linker{syms: make(map[string]*linkSym)}
+	if len(mods) == 0 {
+		return nil, errors.New("ptxgen: nothing to link")
+	}
+	for i, m := range mods {
+		if m == nil {
+			return nil, fmt.Errorf("ptxgen: module %d is nil", i)
+		}
+	}
+	out := l.header(mods)
+	copies := make([]*builder.Module, len(mods))
+	for i, m := range mods {
+		copies[i] = m.Clone()
+	}
+	l.collect(copies)
+	l.resolve()
+	l.rename(copies)
+	if len(l.errs) > 0 {
+		return nil, errors.Join(l.errs...)
+	}
+	for i, m := range copies {
+		l.apply(m, i)
+	}
+	if len(l.errs) > 0 {
+		return nil, errors.Join(l.errs...)
+	}
+	l.build(copies, out)
+	return out, nil
+}
+
+// linkSym is every module's view of one shared symbol name.
+type linkSym struct {
+	name   string
+	defs   []linkDef
+	winner *linkDef // nil until resolved
+	placed bool     // definition or declaration already in the output
+}
+
+// linkDef is one declaration or definition of a symbol.
+type linkDef struct {
+	mod int
+	g   *builder.Global
+	f   *builder.Function
+}
+
+func (d *linkDef) linkage() ptx.Linkage {
+	if d.g != nil {
+		return d.g.Linkage
+	}
+	return d.f.Linkage
+}
+
+// isDecl reports whether d only declares the symbol.
+func (d *linkDef) isDecl() bool {
+	if d.g != nil {
+		return d.g.Linkage == ptx.LinkExtern
+	}
+	return d.f.IsDecl || d.f.Linkage == ptx.LinkExtern
+}
+
+func (d *linkDef) same(o *linkDef) bool {
+	return d.g == o.g && d.f == o.f
+}
+
+type linker struct {
+	syms    map[string]*linkSym
+	order   []*linkSym
+	renames []map[string]string // per module: private name -> output name
+	pos     map[string]int      // output position of each function definition
+	errs    []error
+}
+
+func (l *linker) errorf(format string, args ...interface{}) {
+	l.errs = append(l.errs, fmt.Errorf("ptxgen: "+format, args...))
+}
+
+// header creates the output module from the highest version and target.
+func (l *linker) header(mods []*builder.Module) *builder.Module {
+	version, target := mods[0].Version, mods[0].Target
+	for _, m := range mods[1:] {
+		if m.Version.Major > version.Major || (m.Version.Major == version.Major && m.Version.Minor > version.Minor) {
+			version = m.Version
+		}
+		if m.Target > target {
+			target = m.Target
+		}
+	}
+	out := builder.NewModule(version, target)
+	out.AddressSize = mods[0].AddressSize
+	for i, m := range mods {
+		if m.AddressSize != out.AddressSize {
+			l.errorf("module %d has address size %d, module 0 has %d", i, m.AddressSize, out.AddressSize)
+		}
+		if archSpecific(m.Target) && m.Target != target {
+			l.errorf("module %d targets %s, which cannot be combined with %s", i, m.Target, target)
+		}
+		for _, name := range m.Files {
+			out.AddFile(name)
+		}
+	}
+	return out
+}
+
+// archSpecific reports whether code for t runs only on that architecture.
+func archSpecific(t ptx.Target) bool {
+	return t == ptx.SM90a
+}
+
+// shared reports whether a symbol with linkage lk is visible to other
+// modules.
+func shared(lk ptx.Linkage) bool {
+	return lk != ptx.LinkNone
+}
+
+// collect gathers every declaration and definition of each shared symbol.
+func (l *linker) collect(mods []*builder.Module) {
+	add := func(name string, d linkDef) {
+		s := l.syms[name]
+		if s == nil {
+			s = &linkSym{name: name}
+			l.syms[name] = s
+			l.order = append(l.order, s)
+		}
+		s.defs = append(s.defs, d)
+	}
+	for i, m := range mods {
+		for _, g := range m.Globals {
+			if g != nil && shared(g.Linkage) {
+				add(g.Name, linkDef{mod: i, g: g})
+			}
+		}
+		for _, f := range m.Functions {
+			if f != nil && shared(f.Linkage) {
+				add(f.Name, linkDef{mod: i, f: f})
+			}
+		}
+	}
+}
+
+// resolve picks the winning definition of each shared symbol.
+func (l *linker) resolve() {
+	for _, s := range l.order {
+		var strong, weak, common, extern *linkDef
+		for i := range s.defs {
+			d := &s.defs[i]
+			if (d.g != nil) != (s.defs[0].g != nil) {
+				l.errorf("%s is a variable in one module and a function in another (modules %d and %d)",
+					s.name, s.defs[0].mod, d.mod)
+				continue
+			}
+			switch {
+			case d.isDecl():
+				if extern == nil {
+					extern = d
+				}
+			case d.linkage() == ptx.LinkVisible:
+				if strong != nil {
+					l.errorf("%s is defined in modules %d and %d", s.name, strong.mod, d.mod)
+					continue
+				}
+				strong = d
+			case d.linkage() == ptx.LinkWeak:
+				if weak == nil {
+					weak = d
+				}
+			case d.linkage() == ptx.LinkCommon && d.g != nil:
+				if common == nil || varSize(d.g) > varSize(common.g) {
+					common = d
+				}
+			default:
+				l.errorf("%s in module %d has %s linkage, which only variables may have", s.name, d.mod, d.linkage())
+			}
+		}
+		switch {
+		case strong != nil:
+			s.winner = strong
+		case weak != nil:
+			s.winner = weak
+		case common != nil:
+			s.winner = common
+		default:
+			s.winner = extern
+		}
+		if s.winner == nil {
+			continue
+		}
+		if common != nil && s.winner != common && varSize(common.g) > varSize(s.winner.g) {
+			l.errorf(".common %s in module %d is larger than its definition in module %d", s.name, common.mod, s.winner.mod)
+		}
+		for i := range s.defs {
+			d := &s.defs[i]
+			if d.f != nil && s.winner.f != nil && !d.same(s.winner) &&
+				(len(d.f.Params) != len(s.winner.f.Params) || len(d.f.ReturnParams) != len(s.winner.f.ReturnParams)) {
+				l.errorf("%s in module %d does not match its signature in module %d", s.name, d.mod, s.winner.mod)
+			}
+		}
+	}
+}
+
+// varSize returns the size of a variable in bytes.
+func varSize(g *builder.Global) int {
+	n := g.Typ.BitWidth() / 8
+	switch g.Vec {
+	case ptx.V2:
+		n *= 2
+	case ptx.V4:
+		n *= 4
+	}
+	if g.Count > 0 {
+		n *= g.Count
+	}
+	return n
+}
+
+// rename gives each module's private symbols names that clash with no
+// shared symbol and no other module's private symbols. A new name is never
+// one that any module already uses, so it can be applied with the builder
+// rename functions in any order.
+func (l *linker) rename(mods []*builder.Module) {
+	used := make(map[string]bool)
+	for _, m := range mods {
+		for _, g := range m.Globals {
+			if g != nil {
+				used[g.Name] = true
+			}
+		}
+		for _, f := range m.Functions {
+			if f != nil {
+				used[f.Name] = true
+			}
+		}
+	}
+	claimed := make(map[string]bool)
+	for name := range l.syms {
+		claimed[name] = true
+	}
+	l.renames = make([]map[string]string, len(mods))
+	for i, m := range mods {
+		r := make(map[string]string)
+		l.renames[i] = r
+		private := func(name string) {
+			if _, done := r[name]; done {
+				return // a forward declaration and its definition
+			}
+			out := name
+			if claimed[name] {
+				for n := 1; used[out] || claimed[out]; n++ {
+					out = fmt.Sprintf("%s_%d", name, n)
+				}
+			}
+			claimed[out] = true
+			r[name] = out
+		}
+		for _, g := range m.Globals {
+			if g != nil && !shared(g.Linkage) {
+				private(g.Name)
+			}
+		}
+		for _, f := range m.Functions {
+			if f != nil && !shared(f.Linkage) {
+				private(f.Name)
+			}
+		}
+	}
+}
+
+// apply renames module i's private symbols, and the references to them,
+// with the builder rename functions. m must be a copy of the input.
+func (l *linker) apply(m *builder.Module, i int) {
+	isFunc := make(map[string]bool)
+	for _, f := range m.Functions {
+		if f != nil {
+			isFunc[f.Name] = true
+		}
+	}
+	// Sorted, so the errors, if any, come in a stable order.
+	olds := make([]string, 0, len(l.renames[i]))
+	for old, new := range l.renames[i] {
+		if old != new {
+			olds = append(olds, old)
+		}
+	}
+	slices.Sort(olds)
+	for _, old := range olds {
+		rename := m.RenameGlobal
+		if isFunc[old] {
+			rename = m.RenameFunction
+		}
+		if err := rename(old, l.renames[i][old]); err != nil {
+			l.errorf("module %d: %v", i, err)
+		}
+	}
+}
+
+// build fills out with the globals and functions of mods, which are the
+// copies of the inputs with their private symbols renamed.
+func (l *linker) build(mods []*builder.Module, out *builder.Module) {
+	for _, m := range mods {
+		for _, g := range m.Globals {
+			if g == nil {
+				continue
+			}
+			if !shared(g.Linkage) {
+				out.AddGlobal(g)
+				continue
+			}
+			s := l.syms[g.Name]
+			if !s.placed && s.winner.g == g {
+				out.AddGlobal(g)
+				s.placed = true
+			}
+		}
+	}
+
+	// Output positions of the functions, to know which calls come before
+	// the definition they reach.
+	l.pos = make(map[string]int)
+	n := 0
+	for _, m := range mods {
+		for _, f := range m.Functions {
+			if f == nil {
+				continue
+			}
+			if !shared(f.Linkage) || l.syms[f.Name].winner.f == f {
+				l.pos[f.Name] = n
+			}
+			n++
+		}
+	}
+
+	n = 0
+	for _, m := range mods {
+		for _, f := range m.Functions {
+			if f == nil {
+				continue
+			}
+			at := n
+			n++
+			if !shared(f.Linkage) {
+				out.AddFunction(f)
+				continue
+			}
+			s := l.syms[f.Name]
+			switch {
+			case s.winner.f == f:
+				out.AddFunction(f)
+				s.placed = true
+			case !s.placed && l.pos[f.Name] > at:
+				// Stand-in for a definition further down: declare it here.
+				decl := s.winner.f.Clone()
+				decl.IsDecl, decl.Blocks, decl.Registers = true, nil, nil
+				decl.Prototypes, decl.CallTargetLists, decl.Locals = nil, nil, nil
+				out.AddFunction(decl)
+				s.placed = true
+			}
+		}
+	}
+}
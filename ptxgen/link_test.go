@@ -0,0 +1,192 @@
+package ptxgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// linkInputs returns two modules that share helper, counter and buf, and
+// both have a private util and tbl.
+func linkInputs() (a, b *builder.Module) {
+	a = NewModule(ptx.ISA70, ptx.SM80)
+	a.AddGlobal(builder.NewGlobal("counter", ptx.Global, ptx.U32).WithLinkage(ptx.LinkWeak).WithInit(1))
+	a.AddGlobal(builder.NewGlobalArray("buf", ptx.Global, ptx.U32, 4).WithLinkage(ptx.LinkCommon))
+	a.AddGlobal(builder.NewGlobal("tbl", ptx.Global, ptx.U32))
+	a.ExternFunc("helper").AddParam(builder.NewParam("x", ptx.F32))
+	util := a.NewFunc("util")
+	v := util.NewReg("v", ptx.F32)
+	bb := util.NewBlock("")
+	bb.Add(builder.Ld(v, builder.Addr(builder.Sym("tbl"), 0)).Typed(ptx.F32).InSpace(ptx.Global))
+	bb.Add(builder.Call("helper", nil, []builder.Operand{v}))
+	bb.Add(builder.Ret())
+	ka := a.NewKernel("ka")
+	bb = ka.NewBlock("")
+	bb.Add(builder.Call("util", nil, nil))
+	bb.Add(builder.Ret())
+
+	b = NewModule(ptx.ISA80, ptx.SM86)
+	b.AddGlobal(builder.NewGlobal("counter", ptx.Global, ptx.U32).WithLinkage(ptx.LinkWeak).WithInit(2))
+	b.AddGlobal(builder.NewGlobalArray("buf", ptx.Global, ptx.U32, 16).WithLinkage(ptx.LinkCommon))
+	b.AddGlobal(builder.NewGlobal("tbl", ptx.Global, ptx.U32))
+	helper := b.NewFunc("helper")
+	helper.Linkage = ptx.LinkVisible
+	helper.AddParam(builder.NewParam("x", ptx.F32))
+	helper.NewBlock("").Add(builder.Ret())
+	util = b.NewFunc("util")
+	r := util.NewReg("r", ptx.U64)
+	bb = util.NewBlock("")
+	bb.Add(builder.Mov(r, builder.Sym("tbl")).Typed(ptx.U64))
+	bb.Add(builder.Ret())
+	kb := b.NewKernel("kb")
+	bb = kb.NewBlock("")
+	bb.Add(builder.Call("util", nil, nil))
+	bb.Add(builder.Ret())
+	return a, b
+}
+
+func TestLinkResolve(t *testing.T) {
+	a, b := linkInputs()
+	before := Build(a) + Build(b)
+	out, err := Link(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != ptx.ISA80 || out.Target != ptx.SM86 {
+		t.Errorf("result is PTX %s for %s, want 8.0 for sm_86", out.Version, out.Target)
+	}
+	src, err := BuildChecked(out)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	flat := strings.Join(strings.Fields(src), " ")
+	for _, want := range []string{
+		".weak .global .u32 counter = 1;", // the first .weak definition
+		".common .global .u32 buf[16];",   // the largest .common variable
+		".global .u32 tbl;",               // module a keeps its name
+		".global .u32 tbl_1;",             // module b's is renamed
+		"ld.global.f32 %v, [tbl];",
+		"mov.u64 %r, tbl_1;",
+		"call util;",
+		"call util_1;",
+	} {
+		if !strings.Contains(flat, want) {
+			t.Errorf("result does not contain %q:\n%s", want, src)
+		}
+	}
+	// The .extern declaration becomes a forward declaration of the
+	// .visible definition, which follows it.
+	decl := strings.Index(src, ".visible .func helper")
+	def := strings.LastIndex(src, ".visible .func helper")
+	if decl < 0 || decl == def || strings.Contains(src, ".extern .func helper") {
+		t.Errorf("want one declaration of helper before its definition:\n%s", src)
+	}
+	if n := strings.Count(src, ".global .u32 counter"); n != 1 {
+		t.Errorf("counter is defined %d times:\n%s", n, src)
+	}
+
+	if after := Build(a) + Build(b); after != before {
+		t.Errorf("Link modified its inputs")
+	}
+	inputs := make(map[interface{}]bool)
+	for _, m := range []*builder.Module{a, b} {
+		for _, g := range m.Globals {
+			inputs[g] = true
+		}
+		for _, f := range m.Functions {
+			inputs[f] = true
+			for _, p := range f.Params {
+				inputs[p] = true
+			}
+		}
+	}
+	for _, g := range out.Globals {
+		if inputs[g] {
+			t.Errorf("result shares global %s with an input", g.Name)
+		}
+	}
+	for _, f := range out.Functions {
+		if inputs[f] {
+			t.Errorf("result shares function %s with an input", f.Name)
+		}
+		for _, p := range f.Params {
+			if inputs[p] {
+				t.Errorf("result shares parameter %s of %s with an input", p.Name, f.Name)
+			}
+		}
+	}
+}
+
+func TestLinkExternOnly(t *testing.T) {
+	a := NewModule(ptx.ISA80, ptx.SM80)
+	a.AddGlobal(builder.NewGlobal("ext", ptx.Global, ptx.U32).WithLinkage(ptx.LinkExtern))
+	b := NewModule(ptx.ISA80, ptx.SM80)
+	b.AddGlobal(builder.NewGlobal("ext", ptx.Global, ptx.U32).WithLinkage(ptx.LinkExtern))
+	out, err := Link(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Globals) != 1 || out.Globals[0].Linkage != ptx.LinkExtern {
+		t.Errorf("want one .extern declaration of ext, got %d globals", len(out.Globals))
+	}
+}
+
+// TestLinkRenameAvoidsExisting checks that a renamed symbol does not take
+// a name another module already uses.
+func TestLinkRenameAvoidsExisting(t *testing.T) {
+	a := NewModule(ptx.ISA80, ptx.SM80)
+	a.AddGlobal(builder.NewGlobal("t", ptx.Global, ptx.U32))
+	b := NewModule(ptx.ISA80, ptx.SM80)
+	b.AddGlobal(builder.NewGlobal("t", ptx.Global, ptx.U32))
+	b.AddGlobal(builder.NewGlobal("t_1", ptx.Global, ptx.U32))
+	c := NewModule(ptx.ISA80, ptx.SM80)
+	c.AddGlobal(builder.NewGlobal("t_2", ptx.Global, ptx.U32).WithLinkage(ptx.LinkVisible))
+	out, err := Link(a, b, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, g := range out.Globals {
+		names = append(names, g.Name)
+	}
+	if got := strings.Join(names, " "); got != "t t_3 t_1 t_2" {
+		t.Errorf("globals %q, want %q", got, "t t_3 t_1 t_2")
+	}
+}
+
+func TestLinkConflicts(t *testing.T) {
+	visible := func(target ptx.Target) *builder.Module {
+		m := NewModule(ptx.ISA80, target)
+		m.AddGlobal(builder.NewGlobal("g", ptx.Global, ptx.U32).WithLinkage(ptx.LinkVisible))
+		return m
+	}
+	narrow := NewModule(ptx.ISA80, ptx.SM80)
+	narrow.AddressSize = 32
+	fn := NewModule(ptx.ISA80, ptx.SM80)
+	fn.NewFunc("g").Linkage = ptx.LinkWeak
+
+	for _, tt := range []struct {
+		name string
+		mods []*builder.Module
+		want []string
+	}{
+		{"two definitions", []*builder.Module{visible(ptx.SM80), visible(ptx.SM80)}, []string{"g is defined in modules 0 and 1"}},
+		{"arch-specific", []*builder.Module{visible(ptx.SM90a), NewModule(ptx.ISA80, ptx.SM100)}, []string{"module 0 targets sm_90a, which cannot be combined with sm_100"}},
+		{"address size", []*builder.Module{NewModule(ptx.ISA80, ptx.SM80), narrow}, []string{"module 1 has address size 32"}},
+		{"variable and function", []*builder.Module{visible(ptx.SM80), fn}, []string{"g is a variable in one module and a function in another"}},
+		{"several", []*builder.Module{visible(ptx.SM90a), visible(ptx.SM100)}, []string{"defined in modules 0 and 1", "cannot be combined"}},
+	} {
+		_, err := Link(tt.mods...)
+		if err == nil {
+			t.Errorf("%s: Link succeeded", tt.name)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: error %q does not mention %q", tt.name, err, want)
+			}
+		}
+	}
+}
@@ -13,4 +13,15 @@ func NewModule(version ptx.ISAVersion, target ptx.Target) *builder.Module {
 
 func Build(mod *builder.Module) string {
     return codegen.Emit(mod)
-}
\ No newline at end of file
+}
+
+// BuildChecked is like Build but returns a *codegen.EmitError listing every
+// problem in mod instead of emitting invalid PTX.
+func BuildChecked(mod *builder.Module) (string, error) {
+    return codegen.EmitChecked(mod)
+}
+
+// BuildWithOptions is like Build with the layout given by opts.
+func BuildWithOptions(mod *builder.Module, opts codegen.EmitOptions) string {
+    return codegen.EmitWithOptions(mod, opts)
+}
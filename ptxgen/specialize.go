@@ -0,0 +1,233 @@
+package ptxgen
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/arc-language/ptx-gen/builder"
+)
+
+// LibraryVersion identifies the generator library. It is part of every
+// specialization key, so instances are never shared across versions that
+// may emit different code.
+const LibraryVersion = "0.1.0"
+
+// Template is a kernel generator specialized by a parameter struct P, such
+// as tile sizes, element types and unroll factors. Each distinct parameter
+// value is built once; later requests for it return the cached module.
+// A Template is safe for use by multiple goroutines.
+//
+//	type GemmParams struct {
+//		TileM, TileN, TileK int
+//		Elem                ptx.Type
+//	}
+//	var gemm = ptxgen.NewTemplate("gemm", func(p GemmParams) (*builder.Module, error) {
+//		mod := builder.NewModule(ptx.ISA80, ptx.SM90a)
+//		k := mod.NewKernel("gemm")
+//		...
+//		return mod, nil
+//	})
+//
+//	mod, err := gemm.Instantiate(GemmParams{128, 128, 32, ptx.F16}) // .entry gemm_3f9c0a1b7d2e4c68
+type Template[P any] struct {
+	name string
+	gen  func(p P) (*builder.Module, error)
+
+	mu        sync.Mutex
+	instances map[string]*instance
+}
+
+type instance struct {
+	once sync.Once
+	mod  *builder.Module
+	err  error
+}
+
+// NewTemplate returns a template named name that builds its instances
+// with gen. gen must be deterministic: the same parameters always give the
+// same module.
+func NewTemplate[P any](name string, gen func(p P) (*builder.Module, error)) *Template[P] {
+	return &Template[P]{name: name, gen: gen, instances: make(map[string]*instance)}
+}
+
+// Key returns the specialization key of p: a hex SHA-256 of the library
+// version, the template name, the parameter type and the value of every
+// field of p, exported or not. Pointers are followed, and maps are hashed
+// regardless of iteration order. It fails for parameters holding
+// functions, channels, unsafe pointers or cycles, which have no stable
+// content.
+func (t *Template[P]) Key(p P) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", LibraryVersion, t.name, reflect.TypeOf(&p).Elem())
+	if err := hashValue(h, reflect.ValueOf(&p).Elem(), nil); err != nil {
+		return "", fmt.Errorf("ptxgen: %s: cannot hash parameters: %v", t.name, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashValue writes an unambiguous encoding of v to h: each value is
+// preceded by its kind, and strings, names, slices and maps by their
+// length. path holds the pointers being followed, to catch cycles.
+func hashValue(h hash.Hash, v reflect.Value, path []uintptr) error {
+	var buf [8]byte
+	word := func(n uint64) {
+		binary.LittleEndian.PutUint64(buf[:], n)
+		h.Write(buf[:])
+	}
+	str := func(s string) {
+		word(uint64(len(s)))
+		h.Write([]byte(s))
+	}
+	word(uint64(v.Kind()))
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			word(1)
+		} else {
+			word(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		word(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		word(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		word(math.Float64bits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		word(math.Float64bits(real(v.Complex())))
+		word(math.Float64bits(imag(v.Complex())))
+	case reflect.String:
+		str(v.String())
+	case reflect.Array, reflect.Slice:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			word(math.MaxUint64)
+			return nil
+		}
+		word(uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := hashValue(h, v.Index(i), path); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			str(v.Type().Field(i).Name)
+			if err := hashValue(h, v.Field(i), path); err != nil {
+				return err
+			}
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			word(0)
+			return nil
+		}
+		if slices.Contains(path, v.Pointer()) {
+			return fmt.Errorf("cycle through %s", v.Type())
+		}
+		word(1)
+		return hashValue(h, v.Elem(), append(path, v.Pointer()))
+	case reflect.Interface:
+		if v.IsNil() {
+			word(0)
+			return nil
+		}
+		e := v.Elem()
+		str(e.Type().String())
+		return hashValue(h, e, path)
+	case reflect.Map:
+		if v.IsNil() {
+			word(math.MaxUint64)
+			return nil
+		}
+		// Hash each entry on its own and sort the results, so the order
+		// of iteration does not matter.
+		entries := make([][]byte, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			eh := sha256.New()
+			if err := hashValue(eh, iter.Key(), path); err != nil {
+				return err
+			}
+			if err := hashValue(eh, iter.Value(), path); err != nil {
+				return err
+			}
+			entries = append(entries, eh.Sum(nil))
+		}
+		slices.SortFunc(entries, bytes.Compare)
+		word(uint64(len(entries)))
+		for _, e := range entries {
+			h.Write(e)
+		}
+	default:
+		return fmt.Errorf("%s has no stable value", v.Type())
+	}
+	return nil
+}
+
+// KernelName returns the name kernel has in the instance for p, for
+// looking it up on the host: kernel_<first 16 hex digits of the key>.
+func (t *Template[P]) KernelName(kernel string, p P) (string, error) {
+	key, err := t.Key(p)
+	if err != nil {
+		return "", err
+	}
+	return mangle(kernel, key), nil
+}
+
+func mangle(name, key string) string {
+	return name + "_" + key[:16]
+}
+
+// Instantiate returns the module for p, building it on first use. Every
+// kernel definition in it is renamed as KernelName describes, so instances
+// for different parameters can be linked or loaded side by side. Errors are
+// cached like modules. The returned module is shared by all callers and
+// must not be modified.
+func (t *Template[P]) Instantiate(p P) (*builder.Module, error) {
+	key, err := t.Key(p)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	in, ok := t.instances[key]
+	if !ok {
+		in = new(instance)
+		t.instances[key] = in
+	}
+	t.mu.Unlock()
+
+	in.once.Do(func() {
+		// Stays set if gen panics, so later callers see an error rather
+		// than a nil module.
+		in.err = fmt.Errorf("ptxgen: %s: generator panicked", t.name)
+		mod, err := t.gen(p)
+		switch {
+		case err != nil:
+			in.err = fmt.Errorf("ptxgen: %s: %w", t.name, err)
+		case mod == nil:
+			in.err = fmt.Errorf("ptxgen: %s: generator returned no module", t.name)
+		default:
+			var kernels []string
+			for _, f := range mod.Functions {
+				if isKernel(f) {
+					kernels = append(kernels, f.Name)
+				}
+			}
+			for _, name := range kernels {
+				if err := mod.RenameFunction(name, mangle(name, key)); err != nil {
+					in.err = fmt.Errorf("ptxgen: %s: %w", t.name, err)
+					return
+				}
+			}
+			in.mod, in.err = mod, nil
+		}
+	})
+	return in.mod, in.err
+}
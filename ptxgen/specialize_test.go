@@ -0,0 +1,223 @@
+package ptxgen
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+type tileParams struct {
+	M, N  int
+	Elem  ptx.Type
+	Names map[string]int
+	Scale *float64
+	tag   string
+}
+
+// tileTemplate builds a kernel "tile" calling a helper function, and
+// counts the calls to its generator.
+func tileTemplate(calls *atomic.Int32) *Template[tileParams] {
+	return NewTemplate("tile", func(p tileParams) (*builder.Module, error) {
+		calls.Add(1)
+		mod := NewModule(ptx.ISA80, ptx.SM80)
+		helper := mod.NewFunc("helper")
+		helper.NewBlock("").Add(builder.Ret())
+		k := mod.NewKernel("tile")
+		k.AddDirective(builder.ReqNTid(p.M, p.N, 1))
+		bb := k.NewBlock("")
+		bb.Add(builder.Call("helper", nil, nil))
+		bb.Add(builder.Ret())
+		return mod, nil
+	})
+}
+
+func TestTemplateKey(t *testing.T) {
+	var calls atomic.Int32
+	tmpl := tileTemplate(&calls)
+	key := func(p tileParams) string {
+		t.Helper()
+		k, err := tmpl.Key(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	one, also := 1.0, 1.0
+	base := tileParams{M: 128, N: 64, Elem: ptx.F16, Names: map[string]int{"a": 1, "b": 2, "c": 3}, Scale: &one, tag: "x"}
+	k := key(base)
+	if len(k) != 64 || strings.Trim(k, "0123456789abcdef") != "" {
+		t.Fatalf("key %q is not a hex SHA-256", k)
+	}
+
+	// Equal values give equal keys, whatever the map insertion order or
+	// pointer identity.
+	same := base
+	same.Names = make(map[string]int)
+	for _, n := range []string{"c", "a", "b"} {
+		same.Names[n] = base.Names[n]
+	}
+	same.Scale = &also
+	for i := 0; i < 10; i++ {
+		if got := key(same); got != k {
+			t.Fatalf("equal parameters give keys %s and %s", got, k)
+		}
+	}
+
+	two := 2.0
+	for name, change := range map[string]func(p *tileParams){
+		"int field":        func(p *tileParams) { p.M = 64 },
+		"type field":       func(p *tileParams) { p.Elem = ptx.F32 },
+		"map value":        func(p *tileParams) { p.Names = map[string]int{"a": 1, "b": 2, "c": 4} },
+		"map key":          func(p *tileParams) { p.Names = map[string]int{"a": 1, "b": 2, "d": 3} },
+		"pointed-to value": func(p *tileParams) { p.Scale = &two },
+		"nil pointer":      func(p *tileParams) { p.Scale = nil },
+		"unexported field": func(p *tileParams) { p.tag = "y" },
+	} {
+		p := base
+		change(&p)
+		if key(p) == k {
+			t.Errorf("%s: changed parameters give the same key", name)
+		}
+	}
+	if other, _ := NewTemplate("other", tmpl.gen).Key(base); other == k {
+		t.Errorf("templates with different names give the same key")
+	}
+}
+
+func TestTemplateKeyErrors(t *testing.T) {
+	type node struct {
+		Next *node
+	}
+	cycle := &node{}
+	cycle.Next = cycle
+	for _, tt := range []struct {
+		name string
+		key  func() (string, error)
+	}{
+		{"func", func() (string, error) {
+			return NewTemplate("f", func(func()) (*builder.Module, error) { return nil, nil }).Key(func() {})
+		}},
+		{"chan", func() (string, error) {
+			return NewTemplate("c", func(chan int) (*builder.Module, error) { return nil, nil }).Key(make(chan int))
+		}},
+		{"cycle", func() (string, error) {
+			return NewTemplate("n", func(*node) (*builder.Module, error) { return nil, nil }).Key(cycle)
+		}},
+	} {
+		if _, err := tt.key(); err == nil || !strings.Contains(err.Error(), "cannot hash parameters") {
+			t.Errorf("%s: error %v, want one about hashing", tt.name, err)
+		}
+	}
+}
+
+func TestTemplateInstantiate(t *testing.T) {
+	var calls atomic.Int32
+	tmpl := tileTemplate(&calls)
+	p := tileParams{M: 128, N: 1, Elem: ptx.F32}
+	mod, err := tmpl.Instantiate(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := tmpl.KernelName("tile", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := tmpl.Key(p)
+	if name != "tile_"+key[:16] {
+		t.Errorf("KernelName is %s, want tile_%s", name, key[:16])
+	}
+	src := Build(mod)
+	if !strings.Contains(src, ".entry "+name) || !strings.Contains(src, ".func helper") {
+		t.Errorf("want kernel %s and an unrenamed helper:\n%s", name, src)
+	}
+
+	again, err := tmpl.Instantiate(p)
+	if err != nil || again != mod || calls.Load() != 1 {
+		t.Errorf("second Instantiate: module %p (first %p), error %v, %d generator calls; want the cached module",
+			again, mod, err, calls.Load())
+	}
+	p.N = 2
+	if other, _ := tmpl.Instantiate(p); other == mod || calls.Load() != 2 {
+		t.Errorf("different parameters did not build a new module")
+	}
+}
+
+func TestTemplateCachedErrors(t *testing.T) {
+	fail := errors.New("unsupported tile")
+	calls := 0
+	tmpl := NewTemplate("bad", func(n int) (*builder.Module, error) {
+		calls++
+		switch n {
+		case 0:
+			return nil, fail
+		case 1:
+			return nil, nil
+		}
+		panic("boom")
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := tmpl.Instantiate(0); !errors.Is(err, fail) || !strings.HasPrefix(err.Error(), "ptxgen: bad: ") {
+			t.Errorf("error %v, want one wrapping %v", err, fail)
+		}
+		if _, err := tmpl.Instantiate(1); err == nil || !strings.Contains(err.Error(), "generator returned no module") {
+			t.Errorf("error %v, want one about the missing module", err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("generator called %d times, want once per parameter", calls)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("first Instantiate after a panic did not panic")
+			}
+		}()
+		tmpl.Instantiate(2)
+	}()
+	if mod, err := tmpl.Instantiate(2); mod != nil || err == nil || !strings.Contains(err.Error(), "generator panicked") {
+		t.Errorf("after a panic: module %v, error %v; want a cached error", mod, err)
+	}
+}
+
+// TestTemplateConcurrent is meant to be run with -race.
+func TestTemplateConcurrent(t *testing.T) {
+	var calls atomic.Int32
+	tmpl := tileTemplate(&calls)
+	const params, workers = 4, 32
+	mods := make([][]*builder.Module, params)
+	for i := range mods {
+		mods[i] = make([]*builder.Module, workers)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		for i := 0; i < params; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				mod, err := tmpl.Instantiate(tileParams{M: 32 << i, N: 1, tag: fmt.Sprint(i)})
+				if err != nil {
+					t.Error(err)
+				}
+				mods[i][w] = mod
+			}()
+		}
+	}
+	wg.Wait()
+	if n := calls.Load(); n != params {
+		t.Errorf("generator called %d times, want %d", n, params)
+	}
+	for i := range mods {
+		for w := range mods[i] {
+			if mods[i][w] == nil || mods[i][w] != mods[i][0] {
+				t.Fatalf("parameters %d: workers got different modules", i)
+			}
+		}
+	}
+}
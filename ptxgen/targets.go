@@ -0,0 +1,158 @@
+package ptxgen
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+// TargetContext tells a generator which target it is building for and what
+// that target supports, so one kernel description can pick the best
+// instructions for each architecture.
+type TargetContext struct {
+	Target  ptx.Target
+	Version ptx.ISAVersion // lowest PTX ISA version that supports Target
+}
+
+// NewTargetContext returns the context for building for t.
+func NewTargetContext(t ptx.Target) *TargetContext {
+	return &TargetContext{Target: t, Version: minISA(t)}
+}
+
+// SM returns the architecture number: 80 for sm_80, 90 for sm_90a.
+func (c *TargetContext) SM() int {
+	switch c.Target {
+	case ptx.SM50:
+		return 50
+	case ptx.SM52:
+		return 52
+	case ptx.SM53:
+		return 53
+	case ptx.SM60:
+		return 60
+	case ptx.SM61:
+		return 61
+	case ptx.SM62:
+		return 62
+	case ptx.SM70:
+		return 70
+	case ptx.SM72:
+		return 72
+	case ptx.SM75:
+		return 75
+	case ptx.SM80:
+		return 80
+	case ptx.SM86:
+		return 86
+	case ptx.SM87:
+		return 87
+	case ptx.SM89:
+		return 89
+	case ptx.SM90, ptx.SM90a:
+		return 90
+	case ptx.SM100:
+		return 100
+	case ptx.SM101:
+		return 101
+	case ptx.SM120:
+		return 120
+	}
+	return 0
+}
+
+// AtLeast reports whether the target is sm_<sm> or newer.
+func (c *TargetContext) AtLeast(sm int) bool {
+	return c.SM() >= sm
+}
+
+// HasAsyncCopy reports support for cp.async (sm_80+).
+func (c *TargetContext) HasAsyncCopy() bool {
+	return c.AtLeast(80)
+}
+
+// HasCluster reports support for thread block clusters, distributed
+// shared memory and the cluster directives (sm_90+).
+func (c *TargetContext) HasCluster() bool {
+	return c.AtLeast(90)
+}
+
+// HasTMA reports support for the tensor memory accelerator:
+// cp.async.bulk.tensor with tensor maps (sm_90+).
+func (c *TargetContext) HasTMA() bool {
+	return c.AtLeast(90)
+}
+
+// HasWGMMA reports support for warpgroup MMA, which exists only on the
+// arch-specific sm_90a target.
+func (c *TargetContext) HasWGMMA() bool {
+	return c.Target == ptx.SM90a
+}
+
+// HasTcgen05 reports support for the 5th-generation tensor core
+// instructions. Like wgmma, they exist only on arch-specific targets
+// (sm_100a, sm_101a), which ptx.Target does not have yet, so it is always
+// false: code for sm_100 or sm_101 using them would not load.
+func (c *TargetContext) HasTcgen05() bool {
+	return false
+}
+
+// minISA returns the lowest PTX ISA version that supports t.
+func minISA(t ptx.Target) ptx.ISAVersion {
+	switch t {
+	case ptx.SM72:
+		return ptx.ISAVersion{Major: 6, Minor: 1}
+	case ptx.SM75:
+		return ptx.ISA63
+	case ptx.SM80:
+		return ptx.ISA70
+	case ptx.SM86:
+		return ptx.ISA71
+	case ptx.SM87:
+		return ptx.ISA74
+	case ptx.SM89, ptx.SM90:
+		return ptx.ISA78
+	case ptx.SM90a:
+		return ptx.ISA80
+	case ptx.SM100, ptx.SM101:
+		return ptx.ISA86
+	case ptx.SM120:
+		return ptx.ISA87
+	}
+	return ptx.ISA60
+}
+
+// BuildTargets calls gen once for each target with a fresh module for
+// that target, at the lowest ISA version supporting it, and returns the
+// modules by target. gen may raise the module's Version if it needs newer
+// instructions. Every error is returned, joined into one.
+//
+//	mods, err := ptxgen.BuildTargets(func(t *ptxgen.TargetContext, mod *builder.Module) error {
+//		k := mod.NewKernel("gemm")
+//		if t.HasWGMMA() {
+//			// wgmma path
+//		} else {
+//			// mma.sync path
+//		}
+//		return nil
+//	}, ptx.SM80, ptx.SM90a, ptx.SM100)
+func BuildTargets(gen func(t *TargetContext, mod *builder.Module) error, targets ...ptx.Target) (map[ptx.Target]*builder.Module, error) {
+	mods := make(map[ptx.Target]*builder.Module, len(targets))
+	var errs []error
+	for _, t := range targets {
+		if _, ok := mods[t]; ok {
+			continue
+		}
+		ctx := NewTargetContext(t)
+		mod := builder.NewModule(ctx.Version, t)
+		if err := gen(ctx, mod); err != nil {
+			errs = append(errs, fmt.Errorf("ptxgen: %s: %w", t, err))
+		}
+		mods[t] = mod
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return mods, nil
+}
@@ -0,0 +1,89 @@
+package ptxgen
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/arc-language/ptx-gen/builder"
+	"github.com/arc-language/ptx-gen/ptx"
+)
+
+func TestTargetContext(t *testing.T) {
+	for _, tt := range []struct {
+		target                     ptx.Target
+		sm                         int
+		isa                        ptx.ISAVersion
+		async, cluster, tma, wgmma bool
+	}{
+		{ptx.SM50, 50, ptx.ISA60, false, false, false, false},
+		{ptx.SM52, 52, ptx.ISA60, false, false, false, false},
+		{ptx.SM53, 53, ptx.ISA60, false, false, false, false},
+		{ptx.SM60, 60, ptx.ISA60, false, false, false, false},
+		{ptx.SM61, 61, ptx.ISA60, false, false, false, false},
+		{ptx.SM62, 62, ptx.ISA60, false, false, false, false},
+		{ptx.SM70, 70, ptx.ISA60, false, false, false, false},
+		{ptx.SM72, 72, ptx.ISAVersion{Major: 6, Minor: 1}, false, false, false, false},
+		{ptx.SM75, 75, ptx.ISA63, false, false, false, false},
+		{ptx.SM80, 80, ptx.ISA70, true, false, false, false},
+		{ptx.SM86, 86, ptx.ISA71, true, false, false, false},
+		{ptx.SM87, 87, ptx.ISA74, true, false, false, false},
+		{ptx.SM89, 89, ptx.ISA78, true, false, false, false},
+		{ptx.SM90, 90, ptx.ISA78, true, true, true, false},
+		{ptx.SM90a, 90, ptx.ISA80, true, true, true, true},
+		{ptx.SM100, 100, ptx.ISA86, true, true, true, false},
+		{ptx.SM101, 101, ptx.ISA86, true, true, true, false},
+		{ptx.SM120, 120, ptx.ISA87, true, true, true, false},
+	} {
+		c := NewTargetContext(tt.target)
+		if c.Target != tt.target || c.SM() != tt.sm || c.Version != tt.isa {
+			t.Errorf("%s: SM %d, version %s; want %d and %s", tt.target, c.SM(), c.Version, tt.sm, tt.isa)
+		}
+		if !c.AtLeast(tt.sm) || c.AtLeast(tt.sm+1) {
+			t.Errorf("%s: AtLeast disagrees with SM %d", tt.target, tt.sm)
+		}
+		if c.HasAsyncCopy() != tt.async || c.HasCluster() != tt.cluster || c.HasTMA() != tt.tma ||
+			c.HasWGMMA() != tt.wgmma || c.HasTcgen05() {
+			t.Errorf("%s: async %v cluster %v tma %v wgmma %v tcgen05 %v; want %v %v %v %v false", tt.target,
+				c.HasAsyncCopy(), c.HasCluster(), c.HasTMA(), c.HasWGMMA(), c.HasTcgen05(),
+				tt.async, tt.cluster, tt.tma, tt.wgmma)
+		}
+	}
+}
+
+func TestBuildTargets(t *testing.T) {
+	calls := 0
+	mods, err := BuildTargets(func(c *TargetContext, mod *builder.Module) error {
+		calls++
+		if mod.Target != c.Target || mod.Version != c.Version {
+			t.Errorf("%s: module is PTX %s for %s", c.Target, mod.Version, mod.Target)
+		}
+		mod.NewKernel("k").NewBlock("").Add(builder.Ret())
+		return nil
+	}, ptx.SM80, ptx.SM90a, ptx.SM80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || len(mods) != 2 || mods[ptx.SM80] == nil || mods[ptx.SM90a] == nil {
+		t.Errorf("%d calls, modules %v; want one for each of sm_80 and sm_90a", calls, mods)
+	}
+
+	fail := errors.New("no kernel")
+	_, err = BuildTargets(func(c *TargetContext, mod *builder.Module) error {
+		if c.AtLeast(90) {
+			return fail
+		}
+		return nil
+	}, ptx.SM80, ptx.SM90, ptx.SM100)
+	if !errors.Is(err, fail) {
+		t.Fatalf("error %v, want one wrapping %v", err, fail)
+	}
+	for _, want := range []string{"ptxgen: sm_90: no kernel", "ptxgen: sm_100: no kernel"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "sm_80") {
+		t.Errorf("error %q mentions sm_80, which succeeded", err)
+	}
+}